@@ -0,0 +1,103 @@
+package otgorm
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var insertRegexp = regexp.MustCompile(`(?is)^\s*INSERT\s+INTO\s+\S+\s*\(([^)]*)\)\s*VALUES\s*`)
+
+// hasAllowlist reports whether only allowed values are interpolated
+func (o options) hasAllowlist() bool {
+	return o.allowedColumns != nil || o.allowedParams != nil
+}
+
+// valueAllowed reports whether value of the column bound to the n-th placeholder is interpolated
+func (o options) valueAllowed(column string, n int) bool {
+	return o.allowedParams[n] || (column != "" && o.allowedColumns[strings.ToLower(column)])
+}
+
+// maskInValues renders IN (...) list of not allowed values
+func maskInValues(n int, opts options) string {
+	if opts.maxInValues > 0 && n > opts.maxInValues {
+		return fmt.Sprintf("… %d values", n)
+	}
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// insertColumns returns columns of INSERT INTO table (columns) VALUES (...), (...)
+// and the start and the end of the values tuples
+func insertColumns(query string) ([]string, int, int) {
+	match := insertRegexp.FindStringSubmatchIndex(query)
+	if match == nil {
+		return nil, -1, -1
+	}
+	columns := strings.Split(query[match[2]:match[3]], ",")
+	for i, column := range columns {
+		columns[i] = unquoteIdent(strings.TrimSpace(column))
+	}
+
+	// tuples end at the first character after closing parenthesis which isn't a comma
+	end, depth := match[1], 0
+	for ; end < len(query); end++ {
+		c := query[end]
+		if c == '(' {
+			depth++
+		} else if c == ')' {
+			depth--
+		} else if depth == 0 && c != ',' && c != ' ' && c != '\n' && c != '\t' && c != '\r' {
+			break
+		}
+	}
+	return columns, match[1], end
+}
+
+// placeholderColumn returns column compared with placeholder or IN list at i, e.g. "products"."code" = $1.
+// It returns empty string if the column can't be recognized, e.g. for expressions
+func placeholderColumn(query string, i int) string {
+	j := skipSpacesBack(query, i-1)
+	// comparison operator
+	for j >= 0 && strings.IndexByte("=<>!", query[j]) >= 0 {
+		j--
+	}
+	j = skipSpacesBack(query, j)
+	// LIKE, ILIKE and NOT of NOT IN or NOT LIKE
+	for _, keyword := range []string{"LIKE", "ILIKE", "NOT"} {
+		if start := j - len(keyword) + 1; start >= 0 && strings.EqualFold(query[start:j+1], keyword) &&
+			(start == 0 || !isIdentChar(query[start-1])) {
+			j = skipSpacesBack(query, start-1)
+		}
+	}
+
+	// the last component of possibly quoted and qualified column
+	end := j + 1
+	switch {
+	case j >= 0 && (query[j] == '"' || query[j] == '`' || query[j] == ']'):
+		open := map[byte]byte{'"': '"', '`': '`', ']': '['}[query[j]]
+		start := strings.LastIndexByte(query[:j], open)
+		if start < 0 {
+			return ""
+		}
+		return strings.ToLower(query[start+1 : j])
+	default:
+		for j >= 0 && isIdentChar(query[j]) {
+			j--
+		}
+		return strings.ToLower(query[j+1 : end])
+	}
+}
+
+func unquoteIdent(ident string) string {
+	if i := strings.LastIndexByte(ident, '.'); i >= 0 {
+		ident = ident[i+1:]
+	}
+	return strings.ToLower(strings.Trim(ident, "\"`[]"))
+}
+
+func skipSpacesBack(query string, j int) int {
+	for j >= 0 && (query[j] == ' ' || query[j] == '\t' || query[j] == '\n' || query[j] == '\r') {
+		j--
+	}
+	return j
+}
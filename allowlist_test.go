@@ -0,0 +1,42 @@
+package otgorm
+
+import "testing"
+
+func TestInterpolateAllowlist(t *testing.T) {
+	opts := defaultOptions()
+	WithAllowedColumns("code", "status")(&opts)
+	WithAllowedParams(3)(&opts)
+
+	cases := []struct {
+		query    string
+		vars     []interface{}
+		expected string
+	}{
+		{
+			query:    `SELECT * FROM "products" WHERE ("products"."code" = $1 AND email = $2 AND age > $3)`,
+			vars:     []interface{}{"L1212", "john@example.com", 18},
+			expected: `SELECT * FROM "products" WHERE ("products"."code" = 'L1212' AND email = ? AND age > 18)`,
+		},
+		{
+			query:    "SELECT * FROM `products` WHERE (`status` NOT IN (?,?,?) AND name LIKE ? AND lower(code) = ?)",
+			vars:     []interface{}{"a", "b", "c", "john%", "l1212"},
+			expected: "SELECT * FROM `products` WHERE (`status` NOT IN ('a','b','c') AND name LIKE ? AND lower(code) = ?)",
+		},
+		{
+			query:    `SELECT * FROM "products" WHERE (email IN ($1,$2))`,
+			vars:     []interface{}{"a", "b"},
+			expected: `SELECT * FROM "products" WHERE (email IN (?,?))`,
+		},
+		{
+			query:    `INSERT INTO "products" ("email","code") VALUES ($1,$2),($4,$5) ON CONFLICT DO UPDATE SET "email" = $3`,
+			vars:     []interface{}{"a", "L1", "b", "c", "L2"},
+			expected: `INSERT INTO "products" ("email","code") VALUES (?,'L1'),(?,'L2') ON CONFLICT DO UPDATE SET "email" = 'b'`,
+		},
+	}
+
+	for _, c := range cases {
+		if actual := interpolate(c.query, c.vars, opts); actual != c.expected {
+			t.Errorf("interpolate(%q) should be %q but it's %q", c.query, c.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package otgorm
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"runtime/debug"
+	"strings"
+	"unicode/utf8"
+)
+
+// modulePath is the path otgorm is looked up by in build info
+const modulePath = "github.com/smacker/opentracing-gorm"
+
+// Version is the version of otgorm stamped into application_name by WithApplicationName. It's read from build info
+// of the binary, devel if otgorm isn't built as a versioned module, and can be set with -ldflags -X
+var Version = moduleVersion()
+
+// moduleVersion returns version of otgorm module of the binary, replaced module version if it's replaced
+func moduleVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "devel"
+	}
+	module := &info.Main
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			module = dep
+			break
+		}
+	}
+	if module.Path != modulePath {
+		return "devel"
+	}
+	if module.Replace != nil && module.Replace.Version != "" {
+		module = module.Replace
+	}
+	if module.Version == "" || module.Version == "(devel)" {
+		return "devel"
+	}
+	return module.Version
+}
+
+// maxApplicationName is the length Postgres truncates application_name to
+const maxApplicationName = 63
+
+// errNoExec is reported when the connection of the wrapped driver can't execute statements
+var errNoExec = errors.New("otgorm: connection doesn't support ExecContext, application_name isn't set")
+
+// applicationName returns application_name of the service with otgorm version and suffix, e.g. trace id
+func applicationName(service, suffix string) string {
+	name := service + " otgorm/" + Version
+	if suffix != "" {
+		name += " " + suffix
+	}
+	if len(name) > maxApplicationName {
+		// cut on a rune boundary, so the name stays valid UTF-8
+		n := maxApplicationName
+		for n > 0 && !utf8.RuneStart(name[n]) {
+			n--
+		}
+		name = name[:n]
+	}
+	return name
+}
+
+// applicationNameStatement returns SET statement of application_name, SET LOCAL if local
+func applicationNameStatement(name string, local bool) string {
+	set := "SET "
+	if local {
+		set = "SET LOCAL "
+	}
+	return set + "application_name = '" + strings.Replace(name, "'", "''", -1) + "'"
+}
+
+// setConnApplicationName sets application_name of the new connection of WrapDriver
+func (c *callbacks) setConnApplicationName(conn driver.Conn) {
+	execer, ok := conn.(driver.ExecerContext)
+	if !ok {
+		c.status.reportError("application name", errNoExec)
+		return
+	}
+	statement := applicationNameStatement(applicationName(c.config().applicationName, ""), false)
+	if _, err := execer.ExecContext(context.Background(), statement, nil); err != nil {
+		c.status.reportError("application name", err)
+	}
+}
@@ -0,0 +1,86 @@
+package otgorm
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// AuditEvent describes write query executed through the callbacks
+type AuditEvent struct {
+	// Actor is returned by the actor function of WithAuditSink for the context passed to SetSpanToGorm
+	Actor        string
+	Table        string
+	Operation    string
+	RowsAffected int64
+	Time         time.Time
+	// TraceID is the trace id of the span set by SetSpanToGorm if its tracer exposes it, empty for untraced queries
+	TraceID string
+	// Err is redacted as db.err is in no values mode
+	Err error
+}
+
+// writeOperations are operations reported to the audit sink
+var writeOperations = map[string]bool{
+	"INSERT": true,
+	"UPDATE": true,
+	"DELETE": true,
+}
+
+// WithAuditSink calls sink with AuditEvent of every INSERT, UPDATE and DELETE executed through the callbacks,
+// traced or not. actor returns the actor of the event from the context passed to SetSpanToGorm, it may be nil.
+// sink is called synchronously after the query, hand events off to a queue if delivering them is slow
+func WithAuditSink(sink func(AuditEvent), actor func(ctx context.Context) string) Option {
+	return func(o *options) {
+		o.auditSink = sink
+		o.auditActor = actor
+	}
+}
+
+// emitAudit reports write query of the scope to the audit sink
+func (c *callbacks) emitAudit(scope *gorm.Scope, operation string, cfg *config) {
+	if operation == "" {
+		operation = sqlOperation(scope.SQL)
+	}
+	if !writeOperations[operation] {
+		return
+	}
+	event := AuditEvent{
+		Table:        scope.TableName(),
+		Operation:    operation,
+		RowsAffected: scope.DB().RowsAffected,
+		Time:         time.Now(),
+		Err:          redactedError(scope.DB().Error, cfg.options),
+	}
+	if ctx, ok := contextFromScope(scope); ok && cfg.auditActor != nil {
+		event.Actor = cfg.auditActor(ctx)
+	}
+	if val, ok := scope.Get(ParentSpanGormKey); ok {
+		if parent, _, ok := spanParent(val); ok {
+			event.TraceID = cfg.traceID(parent)
+		}
+	}
+	cfg.auditSink(event)
+}
+
+// WithTraceIDFunc reads trace ids of span contexts for AuditEvent, SlowQuery, WithTraceRecorder and WithTraceSetting,
+// by default they are read from span contexts with TraceID() string method. Tracers returning other types need it,
+// e.g. func(sc opentracing.SpanContext) string { return sc.(jaeger.SpanContext).TraceID().String() }
+func WithTraceIDFunc(fn func(sc opentracing.SpanContext) string) Option {
+	return func(o *options) {
+		o.traceIDFunc = fn
+	}
+}
+
+// traceID returns trace id of the span context, empty if its tracer doesn't expose it
+func (cfg *config) traceID(sc opentracing.SpanContext) string {
+	if cfg.traceIDFunc != nil {
+		return cfg.traceIDFunc(sc)
+	}
+	if sc, ok := sc.(interface{ TraceID() string }); ok {
+		return sc.TraceID()
+	}
+	return ""
+}
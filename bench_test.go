@@ -0,0 +1,68 @@
+package otgorm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+type benchProduct struct {
+	gorm.Model
+	Code string
+}
+
+func newBenchScope(tb testing.TB, traced bool) *gorm.Scope {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if traced {
+		span := mocktracer.New().StartSpan("bench")
+		db = SetSpanToGorm(opentracing.ContextWithSpan(context.Background(), span), db)
+	}
+	scope := db.NewScope(&benchProduct{})
+	scope.SQL = `SELECT * FROM "bench_products" WHERE (code = ?)`
+	scope.SQLVars = []interface{}{"L1212"}
+	return scope
+}
+
+// untraced queries are the common case, callbacks must not allocate for them
+func TestUntracedAllocs(t *testing.T) {
+	c := newCallbacks()
+	scope := newBenchScope(t, false)
+	allocs := testing.AllocsPerRun(100, func() {
+		c.beforeOperation(scope, "query")
+		c.before(scope, "SELECT")
+		c.after(scope, "SELECT")
+		c.afterOperation(scope)
+	})
+	if allocs != 0 {
+		t.Errorf("untraced query should not allocate but it allocates %v times", allocs)
+	}
+}
+
+func BenchmarkUntraced(b *testing.B) {
+	c := newCallbacks()
+	scope := newBenchScope(b, false)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.before(scope, "SELECT")
+		c.after(scope, "SELECT")
+	}
+}
+
+func BenchmarkTraced(b *testing.B) {
+	c := newCallbacks()
+	scope := newBenchScope(b, true)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.before(scope, "SELECT")
+		c.after(scope, "SELECT")
+	}
+}
@@ -0,0 +1,56 @@
+package otgorm
+
+import (
+	"sync"
+	"time"
+)
+
+// spanBudget caps the number of spans per table and operation per second
+type spanBudget struct {
+	limit int
+
+	mu      sync.Mutex
+	windows map[budgetKey]*budgetWindow
+}
+
+type budgetKey struct {
+	table     string
+	operation string
+}
+
+type budgetWindow struct {
+	second  int64
+	spans   int
+	dropped int64
+}
+
+func newSpanBudget(limit int) *spanBudget {
+	return &spanBudget{limit: limit, windows: make(map[budgetKey]*budgetWindow)}
+}
+
+// allow reports whether span of the table and operation fits into the budget of the current second,
+// for allowed span it returns the number of spans dropped since the previous allowed one
+func (b *spanBudget) allow(table, operation string, now time.Time) (bool, int64) {
+	key := budgetKey{table: table, operation: operation}
+	second := now.Unix()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	w, ok := b.windows[key]
+	if !ok {
+		w = &budgetWindow{second: second}
+		b.windows[key] = w
+	}
+	if w.second != second {
+		w.second = second
+		w.spans = 0
+	}
+	if w.spans >= b.limit {
+		w.dropped++
+		return false, 0
+	}
+	w.spans++
+	dropped := w.dropped
+	w.dropped = 0
+	return true, dropped
+}
@@ -0,0 +1,33 @@
+package otgorm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSpanBudget(t *testing.T) {
+	b := newSpanBudget(2)
+	now := time.Unix(1000, 0)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.allow("users", "SELECT", now); !ok {
+			t.Fatalf("span %d should fit into the budget", i+1)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if ok, _ := b.allow("users", "SELECT", now); ok {
+			t.Fatalf("span %d should be over the budget", i+3)
+		}
+	}
+	if ok, _ := b.allow("users", "UPDATE", now); !ok {
+		t.Errorf("span of another operation should fit into its own budget")
+	}
+
+	ok, dropped := b.allow("users", "SELECT", now.Add(time.Second))
+	if !ok {
+		t.Fatalf("span of the next second should fit into the budget")
+	}
+	if dropped != 3 {
+		t.Errorf("3 spans should be dropped but it's %d", dropped)
+	}
+}
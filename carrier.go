@@ -0,0 +1,32 @@
+package otgorm
+
+import (
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// remoteParent is span context extracted by SpanToGormFromCarrier with the tracer which extracted it
+type remoteParent struct {
+	sc     opentracing.SpanContext
+	tracer opentracing.Tracer
+}
+
+// SpanToGormFromCarrier extracts span context from carrier of message headers, e.g. Kafka headers or SQS attributes
+// converted to opentracing.TextMapCarrier, and sets it to gorm settings, returns cloned DB.
+// opentracing.HTTPHeadersCarrier is extracted with opentracing.HTTPHeaders format, other carriers with opentracing.TextMap.
+// Spans are started by tracer, opentracing.GlobalTracer() if it's nil. If there is no span context in carrier,
+// db is returned as is with opentracing.ErrSpanContextNotFound
+func SpanToGormFromCarrier(tracer opentracing.Tracer, carrier opentracing.TextMapReader, db *gorm.DB) (*gorm.DB, error) {
+	if tracer == nil {
+		tracer = opentracing.GlobalTracer()
+	}
+	var format interface{} = opentracing.TextMap
+	if _, ok := carrier.(opentracing.HTTPHeadersCarrier); ok {
+		format = opentracing.HTTPHeaders
+	}
+	sc, err := tracer.Extract(format, carrier)
+	if err != nil {
+		return db, err
+	}
+	return db.Set(ParentSpanGormKey, remoteParent{sc: sc, tracer: tracer}), nil
+}
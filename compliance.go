@@ -0,0 +1,131 @@
+package otgorm
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	auditMu     sync.Mutex
+	auditLogger gorm.LogWriter = log.New(os.Stderr, "", log.LstdFlags)
+)
+
+// WithNoValues enables compliance mode which guarantees no parameter values reach span tags and logs:
+// statements are reported with placeholders and their quoted literals, as well as quoted literals of error messages,
+// are replaced with '?', value converters are disabled and options which capture values are ignored:
+// WithLastInsertID, WithExplainAnalyze, values of WithUpdateCapture, WithSettingsTags, WithTagsFunc, WithShardTagger
+// and WithSchemaResolver.
+// Statements passed to WithSlowQuerySink and kept by WithTraceRecorder are redacted the same way.
+// Ignored options are reported to the audit logger. The mode can't be turned off by UpdateConfig and it's enforced
+// on spans of WrapDriver db is opened with
+func WithNoValues() Option {
+	return func(o *options) {
+		o.noValues = true
+	}
+}
+
+// SetAuditLogger sets logger of attempts to capture values while no values mode is enabled, it's stderr by default
+func SetAuditLogger(l gorm.LogWriter) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	auditLogger = l
+}
+
+// audit reports attempt to capture values while no values mode is enabled
+func audit(attempt string) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	auditLogger.Println("otgorm: audit: no values mode is enabled, ignored " + attempt)
+}
+
+// audit reports options which capture values in no values mode
+func (o options) audit() {
+	if !o.noValues {
+		return
+	}
+	if o.lastInsertID {
+		audit("WithLastInsertID")
+	}
+	if o.explainRate > 0 {
+		audit("WithExplainAnalyze")
+	}
+	for _, values := range o.updateCapture {
+		if values {
+			audit("WithUpdateCapture")
+			break
+		}
+	}
+	if len(o.settingsTags) > 0 {
+		audit("WithSettingsTags")
+	}
+	if o.tagsFunc != nil {
+		audit("WithTagsFunc")
+	}
+	if o.shardTagger != nil {
+		audit("WithShardTagger")
+	}
+	if o.schemaResolver != nil {
+		audit("WithSchemaResolver")
+	}
+	convertersMu.RLock()
+	registered := len(converters) > 0
+	convertersMu.RUnlock()
+	if registered {
+		audit("RegisterValueConverter")
+	}
+}
+
+// enforceNoValues turns no values mode of the callbacks on, it's used by callbacks of gorm in no values mode
+// to keep values out of spans of WrapDriver db is opened with
+func (c *callbacks) enforceNoValues() {
+	c.registerMu.Lock()
+	defer c.registerMu.Unlock()
+	if c.config().noValues {
+		return
+	}
+	cfg := *c.config()
+	cfg.noValues = true
+	cfg.audit()
+	c.cfg.Store(&cfg)
+}
+
+// redactedError returns err with quoted literals of its message replaced with '?' in no values mode,
+// e.g. mysql reports duplicate values as Duplicate entry 'john@example.com' for key 'email'
+func redactedError(err error, opts options) error {
+	if err == nil || !opts.noValues {
+		return err
+	}
+	return errors.New(redactLiterals(err.Error()))
+}
+
+// redactedStatement returns statement with quoted literals replaced with '?' in no values mode
+func redactedStatement(statement string, opts options) string {
+	if !opts.noValues {
+		return statement
+	}
+	return redactLiterals(statement)
+}
+
+func redactLiterals(msg string) string {
+	var b strings.Builder
+	for {
+		open := strings.IndexByte(msg, '\'')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(msg[open+1:], '\'')
+		if end < 0 {
+			break
+		}
+		b.WriteString(msg[:open])
+		b.WriteString("'?'")
+		msg = msg[open+end+2:]
+	}
+	b.WriteString(msg)
+	return b.String()
+}
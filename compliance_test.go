@@ -0,0 +1,116 @@
+package otgorm
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/mattn/go-sqlite3"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func TestNoValues(t *testing.T) {
+	var auditLog bytes.Buffer
+	defer SetAuditLogger(auditLogger)
+	SetAuditLogger(log.New(&auditLog, "", 0))
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(&benchProduct{})
+	var slow []SlowQuery
+	AddGormCallbacks(db, WithNoValues(), WithLastInsertID(), WithSettingsTags("user"),
+		WithTagsFunc(func(scope *gorm.Scope) map[string]interface{} {
+			return map[string]interface{}{"code": "L1212"}
+		}),
+		WithShardTagger(func(scope *gorm.Scope) (string, bool) { return "L1212", true }),
+		WithSchemaResolver(func(scope *gorm.Scope) string { return "L1212" }),
+		WithSlowThreshold(time.Nanosecond), WithSlowQuerySink(func(q SlowQuery) { slow = append(slow, q) }))
+	for _, option := range []string{"WithLastInsertID", "WithSettingsTags", "WithTagsFunc", "WithShardTagger", "WithSchemaResolver"} {
+		if !strings.Contains(auditLog.String(), option) {
+			t.Errorf("audit log should report %s but it's %q", option, auditLog.String())
+		}
+	}
+
+	tracer := mocktracer.New()
+	span := tracer.StartSpan("test")
+	tdb := SetSpanToGorm(opentracing.ContextWithSpan(context.Background(), span), db).Set("user", "john@example.com")
+	tdb.Create(&benchProduct{Code: "L1212"})
+	tdb.Where("code = 'L1212'").Find(&[]benchProduct{})
+	span.Finish()
+
+	for _, sqlSpan := range tracer.FinishedSpans()[:2] {
+		if statement := sqlSpan.Tag("db.statement").(string); strings.Contains(statement, "L1212") {
+			t.Errorf("sql span tag 'db.statement' should not contain values but it's %q", statement)
+		}
+		if id := sqlSpan.Tag("db.last_insert_id"); id != nil {
+			t.Errorf("sql span tag 'db.last_insert_id' should be empty but it's '%v'", id)
+		}
+		if user, code, shard, schema := sqlSpan.Tag("user"), sqlSpan.Tag("code"), sqlSpan.Tag("db.shard"), sqlSpan.Tag("db.schema"); user != nil || code != nil || shard != nil || schema != nil {
+			t.Errorf("sql span shouldn't have application tags but it has %v", sqlSpan.Tags())
+		}
+	}
+	if len(slow) != 2 || strings.Contains(slow[1].Statement, "L1212") {
+		t.Errorf("slow query statements should not contain values but they are %+v", slow)
+	}
+
+	// other callbacks keep values
+	plain, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain.AutoMigrate(&benchProduct{})
+	AddGormCallbacks(plain)
+	tracer.Reset()
+	span = tracer.StartSpan("test")
+	SetSpanToGorm(opentracing.ContextWithSpan(context.Background(), span), plain).Create(&benchProduct{Code: "L1212"})
+	span.Finish()
+	if statement := tracer.FinishedSpans()[0].Tag("db.statement").(string); !strings.Contains(statement, "L1212") {
+		t.Errorf("sql span of callbacks without no values mode should contain values but it's %q", statement)
+	}
+
+	err = redactedError(errors.New("Error 1062: Duplicate entry 'john@example.com' for key 'email'"), options{noValues: true})
+	if msg := err.Error(); msg != "Error 1062: Duplicate entry '?' for key '?'" {
+		t.Errorf("error literals should be redacted but it's %q", msg)
+	}
+}
+
+func TestNoValuesSticky(t *testing.T) {
+	var auditLog bytes.Buffer
+	defer SetAuditLogger(auditLogger)
+	SetAuditLogger(log.New(&auditLog, "", 0))
+
+	c := newCallbacks()
+	sql.Register("sqlite3-no-values", &tracedDriver{driver: &sqlite3.SQLiteDriver{}, callbacks: c})
+	sqlDB, err := sql.Open("sqlite3-no-values", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	db, err := gorm.Open("sqlite3", sqlDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AddGormCallbacks(db, WithNoValues())
+	if !c.config().noValues {
+		t.Error("no values mode should be enforced on the driver db is opened with")
+	}
+
+	if err := UpdateConfig(db); err != nil {
+		t.Fatal(err)
+	}
+	if !callbacksFromGorm(db).config().noValues {
+		t.Error("no values mode shouldn't be turned off by UpdateConfig")
+	}
+	if !strings.Contains(auditLog.String(), "UpdateConfig") {
+		t.Errorf("audit log should report the attempt to turn no values mode off but it's %q", auditLog.String())
+	}
+}
@@ -0,0 +1,75 @@
+package otgorm
+
+import (
+	"reflect"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// FirstOrCreate calls db.FirstOrCreate inside "gorm:first_or_create" span,
+// so SELECT and INSERT it issues are grouped as one logical operation
+func FirstOrCreate(db *gorm.DB, out interface{}, where ...interface{}) *gorm.DB {
+	return traceCompound(db, "first_or_create", out, func(db *gorm.DB) *gorm.DB {
+		return db.FirstOrCreate(out, where...)
+	}, nil)
+}
+
+// FirstOrInit calls db.FirstOrInit inside "gorm:first_or_init" span
+func FirstOrInit(db *gorm.DB, out interface{}, where ...interface{}) *gorm.DB {
+	return traceCompound(db, "first_or_init", out, func(db *gorm.DB) *gorm.DB {
+		return db.FirstOrInit(out, where...)
+	}, nil)
+}
+
+// Count calls db.Count inside "gorm:count" span tagged with the counted number as db.result,
+// gorm reads it after the sql span is finished
+func Count(db *gorm.DB, value interface{}) *gorm.DB {
+	return traceCompound(db, "count", db.Value, func(db *gorm.DB) *gorm.DB {
+		return db.Count(value)
+	}, func(sp opentracing.Span) {
+		if v := reflect.Indirect(reflect.ValueOf(value)); v.IsValid() {
+			sp.SetTag("db.result", v.Interface())
+		}
+	})
+}
+
+// Pluck calls db.Pluck inside "gorm:pluck" span tagged with the number of plucked values as db.rows,
+// gorm reads them after the sql span is finished
+func Pluck(db *gorm.DB, column string, value interface{}) *gorm.DB {
+	return traceCompound(db, "pluck", db.Value, func(db *gorm.DB) *gorm.DB {
+		return db.Pluck(column, value)
+	}, func(sp opentracing.Span) {
+		if v := reflect.Indirect(reflect.ValueOf(value)); v.Kind() == reflect.Slice {
+			sp.SetTag("db.rows", v.Len())
+		}
+	})
+}
+
+// traceCompound calls fn inside "gorm:<name>" span, tags sets result tags of the span if fn succeeds
+func traceCompound(db *gorm.DB, name string, out interface{}, fn func(db *gorm.DB) *gorm.DB,
+	tags func(sp opentracing.Span)) *gorm.DB {
+	val, ok := db.Get(ParentSpanGormKey)
+	if !ok {
+		return fn(db)
+	}
+	cfg := callbacksFromGorm(db).config()
+	parent, tr, ok := cfg.spanParent(val)
+	if !ok {
+		return fn(db)
+	}
+	sp := cfg.sanitizeSpan(tr.StartSpan("gorm:"+name, opentracing.ChildOf(parent)))
+	defer sp.Finish()
+
+	result := fn(db.Set(ParentSpanGormKey, sp))
+	// result is a clone, following calls on it must not use finished span as a parent
+	result.InstantSet(ParentSpanGormKey, val)
+
+	ext.Error.Set(sp, result.Error != nil && !result.RecordNotFound())
+	sp.SetTag("db.table", db.NewScope(out).TableName())
+	if tags != nil && result.Error == nil {
+		tags(sp)
+	}
+	return result
+}
@@ -0,0 +1,374 @@
+package otgorm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config is serializable instrumentation policy, zero fields keep defaults of AddGormCallbacks.
+// Options taking functions, tracers and sinks aren't configurable, append them to Options
+type Config struct {
+	// Dialect and DSN are used by NewTracedDB to open db
+	Dialect string `json:"dialect" yaml:"dialect"`
+	DSN     string `json:"dsn" yaml:"dsn"`
+
+	// Profile is development, production or compliance, the other fields override it
+	Profile string `json:"profile" yaml:"profile"`
+
+	MaxInValues int `json:"max_in_values" yaml:"max_in_values"`
+	MaxParams   int `json:"max_params" yaml:"max_params"`
+
+	OperationSpans bool `json:"operation_spans" yaml:"operation_spans"`
+	// Granularity is statement, operation or call
+	Granularity         string   `json:"granularity" yaml:"granularity"`
+	CallbackTimings     bool     `json:"callback_timings" yaml:"callback_timings"`
+	CallbackProfiling   []string `json:"callback_profiling" yaml:"callback_profiling"`
+	OperationTableNames bool     `json:"operation_table_names" yaml:"operation_table_names"`
+	NoRowsMatched       bool     `json:"no_rows_matched" yaml:"no_rows_matched"`
+	LastInsertID        bool     `json:"last_insert_id" yaml:"last_insert_id"`
+	NoValues            bool     `json:"no_values" yaml:"no_values"`
+
+	StatementTimeout        bool     `json:"statement_timeout" yaml:"statement_timeout"`
+	StatementTimeoutFloor   Duration `json:"statement_timeout_floor" yaml:"statement_timeout_floor"`
+	StatementTimeoutCeiling Duration `json:"statement_timeout_ceiling" yaml:"statement_timeout_ceiling"`
+
+	MaxExecutionTime        bool     `json:"max_execution_time" yaml:"max_execution_time"`
+	MaxExecutionTimeFloor   Duration `json:"max_execution_time_floor" yaml:"max_execution_time_floor"`
+	MaxExecutionTimeCeiling Duration `json:"max_execution_time_ceiling" yaml:"max_execution_time_ceiling"`
+
+	// DuplicateMode is suppress or tag
+	DuplicateMode string `json:"duplicate_mode" yaml:"duplicate_mode"`
+
+	TimeLayout string `json:"time_layout" yaml:"time_layout"`
+	// TimeLocation is IANA time zone name, e.g. UTC
+	TimeLocation string `json:"time_location" yaml:"time_location"`
+
+	ServerVersion    bool     `json:"server_version" yaml:"server_version"`
+	InstanceID       bool     `json:"instance_id" yaml:"instance_id"`
+	BackendPID       bool     `json:"backend_pid" yaml:"backend_pid"`
+	PoolName         string   `json:"pool_name" yaml:"pool_name"`
+	ApplicationName  string   `json:"application_name" yaml:"application_name"`
+	SlowThreshold    Duration `json:"slow_threshold" yaml:"slow_threshold"`
+	SamplingPriority bool     `json:"sampling_priority" yaml:"sampling_priority"`
+	SpanBudget       int      `json:"span_budget" yaml:"span_budget"`
+	AsyncWorkers     int      `json:"async_workers" yaml:"async_workers"`
+	AsyncQueue       int      `json:"async_queue" yaml:"async_queue"`
+
+	// Tags are names of built-in tags, e.g. db.statement, nil keeps all tags
+	Tags          []string `json:"tags" yaml:"tags"`
+	SettingsTags  []string `json:"settings_tags" yaml:"settings_tags"`
+	SchemaSetting string   `json:"schema_setting" yaml:"schema_setting"`
+
+	ExplainRate   float64  `json:"explain_rate" yaml:"explain_rate"`
+	ExplainTables []string `json:"explain_tables" yaml:"explain_tables"`
+
+	AllowedColumns []string `json:"allowed_columns" yaml:"allowed_columns"`
+	AllowedParams  []int    `json:"allowed_params" yaml:"allowed_params"`
+
+	// DefaultCapture and values of TableCapture are full, placeholders or none
+	DefaultCapture      string            `json:"default_capture" yaml:"default_capture"`
+	TableCapture        map[string]string `json:"table_capture" yaml:"table_capture"`
+	UpdateCaptureTables []string          `json:"update_capture_tables" yaml:"update_capture_tables"`
+	UpdateCaptureValues bool              `json:"update_capture_values" yaml:"update_capture_values"`
+	// StatementFormat is raw, compact or pretty
+	StatementFormat    string `json:"statement_format" yaml:"statement_format"`
+	FailedStatement    bool   `json:"failed_statement" yaml:"failed_statement"`
+	OversizedStatement int    `json:"oversized_statement" yaml:"oversized_statement"`
+
+	OverheadTag    bool   `json:"overhead_tag" yaml:"overhead_tag"`
+	ExecTiming     bool   `json:"exec_timing" yaml:"exec_timing"`
+	CheckpointLogs bool   `json:"checkpoint_logs" yaml:"checkpoint_logs"`
+	QueryID        bool   `json:"query_id" yaml:"query_id"`
+	TraceSetting   string `json:"trace_setting" yaml:"trace_setting"`
+	ScanSpans      bool   `json:"scan_spans" yaml:"scan_spans"`
+	GlobalTracer   bool   `json:"global_tracer" yaml:"global_tracer"`
+
+	RuntimeTrace     bool     `json:"runtime_trace" yaml:"runtime_trace"`
+	PprofLabels      bool     `json:"pprof_labels" yaml:"pprof_labels"`
+	RepeatWindow     Duration `json:"repeat_window" yaml:"repeat_window"`
+	RequestSummary   bool     `json:"request_summary" yaml:"request_summary"`
+	LateQueryCheck   bool     `json:"late_query_check" yaml:"late_query_check"`
+	ParentTotals     bool     `json:"parent_totals" yaml:"parent_totals"`
+	ParentTimeTags   bool     `json:"parent_time_tags" yaml:"parent_time_tags"`
+	ParentMaxQueries int      `json:"parent_max_queries" yaml:"parent_max_queries"`
+	ParentMaxTime    Duration `json:"parent_max_time" yaml:"parent_max_time"`
+
+	RecorderTraces     int `json:"recorder_traces" yaml:"recorder_traces"`
+	RecorderStatements int `json:"recorder_statements" yaml:"recorder_statements"`
+}
+
+// Duration is time.Duration written as a string like "500ms" in configuration
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+var granularityNames = map[string]Granularity{
+	"statement": GranularityStatement,
+	"operation": GranularityOperation,
+	"call":      GranularityCall,
+}
+
+var duplicateModeNames = map[string]DuplicateMode{
+	"suppress": DuplicateSuppress,
+	"tag":      DuplicateTag,
+}
+
+var statementFormatNames = map[string]StatementFormat{
+	"raw":     StatementRaw,
+	"compact": StatementCompact,
+	"pretty":  StatementPretty,
+}
+
+func parseCapture(name string) (Capture, error) {
+	for capture, n := range captureNames {
+		if n == name {
+			return capture, nil
+		}
+	}
+	return CaptureFull, fmt.Errorf("otgorm: unknown capture %q", name)
+}
+
+// LoadConfig reads Config from JSON file if path has .json extension or from YAML file otherwise,
+// unknown fields are errors
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&cfg)
+	} else {
+		err = yaml.UnmarshalStrict(data, &cfg)
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("otgorm: %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// FromEnv reads Config from OTGORM_<FIELD> environment variables named after upper-cased JSON fields,
+// e.g. OTGORM_SLOW_THRESHOLD=500ms. Lists are comma separated and table_capture is table=capture pairs
+func FromEnv() (Config, error) {
+	var cfg Config
+	v := reflect.ValueOf(&cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+		env := "OTGORM_" + strings.ToUpper(name)
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			continue
+		}
+		if err := setEnvField(v.Field(i), strings.TrimSpace(value)); err != nil {
+			return cfg, fmt.Errorf("otgorm: %s: %v", env, err)
+		}
+	}
+	return cfg, nil
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+func setEnvField(field reflect.Value, value string) error {
+	var items []string
+	if value != "" {
+		items = strings.Split(value, ",")
+	}
+	switch {
+	case field.Type() == durationType:
+		return field.Addr().Interface().(*Duration).UnmarshalText([]byte(value))
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		field.SetBool(b)
+		return err
+	case field.Kind() == reflect.Int:
+		n, err := strconv.Atoi(value)
+		field.SetInt(int64(n))
+		return err
+	case field.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		field.SetFloat(f)
+		return err
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Type() == reflect.TypeOf([]string(nil)):
+		list := make([]string, 0, len(items))
+		for _, item := range items {
+			list = append(list, strings.TrimSpace(item))
+		}
+		field.Set(reflect.ValueOf(list))
+	case field.Type() == reflect.TypeOf([]int(nil)):
+		list := make([]int, 0, len(items))
+		for _, item := range items {
+			n, err := strconv.Atoi(strings.TrimSpace(item))
+			if err != nil {
+				return err
+			}
+			list = append(list, n)
+		}
+		field.Set(reflect.ValueOf(list))
+	case field.Type() == reflect.TypeOf(map[string]string(nil)):
+		m := make(map[string]string, len(items))
+		for _, item := range items {
+			kv := strings.SplitN(item, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("%q isn't key=value", item)
+			}
+			m[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+		field.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported field type %v", field.Type())
+	}
+	return nil
+}
+
+// Options returns options of the configuration for AddGormCallbacks, it fails on unknown names
+func (c Config) Options() ([]Option, error) {
+	var opts []Option
+	add := func(enabled bool, opt Option) {
+		if enabled {
+			opts = append(opts, opt)
+		}
+	}
+	if c.Profile != "" {
+		p, ok := profileNames[c.Profile]
+		if !ok {
+			return nil, fmt.Errorf("otgorm: unknown profile %q", c.Profile)
+		}
+		opts = append(opts, WithProfile(p))
+	}
+	add(c.MaxInValues != 0, WithMaxInValues(c.MaxInValues))
+	add(c.MaxParams != 0, WithMaxParams(c.MaxParams))
+	add(c.OperationSpans, WithOperationSpans())
+	if c.Granularity != "" {
+		g, ok := granularityNames[c.Granularity]
+		if !ok {
+			return nil, fmt.Errorf("otgorm: unknown granularity %q", c.Granularity)
+		}
+		opts = append(opts, WithGranularity(g))
+	}
+	add(c.CallbackTimings, WithCallbackTimings())
+	add(len(c.CallbackProfiling) > 0, WithCallbackProfiling(c.CallbackProfiling...))
+	add(c.OperationTableNames, WithOperationTableNames())
+	add(c.NoRowsMatched, WithNoRowsMatched())
+	add(c.LastInsertID, WithLastInsertID())
+	add(c.NoValues, WithNoValues())
+	add(c.StatementTimeout, WithStatementTimeout(time.Duration(c.StatementTimeoutFloor), time.Duration(c.StatementTimeoutCeiling)))
+	add(c.MaxExecutionTime, WithMaxExecutionTime(time.Duration(c.MaxExecutionTimeFloor), time.Duration(c.MaxExecutionTimeCeiling)))
+	if c.DuplicateMode != "" {
+		mode, ok := duplicateModeNames[c.DuplicateMode]
+		if !ok {
+			return nil, fmt.Errorf("otgorm: unknown duplicate mode %q", c.DuplicateMode)
+		}
+		opts = append(opts, WithDuplicateMode(mode))
+	}
+	if c.TimeLayout != "" || c.TimeLocation != "" {
+		var loc *time.Location
+		if c.TimeLocation != "" {
+			var err error
+			if loc, err = time.LoadLocation(c.TimeLocation); err != nil {
+				return nil, fmt.Errorf("otgorm: %v", err)
+			}
+		}
+		opts = append(opts, WithTimeFormat(c.TimeLayout, loc))
+	}
+	add(c.ServerVersion, WithServerVersion())
+	add(c.InstanceID, WithInstanceID())
+	add(c.BackendPID, WithBackendPID())
+	add(c.PoolName != "", WithPoolName(c.PoolName))
+	add(c.ApplicationName != "", WithApplicationName(c.ApplicationName))
+	add(c.SlowThreshold != 0, WithSlowThreshold(time.Duration(c.SlowThreshold)))
+	add(c.SamplingPriority, WithSamplingPriority())
+	add(c.SpanBudget != 0, WithSpanBudget(c.SpanBudget))
+	add(c.AsyncWorkers > 0, WithAsyncFinish(c.AsyncWorkers, c.AsyncQueue))
+
+	if c.Tags != nil {
+		var tags Tags
+		for _, name := range c.Tags {
+			found := false
+			for _, t := range tagNames {
+				if t.name == name {
+					tags |= t.tag
+					found = true
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("otgorm: unknown tag %q", name)
+			}
+		}
+		opts = append(opts, WithTags(tags))
+	}
+	add(len(c.SettingsTags) > 0, WithSettingsTags(c.SettingsTags...))
+	add(c.SchemaSetting != "", WithSchemaResolver(SchemaSetting(c.SchemaSetting)))
+	add(c.ExplainRate > 0, WithExplainAnalyze(c.ExplainRate, c.ExplainTables...))
+	add(c.AllowedColumns != nil, WithAllowedColumns(c.AllowedColumns...))
+	add(c.AllowedParams != nil, WithAllowedParams(c.AllowedParams...))
+
+	if c.DefaultCapture != "" {
+		capture, err := parseCapture(c.DefaultCapture)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithDefaultCapture(capture))
+	}
+	for table, name := range c.TableCapture {
+		capture, err := parseCapture(name)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithTableCapture(capture, table))
+	}
+	add(len(c.UpdateCaptureTables) > 0, WithUpdateCapture(c.UpdateCaptureValues, c.UpdateCaptureTables...))
+	if c.StatementFormat != "" {
+		format, ok := statementFormatNames[c.StatementFormat]
+		if !ok {
+			return nil, fmt.Errorf("otgorm: unknown statement format %q", c.StatementFormat)
+		}
+		opts = append(opts, WithStatementFormat(format))
+	}
+	add(c.FailedStatement, WithFailedStatement())
+	add(c.OversizedStatement > 0, WithOversizedStatement(c.OversizedStatement))
+
+	add(c.OverheadTag, WithOverheadTag())
+	add(c.ExecTiming, WithExecTiming())
+	add(c.CheckpointLogs, WithCheckpointLogs())
+	add(c.QueryID, WithQueryID())
+	add(c.TraceSetting != "", WithTraceSetting(c.TraceSetting))
+	add(c.ScanSpans, WithScanSpans())
+	add(c.GlobalTracer, WithGlobalTracer())
+
+	add(c.RuntimeTrace, WithRuntimeTrace())
+	add(c.PprofLabels, WithPprofLabels())
+	add(c.RepeatWindow > 0, WithRepeatCompression(time.Duration(c.RepeatWindow)))
+	add(c.RequestSummary, WithRequestSummary())
+	add(c.LateQueryCheck, WithLateQueryCheck(nil))
+	add(c.ParentTotals, WithParentTotals(c.ParentMaxQueries, time.Duration(c.ParentMaxTime)))
+	add(c.ParentTimeTags, WithParentTimeTags())
+	add(c.RecorderTraces > 0, WithTraceRecorder(c.RecorderTraces, c.RecorderStatements))
+	return opts, nil
+}
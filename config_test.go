@@ -0,0 +1,121 @@
+package otgorm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func applyConfig(t *testing.T, cfg Config) options {
+	opts, err := cfg.Options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "otgorm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"config.yaml": "slow_threshold: 500ms\ngranularity: call\ntags: [db.table, db.statement]\n" +
+			"table_capture:\n  users: none\nallowed_params: [1, 2]\n",
+		"config.json": `{"slow_threshold": "500ms", "granularity": "call", "tags": ["db.table", "db.statement"],` +
+			` "table_capture": {"users": "none"}, "allowed_params": [1, 2]}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		o := applyConfig(t, cfg)
+		if o.slowThreshold != 500*time.Millisecond || o.granularity != GranularityCall || o.tags != TagTable|TagStatement ||
+			o.tableCapture["users"] != CaptureNone || len(o.allowedParams) != 2 || o.maxInValues != 100 {
+			t.Errorf("%s: options don't match the config: %+v", name, cfg)
+		}
+	}
+
+	path := filepath.Join(dir, "typo.yaml")
+	if err := ioutil.WriteFile(path, []byte("slow_treshold: 1s\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("unknown field should be an error")
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	env := map[string]string{
+		"OTGORM_SLOW_THRESHOLD":   "250ms",
+		"OTGORM_QUERY_ID":         "true",
+		"OTGORM_ALLOWED_COLUMNS":  "id, status",
+		"OTGORM_TABLE_CAPTURE":    "users=placeholders",
+		"OTGORM_PARENT_TOTALS":    "1",
+		"OTGORM_PARENT_MAX_TIME":  "1s",
+		"OTGORM_DEFAULT_CAPTURE":  "none",
+		"OTGORM_STATEMENT_FORMAT": "compact",
+	}
+	for key, value := range env {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+	cfg, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := applyConfig(t, cfg)
+	if o.slowThreshold != 250*time.Millisecond || !o.queryID || !o.allowedColumns["status"] ||
+		o.tableCapture["users"] != CapturePlaceholders || !o.parentTotals || o.parentMaxTime != time.Second ||
+		o.defaultCapture != CaptureNone || o.statementFormat != StatementCompact {
+		t.Errorf("options don't match the environment: %+v", cfg)
+	}
+
+	os.Setenv("OTGORM_SPAN_BUDGET", "many")
+	defer os.Unsetenv("OTGORM_SPAN_BUDGET")
+	if _, err := FromEnv(); err == nil {
+		t.Error("invalid value should be an error")
+	}
+}
+
+func TestConfigUnknownNames(t *testing.T) {
+	for _, cfg := range []Config{
+		{Profile: "staging"},
+		{Granularity: "query"},
+		{Tags: []string{"db.unknown"}},
+		{DefaultCapture: "some"},
+		{TableCapture: map[string]string{"users": "all"}},
+		{StatementFormat: "sql"},
+		{DuplicateMode: "drop"},
+	} {
+		if _, err := cfg.Options(); err == nil {
+			t.Errorf("config %+v should be an error", cfg)
+		}
+	}
+}
+
+func TestProfile(t *testing.T) {
+	o := applyConfig(t, Config{Profile: "production", SlowThreshold: Duration(time.Second)})
+	if !o.failedStatement || o.spanBudget != 100 || o.slowThreshold != time.Second {
+		t.Errorf("production profile should be applied with overridden slow threshold: %+v", o.debugConfig())
+	}
+
+	o = defaultOptions()
+	WithProfile(ProfileCompliance)(&o)
+	if !o.noValues || o.defaultCapture != CapturePlaceholders || o.allowedColumns == nil || o.has(TagParams) {
+		t.Errorf("compliance profile shouldn't capture values: %+v", o.debugConfig())
+	}
+}
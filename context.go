@@ -0,0 +1,20 @@
+package otgorm
+
+import (
+	"context"
+
+	"github.com/jinzhu/gorm"
+)
+
+type dbContextKey struct{}
+
+// NewContext returns a copy of ctx carrying db, use FromContext to get it
+func NewContext(ctx context.Context, db *gorm.DB) context.Context {
+	return context.WithValue(ctx, dbContextKey{}, db)
+}
+
+// FromContext returns db stored in ctx by NewContext (or by middlewares), returns nil if there is no db
+func FromContext(ctx context.Context) *gorm.DB {
+	db, _ := ctx.Value(dbContextKey{}).(*gorm.DB)
+	return db
+}
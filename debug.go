@@ -0,0 +1,274 @@
+package otgorm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// maxRecentErrors is the number of instrumentation errors kept for DebugHandler
+const maxRecentErrors = 20
+
+// instrumentationError is a failure of the instrumentation itself, e.g. failed server version query
+type instrumentationError struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	Error  string    `json:"error"`
+}
+
+// status collects counters reported by DebugHandler, it survives UpdateConfig
+type status struct {
+	// untraced is the number of queries executed without span set by SetSpanToGorm
+	untraced int64
+	// overheadNanos is time spent in callbacks by overheadSpans spans
+	overheadNanos int64
+	overheadSpans int64
+	// panics is the number of panics recovered in callbacks
+	panics int64
+	// spans holds *int64 number of sql spans by table
+	spans sync.Map
+
+	mu     sync.Mutex
+	errors []instrumentationError
+}
+
+func (s *status) countUntraced() {
+	atomic.AddInt64(&s.untraced, 1)
+}
+
+func (s *status) countSpan(table string) {
+	val, ok := s.spans.Load(table)
+	if !ok {
+		val, _ = s.spans.LoadOrStore(table, new(int64))
+	}
+	atomic.AddInt64(val.(*int64), 1)
+}
+
+// reportError records error of the instrumentation, only the most recent ones are kept
+func (s *status) reportError(source string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.errors) == maxRecentErrors {
+		s.errors = append(s.errors[:0], s.errors[1:]...)
+	}
+	s.errors = append(s.errors, instrumentationError{Time: time.Now(), Source: source, Error: err.Error()})
+}
+
+// debugCallbacks are names of callbacks registered by AddGormCallbacks by processor, operation callbacks
+// are registered once operation spans are enabled
+var debugCallbacks = []struct {
+	processor string
+	names     []string
+	operation []string
+}{
+	{"create", []string{"tracing:create_before", "tracing:create_after"}, []string{"tracing:create_operation_before", "tracing:create_operation_after"}},
+	{"query", []string{"tracing:query_before", "tracing:query_after"}, []string{"tracing:query_operation_before", "tracing:query_operation_after"}},
+	{"update", []string{"tracing:update_before", "tracing:update_after"}, []string{"tracing:update_operation_before", "tracing:update_operation_after"}},
+	{"delete", []string{"tracing:delete_before", "tracing:delete_after"}, []string{"tracing:delete_operation_before", "tracing:delete_operation_after"}},
+	{"row_query", []string{"tracing:row_query_before", "tracing:row_query_after"}, nil},
+}
+
+func processor(db *gorm.DB, name string) *gorm.CallbackProcessor {
+	switch name {
+	case "create":
+		return db.Callback().Create()
+	case "query":
+		return db.Callback().Query()
+	case "update":
+		return db.Callback().Update()
+	case "delete":
+		return db.Callback().Delete()
+	default:
+		return db.Callback().RowQuery()
+	}
+}
+
+var tagNames = []struct {
+	tag  Tags
+	name string
+}{
+	{TagType, "db.type"},
+	{TagInstance, "db.instance"},
+	{TagTable, "db.table"},
+	{TagMethod, "db.method"},
+	{TagCount, "db.count"},
+	{TagErr, "db.err"},
+	{TagStatement, "db.statement"},
+	{TagDuration, "db.duration_ms"},
+	{TagParams, "db.params"},
+	{TagUpdatedColumns, "db.updated_columns"},
+	{TagModel, "db.model"},
+	{TagSoftDelete, "db.soft_delete"},
+	{TagPagination, "db.limit"},
+	{TagBatchSize, "db.batch.size"},
+	{TagVersion, "db.version"},
+	{TagDeadline, "db.deadline_remaining_ms"},
+}
+
+var captureNames = map[Capture]string{
+	CaptureFull:         "full",
+	CapturePlaceholders: "placeholders",
+	CaptureNone:         "none",
+}
+
+// debugConfig describes options, functions are reported only as set or not
+func (o options) debugConfig() map[string]interface{} {
+	tags := []string{}
+	for _, t := range tagNames {
+		if o.has(t.tag) {
+			tags = append(tags, t.name)
+		}
+	}
+	tableCapture := make(map[string]string, len(o.tableCapture))
+	for table, capture := range o.tableCapture {
+		tableCapture[table] = captureNames[capture]
+	}
+	return map[string]interface{}{
+		"max_in_values":         o.maxInValues,
+		"max_params":            o.maxParams,
+		"operation_spans":       o.operationSpans,
+		"callback_timings":      o.callbackTimings,
+		"granularity":           o.granularity,
+		"operation_table_names": o.operationTableNames,
+		"no_rows_matched":       o.noRowsMatched,
+		"last_insert_id":        o.lastInsertID,
+		"statement_timeout":     o.statementTimeout,
+		"max_execution_time":    o.maxExecutionTime,
+		"duplicate_mode":        o.duplicateMode,
+		"server_version":        o.serverVersion,
+		"instance_id":           o.instanceID,
+		"pool_name":             o.poolName,
+		"application_name":      o.applicationName,
+		"slow_threshold":        o.slowThreshold.String(),
+		"slow_query_sink":       o.slowQuerySink != nil,
+		"sampling_priority":     o.samplingPriority,
+		"span_budget":           o.spanBudget,
+		"runtime_trace":         o.runtimeTrace,
+		"pprof_labels":          o.pprofLabels,
+		"repeat_window":         o.repeatWindow.String(),
+		"request_summary":       o.requestSummary,
+		"late_query_check":      o.parentFinished != nil,
+		"callback_profiling":    o.callbackProfiling,
+		"profiled_callbacks":    o.profiledCallbacks,
+		"trace_id_func":         o.traceIDFunc != nil,
+		"enabled_func":          o.enabledFunc != nil,
+		"error_rate_window":     o.watchdogWindow.String(),
+		"error_rate_threshold":  o.watchdogThreshold,
+		"disabled":              atomic.LoadInt32(&disabled) == 1,
+		"parent_totals":         o.parentTotals,
+		"parent_time_tags":      o.parentTimeTags,
+		"parent_max_queries":    o.parentMaxQueries,
+		"parent_max_time":       o.parentMaxTime.String(),
+		"recorder_traces":       o.recorderTraces,
+		"recorder_statements":   o.recorderStatements,
+		"async_workers":         o.asyncWorkers,
+		"async_queue":           o.asyncQueue,
+		"tags":                  tags,
+		"tags_func":             o.tagsFunc != nil,
+		"shard_tagger":          o.shardTagger != nil,
+		"schema_resolver":       o.schemaResolver != nil,
+		"settings_tags":         o.settingsTags,
+		"explain_rate":          o.explainRate,
+		"allowed_columns":       len(o.allowedColumns),
+		"allowed_params":        len(o.allowedParams),
+		"default_capture":       captureNames[o.defaultCapture],
+		"statement_format":      o.statementFormat,
+		"update_capture":        o.updateCapture,
+		"table_capture":         tableCapture,
+		"no_values":             o.noValues,
+		"overhead_tag":          o.overheadTag,
+		"sampled_statement":     o.sampled != nil,
+		"failed_statement":      o.failedStatement,
+		"oversized_statement":   o.oversizedStatement,
+		"exec_timing":           o.execTiming,
+		"checkpoint_logs":       o.checkpointLogs,
+		"query_id":              o.queryID,
+		"trace_setting":         o.traceSetting,
+		"scan_spans":            o.scanSpans,
+		"tracer":                o.tracer != nil,
+		"global_tracer":         o.globalTracer,
+		"audit_sink":            o.auditSink != nil,
+		"tag_sanitizer":         o.tagSanitizer != nil,
+	}
+}
+
+// debugStatus is the response of DebugHandler
+type debugStatus struct {
+	Callbacks map[string]bool        `json:"callbacks"`
+	Config    map[string]interface{} `json:"config"`
+	Spans     map[string]int64       `json:"spans"`
+	Untraced  int64                  `json:"untraced"`
+	Overhead  float64                `json:"overhead_us"`
+	Panics    int64                  `json:"panics"`
+	Errors    []instrumentationError `json:"errors"`
+	// ErrorRates are tables and operations alerting with WithErrorRateWatchdog
+	ErrorRates []ErrorRateAlert `json:"error_rate_alerts,omitempty"`
+}
+
+// DebugHandler returns http.Handler which reports status of the instrumentation of db as JSON:
+// registered callbacks, active options, the number of sql spans by table, the number of queries
+// executed without span set by SetSpanToGorm, average overhead of callbacks per span in microseconds,
+// the number of panics recovered in callbacks and recent errors of the instrumentation itself, oldest first.
+// With ?trace=<id> it reports statements of the trace recorded by WithTraceRecorder instead.
+// It helps to find out why a service has no db spans, mount it on an internal port only:
+//
+//	http.Handle("/debug/otgorm", otgorm.DebugHandler(db))
+func DebugHandler(db *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		val, ok := db.Get(CallbacksGormKey)
+		c, _ := val.(*callbacks)
+		if !ok || c == nil {
+			http.Error(w, "otgorm: callbacks aren't added to db", http.StatusNotFound)
+			return
+		}
+
+		if traceID := r.URL.Query().Get("trace"); traceID != "" {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(RecordedStatements(db, traceID))
+			return
+		}
+
+		st := debugStatus{
+			Callbacks: make(map[string]bool),
+			Config:    c.config().debugConfig(),
+			Spans:     make(map[string]int64),
+			Untraced:  atomic.LoadInt64(&c.status.untraced),
+			Panics:    atomic.LoadInt64(&c.status.panics),
+		}
+		if total, spans := c.status.overhead(); spans > 0 {
+			st.Overhead = float64(total) / float64(spans) / float64(time.Microsecond)
+		}
+		for _, p := range debugCallbacks {
+			for _, name := range append(p.names[:len(p.names):len(p.names)], p.operation...) {
+				st.Callbacks[fmt.Sprintf("%s/%s", p.processor, name)] = processor(db, p.processor).Get(name) != nil
+			}
+		}
+		c.status.spans.Range(func(key, val interface{}) bool {
+			st.Spans[key.(string)] = atomic.LoadInt64(val.(*int64))
+			return true
+		})
+		c.status.mu.Lock()
+		st.Errors = append([]instrumentationError{}, c.status.errors...)
+		c.status.mu.Unlock()
+		if c.config().noValues {
+			for i := range st.Errors {
+				st.Errors[i].Error = redactLiterals(st.Errors[i].Error)
+			}
+		}
+		if watchdog := c.config().watchdog; watchdog != nil {
+			st.ErrorRates = watchdog.alerting(time.Now())
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(st)
+	})
+}
@@ -0,0 +1,36 @@
+package otgorm
+
+import (
+	"context"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// Detach starts "detached" span following from the span of ctx, or of db traced by SetSpanToGorm if ctx has none,
+// and returns db traced with it for use in a spawned goroutine. The returned db doesn't share state with db and
+// isn't bound to ctx, so it isn't cancelled with the request and its queries don't finish after the request span.
+// Callers must finish the span when the goroutine is done
+func Detach(ctx context.Context, db *gorm.DB) (*gorm.DB, opentracing.Span) {
+	var parent interface{}
+	if ctx != nil {
+		if sp := opentracing.SpanFromContext(ctx); sp != nil {
+			parent = sp
+		}
+	}
+	if parent == nil {
+		parent, _ = db.Get(ParentSpanGormKey)
+	}
+	sc, tr, ok := callbacksFromGorm(db).config().spanParent(parent)
+	if !ok {
+		tr = opentracing.GlobalTracer()
+	}
+	var opts []opentracing.StartSpanOption
+	if sc != nil {
+		opts = append(opts, opentracing.FollowsFrom(sc))
+	}
+	span := tr.StartSpan("detached", opts...)
+	// totals, summary and repeats of the request span aren't carried over, SetSpanToGorm starts new ones if enabled
+	db = db.Set(ParentStatsGormKey, nil)
+	return SetSpanToGorm(opentracing.ContextWithSpan(context.Background(), span), db), span
+}
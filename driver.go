@@ -0,0 +1,355 @@
+package otgorm
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// WrapDriver returns driver which traces Exec, Query, Prepare and Ping of its connections.
+// It's an alternative to callbacks which also covers raw usage of db.DB(), pings and prepared statements:
+//
+//	sql.Register("postgres-traced", otgorm.WrapDriver(&pq.Driver{}))
+//	sqlDB, err := sql.Open("postgres-traced", dsn)
+//	db, err := gorm.Open("postgres", sqlDB)
+//
+// Spans are children of the span of the context passed to *Context methods of sql.DB,
+// calls without span in the context are not traced. gorm v1 doesn't pass context to the driver,
+// so gorm queries still need callbacks to be traced
+func WrapDriver(d driver.Driver, opts ...Option) driver.Driver {
+	return &tracedDriver{driver: d, callbacks: newCallbacks(opts...)}
+}
+
+type tracedDriver struct {
+	driver    driver.Driver
+	callbacks *callbacks
+}
+
+func (d *tracedDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	tc := &tracedConn{conn: conn, callbacks: d.callbacks}
+	if d.callbacks.config().applicationName != "" {
+		d.callbacks.setConnApplicationName(conn)
+	}
+	if query := d.callbacks.config().backendPIDQuery; query != "" {
+		tc.backendPID = queryBackendPID(conn, query)
+	}
+	return tc, nil
+}
+
+// queryBackendPID returns id of the server process of the new connection, it returns 0 if the query fails
+func queryBackendPID(conn driver.Conn, query string) int64 {
+	queryer, ok := conn.(driver.QueryerContext)
+	if !ok {
+		return 0
+	}
+	rows, err := queryer.QueryContext(context.Background(), query, nil)
+	if err != nil {
+		return 0
+	}
+	defer rows.Close()
+	dest := make([]driver.Value, len(rows.Columns()))
+	if len(dest) != 1 || rows.Next(dest) != nil {
+		return 0
+	}
+	pid, _ := dest[0].(int64)
+	return pid
+}
+
+// driverSpanContextKey marks context passed to the wrapped driver, so stacked wrappers don't trace calls twice
+type driverSpanContextKey struct{}
+
+// startDriverSpan starts driver span if ctx carries parent span, returns context for the wrapped driver
+func (c *callbacks) startDriverSpan(ctx context.Context, name string, query string) (opentracing.Span, time.Time, context.Context) {
+	cfg := c.config()
+	if !c.enabled() {
+		return nil, time.Time{}, ctx
+	}
+	parentSpan := opentracing.SpanFromContext(ctx)
+	if parentSpan == nil {
+		return nil, time.Time{}, ctx
+	}
+	duplicate := ctx.Value(driverSpanContextKey{}) != nil
+	if duplicate && cfg.duplicateMode == DuplicateSuppress {
+		return nil, time.Time{}, ctx
+	}
+
+	start := time.Now()
+	tr := cfg.spanTracer(parentSpan.Tracer())
+	sp := cfg.sanitizeSpan(tr.StartSpan(name, opentracing.ChildOf(parentSpan.Context()), opentracing.StartTime(start)))
+	if cfg.has(TagType) {
+		ext.DBType.Set(sp, "sql")
+	}
+	if query != "" && cfg.has(TagMethod) {
+		sp.SetTag("db.method", queryOperation(sp, query))
+	}
+	if pool := cfg.poolName; pool != "" {
+		sp.SetTag("db.pool.name", pool)
+	}
+	if duplicate {
+		sp.SetTag("db.duplicate", true)
+	}
+	return sp, start, context.WithValue(ctx, driverSpanContextKey{}, sp)
+}
+
+func (c *callbacks) finishDriverSpan(sp opentracing.Span, start time.Time, query string, args []driver.NamedValue, err error) {
+	cfg := c.config()
+	if sp == nil {
+		return
+	}
+	if err == driver.ErrSkip {
+		// database/sql retries with prepared statement, it's traced separately
+		sp.SetTag("db.skipped", true)
+		err = nil
+	}
+	ext.Error.Set(sp, err != nil)
+	if err != nil {
+		c.setError(sp, err, cfg)
+	}
+
+	finish := time.Now()
+	if cfg.has(TagDuration) {
+		sp.SetTag("db.duration_ms", float64(finish.Sub(start))/float64(time.Millisecond))
+	}
+	slow := c.setSlow(sp, err != nil, finish.Sub(start), cfg)
+
+	if query != "" {
+		vars := make([]interface{}, len(args))
+		for i, arg := range args {
+			vars[i] = arg.Value
+		}
+		if cfg.has(TagParams) {
+			c.setParams(sp, vars, cfg)
+		}
+		c.setStatement(sp, query, vars, c.captureOnFailure(c.statementCapture("", query, cfg), err != nil, slow, cfg), cfg)
+	}
+	sp.FinishWithOptions(opentracing.FinishOptions{FinishTime: finish})
+}
+
+type tracedConn struct {
+	conn      driver.Conn
+	callbacks *callbacks
+	// backendPID is id of the server process of the connection, it's 0 unless WithBackendPID is used
+	backendPID int64
+}
+
+func (c *tracedConn) startSpan(ctx context.Context, name string, query string) (opentracing.Span, time.Time, context.Context) {
+	sp, start, ctx := c.callbacks.startDriverSpan(ctx, name, query)
+	if sp != nil && c.backendPID != 0 {
+		sp.SetTag("db.backend_pid", c.backendPID)
+	}
+	return sp, start, ctx
+}
+
+func (c *tracedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *tracedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	sp, start, ctx := c.startSpan(ctx, "sql:prepare", query)
+	var stmt driver.Stmt
+	var err error
+	if prep, ok := c.conn.(driver.ConnPrepareContext); ok {
+		stmt, err = prep.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.conn.Prepare(query)
+	}
+	c.callbacks.finishDriverSpan(sp, start, query, nil, err)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedStmt{stmt: stmt, query: query, conn: c, callbacks: c.callbacks}, nil
+}
+
+func (c *tracedConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *tracedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if begin, ok := c.conn.(driver.ConnBeginTx); ok {
+		return begin.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != 0 || opts.ReadOnly {
+		return nil, errors.New("otgorm: driver doesn't support transaction options")
+	}
+	return c.conn.Begin()
+}
+
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	sp, start, ctx := c.startSpan(ctx, "sql", query)
+	execStart := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	c.callbacks.execs.record(query, execStart, c.backendPID)
+	if err == nil && sp != nil && c.callbacks.config().has(TagCount) {
+		if count, err := result.RowsAffected(); err == nil {
+			sp.SetTag("db.count", count)
+		}
+	}
+	c.callbacks.finishDriverSpan(sp, start, query, args, err)
+	return result, err
+}
+
+func (c *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	sp, start, ctx := c.startSpan(ctx, "sql", query)
+	execStart := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	c.callbacks.execs.record(query, execStart, c.backendPID)
+	c.callbacks.finishDriverSpan(sp, start, query, args, err)
+	return rows, err
+}
+
+func (c *tracedConn) Ping(ctx context.Context) error {
+	pinger, ok := c.conn.(driver.Pinger)
+	if !ok {
+		return nil
+	}
+	sp, start, ctx := c.startSpan(ctx, "sql:ping", "")
+	err := pinger.Ping(ctx)
+	c.callbacks.finishDriverSpan(sp, start, "", nil, err)
+	return err
+}
+
+// CheckNamedValue delegates to the checker of the wrapped connection, values of connections without one
+// are converted as database/sql does by default
+func (c *tracedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	var err error
+	nv.Value, err = driver.DefaultParameterConverter.ConvertValue(nv.Value)
+	return err
+}
+
+func (c *tracedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+type tracedStmt struct {
+	stmt      driver.Stmt
+	query     string
+	conn      *tracedConn
+	callbacks *callbacks
+	// uses is the number of executions of the statement, accessed atomically
+	uses int64
+}
+
+func (s *tracedStmt) Close() error {
+	return s.stmt.Close()
+}
+
+func (s *tracedStmt) NumInput() int {
+	return s.stmt.NumInput()
+}
+
+// CheckNamedValue delegates to the checker of the wrapped statement or connection, otherwise database/sql
+// falls back to ColumnConverter
+func (s *tracedStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := s.stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	if checker, ok := s.conn.conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// ColumnConverter returns the converter of the wrapped statement, it converts driver specific types
+func (s *tracedStmt) ColumnConverter(idx int) driver.ValueConverter {
+	if converter, ok := s.stmt.(driver.ColumnConverter); ok {
+		return converter.ColumnConverter(idx)
+	}
+	return driver.DefaultParameterConverter
+}
+
+func (s *tracedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), valuesToNamedValues(args))
+}
+
+func (s *tracedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), valuesToNamedValues(args))
+}
+
+// startSpan starts span of the statement execution, statement executed before is a prepared statement cache hit
+func (s *tracedStmt) startSpan(ctx context.Context) (opentracing.Span, time.Time, context.Context) {
+	uses := atomic.AddInt64(&s.uses, 1)
+	sp, start, ctx := s.conn.startSpan(ctx, "sql", s.query)
+	if sp != nil {
+		sp.SetTag("db.prepared", true)
+		sp.SetTag("db.prepared.cache_hit", uses > 1)
+	}
+	return sp, start, ctx
+}
+
+func (s *tracedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	sp, start, ctx := s.startSpan(ctx)
+	var result driver.Result
+	var err error
+	execStart := time.Now()
+	if execer, ok := s.stmt.(driver.StmtExecContext); ok {
+		result, err = execer.ExecContext(ctx, args)
+	} else {
+		result, err = s.stmt.Exec(namedValuesToValues(args))
+	}
+	s.callbacks.execs.record(s.query, execStart, s.conn.backendPID)
+	if err == nil && sp != nil && s.callbacks.config().has(TagCount) {
+		if count, err := result.RowsAffected(); err == nil {
+			sp.SetTag("db.count", count)
+		}
+	}
+	s.callbacks.finishDriverSpan(sp, start, s.query, args, err)
+	return result, err
+}
+
+func (s *tracedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	sp, start, ctx := s.startSpan(ctx)
+	var rows driver.Rows
+	var err error
+	execStart := time.Now()
+	if queryer, ok := s.stmt.(driver.StmtQueryContext); ok {
+		rows, err = queryer.QueryContext(ctx, args)
+	} else {
+		rows, err = s.stmt.Query(namedValuesToValues(args))
+	}
+	s.callbacks.execs.record(s.query, execStart, s.conn.backendPID)
+	s.callbacks.finishDriverSpan(sp, start, s.query, args, err)
+	return rows, err
+}
+
+func valuesToNamedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+func namedValuesToValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
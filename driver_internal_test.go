@@ -0,0 +1,240 @@
+package otgorm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/mattn/go-sqlite3"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func TestBackendPID(t *testing.T) {
+	// sqlite has no pg_backend_pid(), any query returning a number does
+	c := newCallbacks(func(o *options) {
+		o.backendPIDQuery = "SELECT 42"
+	})
+	sql.Register("sqlite3-backend-pid", &tracedDriver{driver: &sqlite3.SQLiteDriver{}, callbacks: c})
+
+	db, err := sql.Open("sqlite3-backend-pid", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tracer := mocktracer.New()
+	span := tracer.StartSpan("test")
+	if _, err := db.ExecContext(opentracing.ContextWithSpan(context.Background(), span), "SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+	span.Finish()
+
+	if pid := tracer.FinishedSpans()[0].Tag("db.backend_pid"); pid != int64(42) {
+		t.Errorf("sql span tag 'db.backend_pid' should be 42 but it's '%v'", pid)
+	}
+
+	gdb, err := gorm.Open("sqlite3", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AddGormCallbacks(gdb, WithBackendPID())
+	tracer.Reset()
+	span = tracer.StartSpan("test")
+	var one int
+	if err := SetSpanToGorm(context.Background(), gdb).Raw("SELECT 1").Row().Scan(&one); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetSpanToGorm(opentracing.ContextWithSpan(context.Background(), span), gdb).Raw("SELECT 2").Row().Scan(&one); err != nil {
+		t.Fatal(err)
+	}
+	span.Finish()
+	if spans := tracer.FinishedSpans(); len(spans) != 2 || spans[0].Tag("db.backend_pid") != int64(42) {
+		t.Errorf("gorm sql span should have tag 'db.backend_pid' 42 but spans are %v", spans)
+	}
+
+	// execution of another goroutine isn't taken for the query of this one
+	c.execs.record("SELECT 3", time.Now(), 7)
+	tracer.Reset()
+	span = tracer.StartSpan("test")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tdb := SetSpanToGorm(opentracing.ContextWithSpan(context.Background(), span), gdb)
+		if err := tdb.Raw("SELECT 3").Row().Scan(&one); err != nil {
+			t.Error(err)
+		}
+	}()
+	<-done
+	span.Finish()
+	if pid := tracer.FinishedSpans()[0].Tag("db.backend_pid"); pid != int64(42) {
+		t.Errorf("gorm sql span should have backend pid of its own execution but it's '%v'", pid)
+	}
+	if exec, ok := c.execs.take("SELECT 3"); !ok || exec.backendPID != 7 {
+		t.Errorf("execution of this goroutine should be kept but it's %v", exec)
+	}
+}
+
+func TestApplicationName(t *testing.T) {
+	c := newCallbacks(WithApplicationName("billing"))
+	sql.Register("sqlite3-application-name", &tracedDriver{driver: &sqlite3.SQLiteDriver{}, callbacks: c})
+
+	db, err := sql.Open("sqlite3-application-name", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	// sqlite has no application_name, the failed statement is reported as the error of the instrumentation
+	c.status.mu.Lock()
+	defer c.status.mu.Unlock()
+	if len(c.status.errors) != 1 || c.status.errors[0].Source != "application name" {
+		t.Errorf("new connection should set application_name but errors are %+v", c.status.errors)
+	}
+}
+
+func TestExecTimings(t *testing.T) {
+	var execs execTimings
+	execs.record("SELECT 1", time.Now(), 0)
+	if _, ok := execs.take("SELECT 1"); ok {
+		t.Error("execution times shouldn't be recorded until callbacks are linked")
+	}
+
+	execs.enabled = 1
+	for i := 0; i < maxExecTimings; i++ {
+		execs.record(fmt.Sprintf("SELECT %d", i), time.Now(), 0)
+	}
+	if _, ok := execs.take("SELECT 1"); !ok {
+		t.Error("execution time of the statement should be recorded")
+	}
+	if _, ok := execs.take("SELECT 1"); ok {
+		t.Error("execution time should be taken once")
+	}
+	execs.record("SELECT 1", time.Now(), 0)
+	execs.record("SELECT -1", time.Now(), 0)
+	if len(execs.execs) != maxExecTimings {
+		t.Errorf("only the oldest execution time should be dropped but there are %d", len(execs.execs))
+	}
+	if _, ok := execs.take("SELECT 0"); ok {
+		t.Error("the oldest execution time should be dropped")
+	}
+	if _, ok := execs.take("SELECT 2"); !ok {
+		t.Error("execution times which aren't stale should be kept")
+	}
+
+	execs.record("SELECT 3", time.Now().Add(-2*execTimingTTL), 0)
+	execs.record("SELECT 4", time.Now().Add(-2*execTimingTTL), 0)
+	execs.record("SELECT -2", time.Now(), 0)
+	execs.record("SELECT -3", time.Now(), 0)
+	if _, ok := execs.take("SELECT 3"); ok {
+		t.Error("execution times older than TTL should be dropped")
+	}
+	if _, ok := execs.take("SELECT 5"); !ok {
+		t.Error("execution times which aren't stale should be kept")
+	}
+
+	// concurrent executions of the same statement are told apart by goroutine
+	var wg sync.WaitGroup
+	for i := 1; i <= 8; i++ {
+		wg.Add(1)
+		go func(pid int64) {
+			defer wg.Done()
+			execs.record("SELECT shared", time.Now(), pid)
+			runtime.Gosched()
+			if exec, ok := execs.take("SELECT shared"); !ok || exec.backendPID != pid {
+				t.Errorf("execution of the goroutine should have backend pid %d but it's %v", pid, exec)
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+}
+
+// upperConverter converts strings to upper case
+type upperConverter struct{}
+
+func (upperConverter) ConvertValue(v interface{}) (driver.Value, error) {
+	if s, ok := v.(string); ok {
+		return strings.ToUpper(s), nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(v)
+}
+
+// convDriver records args of statements which convert values with upperConverter
+type convDriver struct {
+	args *[]driver.Value
+}
+
+func (d convDriver) Open(name string) (driver.Conn, error) { return convConn(d), nil }
+
+type convConn struct {
+	args *[]driver.Value
+}
+
+func (c convConn) Prepare(query string) (driver.Stmt, error) { return convStmt(c), nil }
+func (c convConn) Close() error                              { return nil }
+func (c convConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+// checkerConn converts values with upperConverter
+type checkerConn struct {
+	convConn
+}
+
+func (c checkerConn) CheckNamedValue(nv *driver.NamedValue) error {
+	var err error
+	nv.Value, err = upperConverter{}.ConvertValue(nv.Value)
+	return err
+}
+
+type convStmt struct {
+	args *[]driver.Value
+}
+
+func (s convStmt) Close() error  { return nil }
+func (s convStmt) NumInput() int { return -1 }
+
+func (s convStmt) Exec(args []driver.Value) (driver.Result, error) {
+	*s.args = args
+	return driver.RowsAffected(1), nil
+}
+
+func (s convStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s convStmt) ColumnConverter(idx int) driver.ValueConverter { return upperConverter{} }
+
+func TestWrapDriverConverters(t *testing.T) {
+	var args []driver.Value
+	sql.Register("conv-traced", &tracedDriver{driver: convDriver{args: &args}, callbacks: newCallbacks()})
+	db, err := sql.Open("conv-traced", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("INSERT", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 1 || args[0] != "ABC" {
+		t.Errorf("args should be converted by the column converter of the statement but they are %v", args)
+	}
+
+	nv := driver.NamedValue{Ordinal: 1, Value: "abc"}
+	if err := (&tracedConn{conn: checkerConn{}}).CheckNamedValue(&nv); err != nil || nv.Value != "ABC" {
+		t.Errorf("value should be checked by the connection but it's %v: %v", nv.Value, err)
+	}
+	nv = driver.NamedValue{Ordinal: 1, Value: 1}
+	if err := (&tracedConn{conn: convConn{}}).CheckNamedValue(&nv); err != nil || nv.Value != int64(1) {
+		t.Errorf("value should be converted by default converter but it's %v: %v", nv.Value, err)
+	}
+}
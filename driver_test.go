@@ -0,0 +1,246 @@
+package otgorm_test
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/mattn/go-sqlite3"
+	"github.com/opentracing/opentracing-go"
+	otgorm "github.com/smacker/opentracing-gorm"
+)
+
+func init() {
+	sql.Register("sqlite3-traced", otgorm.WrapDriver(&sqlite3.SQLiteDriver{}))
+	sql.Register("sqlite3-traced-twice", otgorm.WrapDriver(otgorm.WrapDriver(&sqlite3.SQLiteDriver{})))
+	sql.Register("sqlite3-traced-twice-tagged", otgorm.WrapDriver(
+		otgorm.WrapDriver(&sqlite3.SQLiteDriver{}, otgorm.WithDuplicateMode(otgorm.DuplicateTag)),
+	))
+	sql.Register("sqlite3-traced-table-capture", otgorm.WrapDriver(&sqlite3.SQLiteDriver{},
+		otgorm.WithTableCapture(otgorm.CaptureNone, "users")))
+}
+
+func TestWrapDriver(t *testing.T) {
+	db, err := sql.Open("sqlite3-traced", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	tracer.Reset()
+	defer tracer.Reset()
+
+	span := tracer.StartSpan("test")
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+	if err := db.PingContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, "CREATE TABLE products (code TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO products (code) VALUES ($1)", "L1212"); err != nil {
+		t.Fatal(err)
+	}
+	// calls without span in context are not traced
+	if _, err := db.Exec("DELETE FROM products"); err != nil {
+		t.Fatal(err)
+	}
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 4 {
+		t.Fatalf("should be 4 finished spans but there are %d: %v", len(spans), spans)
+	}
+	if spans[0].OperationName != "sql:ping" {
+		t.Errorf("first span operation should be sql:ping but it's '%s'", spans[0].OperationName)
+	}
+	insertSpan := spans[2]
+	if statement := insertSpan.Tag("db.statement"); statement != "INSERT INTO products (code) VALUES ('L1212')" {
+		t.Errorf("insert span tag 'db.statement' is wrong: '%v'", statement)
+	}
+	if count := insertSpan.Tag("db.count"); count != int64(1) {
+		t.Errorf("insert span tag 'db.count' should be 1 but it's '%v'", count)
+	}
+	if insertSpan.ParentID != spans[3].SpanContext.SpanID {
+		t.Errorf("driver span should be a child of parent span")
+	}
+}
+
+func TestWrapDriverTableCapture(t *testing.T) {
+	db, err := sql.Open("sqlite3-traced-table-capture", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("CREATE TABLE users (email TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE products (code TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	tracer.Reset()
+	defer tracer.Reset()
+
+	span := tracer.StartSpan("test")
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+	for _, query := range []string{
+		"SELECT * FROM users WHERE email = 'john@example.com'",
+		"INSERT INTO \"main\".\"users\" (email) VALUES ('john@example.com')",
+		"SELECT * FROM products WHERE code = 'L1212'",
+	} {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	for _, sp := range spans[:2] {
+		if statement := sp.Tag("db.statement"); statement != nil {
+			t.Errorf("driver span of the table with CaptureNone shouldn't have statement but it's '%v'", statement)
+		}
+	}
+	if statement := spans[2].Tag("db.statement"); statement == nil {
+		t.Errorf("driver span of other tables should have statement")
+	}
+}
+
+func TestWrapDriverDuplicates(t *testing.T) {
+	for driverName, expected := range map[string]int{"sqlite3-traced-twice": 1, "sqlite3-traced-twice-tagged": 2} {
+		db, err := sql.Open(driverName, ":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tracer.Reset()
+
+		span := tracer.StartSpan("test")
+		if _, err := db.ExecContext(opentracing.ContextWithSpan(context.Background(), span), "SELECT 1"); err != nil {
+			t.Fatal(err)
+		}
+		span.Finish()
+		db.Close()
+
+		spans := tracer.FinishedSpans()
+		if len(spans) != expected+1 {
+			t.Errorf("%s should produce %d sql spans but there are %d: %v", driverName, expected, len(spans)-1, spans)
+		}
+		if expected > 1 && spans[0].Tag("db.duplicate") != true {
+			t.Errorf("%s duplicate span should have tag 'db.duplicate'", driverName)
+		}
+	}
+	tracer.Reset()
+}
+
+func TestWrapDriverPrepared(t *testing.T) {
+	db, err := sql.Open("sqlite3-traced", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	tracer.Reset()
+	defer tracer.Reset()
+
+	span := tracer.StartSpan("test")
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+	stmt, err := db.PrepareContext(ctx, "SELECT $1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := stmt.ExecContext(ctx, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stmt.Close()
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 4 {
+		t.Fatalf("should be 4 finished spans but there are %d: %v", len(spans), spans)
+	}
+	if spans[0].OperationName != "sql:prepare" {
+		t.Errorf("first span operation should be sql:prepare but it's '%s'", spans[0].OperationName)
+	}
+	for i, expected := range []bool{false, true} {
+		execSpan := spans[i+1]
+		if prepared := execSpan.Tag("db.prepared"); prepared != true {
+			t.Errorf("exec span tag 'db.prepared' should be true but it's '%v'", prepared)
+		}
+		if hit := execSpan.Tag("db.prepared.cache_hit"); hit != expected {
+			t.Errorf("exec span %d tag 'db.prepared.cache_hit' should be %v but it's '%v'", i+1, expected, hit)
+		}
+	}
+}
+
+func TestExecTiming(t *testing.T) {
+	sqlDB, err := sql.Open("sqlite3-traced", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open("sqlite3", sqlDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.DB().SetMaxOpenConns(1)
+	db.AutoMigrate(&Product{})
+	otgorm.AddGormCallbacks(db, otgorm.WithExecTiming())
+	tracer.Reset()
+	defer tracer.Reset()
+
+	tdb, span := tracedDB(db)
+	tdb.Create(&Product{Code: "L1212"})
+	tdb.Find(&[]Product{})
+	span.Finish()
+
+	for _, sp := range tracer.FinishedSpans()[:2] {
+		exec, ok := sp.Tag("db.exec_ms").(float64)
+		build, ok2 := sp.Tag("db.build_ms").(float64)
+		if !ok || !ok2 {
+			t.Fatalf("sql span should have tags 'db.exec_ms' and 'db.build_ms' but it has %v", sp.Tags())
+		}
+		if duration := sp.Tag("db.duration_ms").(float64); exec+build > duration+0.001 {
+			t.Errorf("db.exec_ms %v and db.build_ms %v should add up to db.duration_ms %v", exec, build, duration)
+		}
+	}
+}
+
+func TestCheckpointLogs(t *testing.T) {
+	sqlDB, err := sql.Open("sqlite3-traced", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open("sqlite3", sqlDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.DB().SetMaxOpenConns(1)
+	db.AutoMigrate(&Product{})
+	otgorm.AddGormCallbacks(db, otgorm.WithCheckpointLogs())
+	tracer.Reset()
+	defer tracer.Reset()
+
+	tdb, span := tracedDB(db)
+	tdb.Create(&Product{Code: "L1212"})
+	tdb.Find(&[]Product{})
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	for i, expected := range []string{
+		"sql.build.done,driver.exec.start,driver.exec.done",
+		"sql.build.done,driver.exec.start,driver.exec.done,scan.done",
+	} {
+		var events []string
+		for _, l := range spans[i].Logs() {
+			events = append(events, l.Fields[0].ValueString)
+		}
+		if strings.Join(events, ",") != expected {
+			t.Errorf("span %d logs should be %s but they are %v", i, expected, events)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package otgorm
+
+import (
+	"bytes"
+	"database/sql"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// maxExecTimings bounds execution times of statements which aren't taken, e.g. executed outside of gorm
+const maxExecTimings = 256
+
+// execTimingTTL is the age of execution times which aren't taken anymore, they are evicted once maxExecTimings is reached
+const execTimingTTL = time.Minute
+
+// execTimings are execution times of the last statements executed by connections of WrapDriver, they are recorded
+// once callbacks of gorm with WithExecTiming, WithCheckpointLogs or WithBackendPID are linked to the driver
+type execTimings struct {
+	// enabled is 1 once linked callbacks need execution times, accessed atomically
+	enabled int32
+
+	mu    sync.Mutex
+	execs map[execKey]execTiming
+}
+
+// execKey identifies the execution, database/sql calls the driver on the goroutine of gorm callbacks, which run
+// statements one by one, so the goroutine and the statement tell concurrent executions of the same statement apart
+type execKey struct {
+	goroutine uint64
+	query     string
+}
+
+type execTiming struct {
+	start    time.Time
+	duration time.Duration
+	// backendPID is id of the server process of the connection, it's 0 unless the driver uses WithBackendPID
+	backendPID int64
+}
+
+// record records driver execution of the query started at start by the connection with backendPID
+func (t *execTimings) record(query string, start time.Time, backendPID int64) {
+	if atomic.LoadInt32(&t.enabled) == 0 || query == "" {
+		return
+	}
+	duration := time.Since(start)
+	key := execKey{goroutine: goroutineID(), query: query}
+	t.mu.Lock()
+	if t.execs == nil {
+		t.execs = make(map[execKey]execTiming)
+	}
+	if _, ok := t.execs[key]; !ok && len(t.execs) >= maxExecTimings {
+		t.evict(start)
+	}
+	t.execs[key] = execTiming{start: start, duration: duration, backendPID: backendPID}
+	t.mu.Unlock()
+}
+
+// evict drops execution times older than execTimingTTL, or the oldest one if none is, so executions which are
+// about to be taken are kept
+func (t *execTimings) evict(now time.Time) {
+	var oldest execKey
+	var oldestStart time.Time
+	evicted := false
+	for key, exec := range t.execs {
+		if now.Sub(exec.start) > execTimingTTL {
+			delete(t.execs, key)
+			evicted = true
+			continue
+		}
+		if oldestStart.IsZero() || exec.start.Before(oldestStart) {
+			oldest, oldestStart = key, exec.start
+		}
+	}
+	if !evicted {
+		delete(t.execs, oldest)
+	}
+}
+
+// take returns driver execution of the query by the current goroutine and forgets it
+func (t *execTimings) take(query string) (execTiming, bool) {
+	key := execKey{goroutine: goroutineID(), query: query}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	exec, ok := t.execs[key]
+	if ok {
+		delete(t.execs, key)
+	}
+	return exec, ok
+}
+
+// goroutineID returns id of the current goroutine parsed from the header of its stack trace
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := bytes.TrimPrefix(buf[:runtime.Stack(buf[:], false)], []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+// linkDriver links callbacks to execution times of the driver of db if it's opened with WrapDriver,
+// gorm v1 doesn't pass context to the driver, so the statement is the only link between them
+func (c *callbacks) linkDriver(db *gorm.DB) {
+	sqlDB, ok := db.CommonDB().(*sql.DB)
+	if !ok {
+		return
+	}
+	if d, ok := sqlDB.Driver().(*tracedDriver); ok {
+		c.driver = d.callbacks
+		c.enableExecTimings()
+		if c.config().noValues {
+			c.driver.enforceNoValues()
+		}
+	}
+}
+
+// enableExecTimings starts recording execution times by the linked driver if the config needs them
+func (c *callbacks) enableExecTimings() {
+	if cfg := c.config(); c.driver != nil && (cfg.execTiming || cfg.checkpointLogs || cfg.backendPIDQuery != "") {
+		atomic.StoreInt32(&c.driver.execs.enabled, 1)
+	}
+}
+
+// takeExecTime returns driver execution of the query recorded by the linked driver
+func (c *callbacks) takeExecTime(query string) (execTiming, bool) {
+	if c.driver == nil {
+		return execTiming{}, false
+	}
+	return c.driver.execs.take(query)
+}
+
+// setExecTime splits duration of the sql span into db.exec_ms spent in the driver and db.build_ms spent in gorm
+func setExecTime(sp opentracing.Span, exec execTiming, total time.Duration) {
+	if exec.duration > total {
+		return
+	}
+	sp.SetTag("db.exec_ms", float64(exec.duration)/float64(time.Millisecond))
+	sp.SetTag("db.build_ms", float64(total-exec.duration)/float64(time.Millisecond))
+}
+
+// checkpointLogs returns span logs of WithCheckpointLogs, driver checkpoints are logged if the driver execution
+// of the query is known
+func checkpointLogs(exec execTiming, execOK bool, operation string, finish time.Time) []opentracing.LogRecord {
+	var logs []opentracing.LogRecord
+	if execOK {
+		logs = append(logs,
+			opentracing.LogRecord{Timestamp: exec.start, Fields: []log.Field{log.String("event", "sql.build.done")}},
+			opentracing.LogRecord{Timestamp: exec.start, Fields: []log.Field{log.String("event", "driver.exec.start")}},
+			opentracing.LogRecord{Timestamp: exec.start.Add(exec.duration), Fields: []log.Field{log.String("event", "driver.exec.done")}},
+		)
+	}
+	// gorm scans query results inside its query callback
+	if operation == "SELECT" {
+		logs = append(logs, opentracing.LogRecord{Timestamp: finish, Fields: []log.Field{log.String("event", "scan.done")}})
+	}
+	return logs
+}
@@ -0,0 +1,106 @@
+package otgorm
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// explainPrefixes are prefixes of query which return its plan by dialect
+var explainPrefixes = map[string]string{
+	"postgres": "EXPLAIN (ANALYZE, BUFFERS) ",
+	"mysql":    "EXPLAIN ANALYZE ",
+	"sqlite3":  "EXPLAIN QUERY PLAN ",
+}
+
+// shouldExplain reports whether plan of the SELECT should be sampled
+func (c *callbacks) shouldExplain(scope *gorm.Scope, cfg *config) bool {
+	if cfg.explainRate <= 0 || scope.HasError() {
+		return false
+	}
+	if len(cfg.explainTables) > 0 && !cfg.explainTables[scope.TableName()] {
+		return false
+	}
+	return rand.Float64() < cfg.explainRate
+}
+
+// planQuery is the query returning plan of the sampled SELECT, it's run when the span is finished
+type planQuery struct {
+	ctx   context.Context
+	db    *sql.DB
+	query string
+	vars  []interface{}
+}
+
+// planQuery returns the query with the plan prefix of the dialect, which is run on a dedicated connection.
+// Queries inside transactions aren't explained, they may see data the other connection doesn't
+func (c *callbacks) planQuery(scope *gorm.Scope) *planQuery {
+	prefix, ok := explainPrefixes[scope.Dialect().GetName()]
+	if !ok {
+		return nil
+	}
+	sqlDB, ok := scope.DB().CommonDB().(*sql.DB)
+	if !ok {
+		return nil
+	}
+
+	ctx := context.Background()
+	// workers of WithAsyncFinish may query the plan after the request is over and its context is canceled
+	if scopeCtx, ok := contextFromScope(scope); ok && c.finisher == nil {
+		ctx = scopeCtx
+	}
+	return &planQuery{ctx: ctx, db: sqlDB, query: prefix + scope.SQL, vars: scope.SQLVars}
+}
+
+// explain runs the plan query and logs the plan to the span
+func (c *callbacks) explain(sp opentracing.Span, q *planQuery) {
+	plan, err := queryPlan(q.ctx, q.db, q.query, q.vars)
+	if err != nil {
+		sp.LogFields(log.String("event", "explain failed"), log.Error(err))
+		c.status.reportError("explain", err)
+		return
+	}
+	sp.LogFields(log.String("event", "explain"), log.String("db.plan", plan))
+}
+
+// queryPlan returns rows of the plan query as lines with columns separated by spaces
+func queryPlan(ctx context.Context, sqlDB *sql.DB, query string, vars []interface{}) (string, error) {
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	rows, err := conn.QueryContext(ctx, query, vars...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+
+	var lines []string
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return "", err
+		}
+		fields := make([]string, len(values))
+		for i, value := range values {
+			fields[i] = value.String
+		}
+		lines = append(lines, strings.Join(fields, " "))
+	}
+	return strings.Join(lines, "\n"), rows.Err()
+}
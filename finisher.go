@@ -0,0 +1,136 @@
+package otgorm
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// errAsyncQueueFull is reported when the span is finished synchronously
+var errAsyncQueueFull = errors.New("queue is full, span is finished synchronously")
+
+// finishJob is a span waiting for db.statement and Finish
+type finishJob struct {
+	sp      opentracing.Span
+	query   string
+	vars    []interface{}
+	capture Capture
+	finish  time.Time
+	// logs are logged with their timestamps when the span is finished
+	logs []opentracing.LogRecord
+
+	// overhead is time spent in the callbacks before afterStart
+	overhead   time.Duration
+	afterStart time.Time
+	// cfg is the config the span was traced with, so UpdateConfig doesn't change it halfway
+	cfg *config
+	// plan is the plan query of WithExplainAnalyze, it's run by workers of WithAsyncFinish
+	plan *planQuery
+}
+
+// asyncFinisher interpolates statements and finishes spans in a pool of workers
+type asyncFinisher struct {
+	jobs chan finishJob
+
+	mu      sync.Mutex
+	done    *sync.Cond
+	pending int
+}
+
+func newAsyncFinisher(c *callbacks, workers, queue int) *asyncFinisher {
+	f := &asyncFinisher{jobs: make(chan finishJob, queue)}
+	f.done = sync.NewCond(&f.mu)
+	for i := 0; i < workers; i++ {
+		go f.work(c)
+	}
+	return f
+}
+
+func (f *asyncFinisher) work(c *callbacks) {
+	for job := range f.jobs {
+		f.finish(c, job)
+	}
+}
+
+func (f *asyncFinisher) finish(c *callbacks, job finishJob) {
+	defer f.release()
+	defer c.recoverSpan(job.sp)
+	c.setStatement(job.sp, job.query, job.vars, job.capture, job.cfg)
+	c.setOverhead(job.sp, job.overhead, job.cfg)
+	if job.plan != nil {
+		c.explain(job.sp, job.plan)
+	}
+	job.sp.FinishWithOptions(opentracing.FinishOptions{FinishTime: job.finish, LogRecords: job.logs})
+}
+
+func (f *asyncFinisher) release() {
+	f.mu.Lock()
+	f.pending--
+	if f.pending == 0 {
+		f.done.Broadcast()
+	}
+	f.mu.Unlock()
+}
+
+// enqueue hands the job to workers, it returns false if the queue is full
+func (f *asyncFinisher) enqueue(job finishJob) bool {
+	f.mu.Lock()
+	f.pending++
+	f.mu.Unlock()
+
+	select {
+	case f.jobs <- job:
+		return true
+	default:
+		f.release()
+		return false
+	}
+}
+
+// wait blocks until all enqueued spans are finished
+func (f *asyncFinisher) wait() {
+	f.mu.Lock()
+	for f.pending > 0 {
+		f.done.Wait()
+	}
+	f.mu.Unlock()
+}
+
+// finishSpan sets db.statement and finishes span, the work is done by the workers of WithAsyncFinish
+// unless their queue is full
+func (c *callbacks) finishSpan(job finishJob) {
+	if c.finisher != nil {
+		// the statement is interpolated by workers, so it isn't overhead of the query
+		async := job
+		async.overhead += time.Since(job.afterStart)
+		if c.finisher.enqueue(async) {
+			return
+		}
+		c.status.reportError("async finish", errAsyncQueueFull)
+	}
+	c.setStatement(job.sp, job.query, job.vars, job.capture, job.cfg)
+	// the plan query isn't overhead of the callbacks, it's a separate query
+	c.setOverhead(job.sp, job.overhead+time.Since(job.afterStart), job.cfg)
+	if job.plan != nil {
+		c.explain(job.sp, job.plan)
+	}
+	job.sp.FinishWithOptions(opentracing.FinishOptions{FinishTime: job.finish, LogRecords: job.logs})
+}
+
+// Flush waits until spans of db finished asynchronously with WithAsyncFinish are finished,
+// call it before closing the tracer. For db returned by SetSpanToGorm it also finishes the span
+// of repeated statements of WithRepeatCompression
+func Flush(db *gorm.DB) {
+	c := callbacksFromGorm(db)
+	if val, ok := db.Get(ParentStatsGormKey); ok {
+		if stats, ok := val.(*parentStats); ok && stats != nil {
+			c.flushRepeat(stats, nil)
+		}
+	}
+	if c.finisher != nil {
+		c.finisher.wait()
+	}
+}
@@ -0,0 +1,40 @@
+package otgorm
+
+import (
+	"sync"
+)
+
+// ValueConverter converts driver specific value of a statement var into a value db.statement knows
+// how to format: nil, number, bool, string, []byte or time.Time. It returns false if it doesn't handle the value
+type ValueConverter func(val interface{}) (interface{}, bool)
+
+var (
+	convertersMu sync.RWMutex
+	converters   []ValueConverter
+)
+
+// RegisterValueConverter registers converter of driver specific values, registered converters are tried
+// in order of registration before the built-in formatting. It's usually called from init of packages like pqtypes:
+//
+//	import _ "github.com/smacker/opentracing-gorm/pqtypes"
+func RegisterValueConverter(f ValueConverter) {
+	convertersMu.Lock()
+	defer convertersMu.Unlock()
+	converters = append(converters, f)
+}
+
+// FormatValue formats var of a statement as SQL literal the same way it's rendered in db.statement tag with default options
+func FormatValue(val interface{}) string {
+	return formatValue(val, defaultOptions())
+}
+
+func convertRegistered(val interface{}) (interface{}, bool) {
+	convertersMu.RLock()
+	defer convertersMu.RUnlock()
+	for _, f := range converters {
+		if v, ok := f(val); ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,40 @@
+package otgorm
+
+import (
+	"context"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// StartJobSpan starts span for background job (worker, cron) and returns context with the span,
+// the span and db traced with it. The span is a root span unless ctx already carries one.
+// The span is started by the tracer of WithTracer or WithGlobalTracer if they are used.
+// The returned context carries the traced db as well, see FromContext. Callers must finish the span,
+// which calls Summarize with the traced db first
+func StartJobSpan(ctx context.Context, db *gorm.DB, jobName string) (context.Context, opentracing.Span, *gorm.DB) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	span, ctx := callbacksFromGorm(db).config().startSpanFromContext(ctx, jobName)
+	span.SetTag("job.name", jobName)
+
+	traced := SetSpanToGorm(ctx, db)
+	return NewContext(ctx, traced), &jobSpan{Span: span, db: traced}, traced
+}
+
+// jobSpan finishes repeated statements and the summary of the job before the span of the job
+type jobSpan struct {
+	opentracing.Span
+	db *gorm.DB
+}
+
+func (s *jobSpan) Finish() {
+	Summarize(s.db)
+	s.Span.Finish()
+}
+
+func (s *jobSpan) FinishWithOptions(opts opentracing.FinishOptions) {
+	Summarize(s.db)
+	s.Span.FinishWithOptions(opts)
+}
@@ -0,0 +1,28 @@
+package otgorm
+
+import "sync/atomic"
+
+// disabled is 1 while tracing is disabled by Disable, accessed atomically
+var disabled int32
+
+// Disable turns callbacks and WrapDriver connections of all dbs into near no-ops until Enable is called,
+// so instrumentation overhead can be shed at runtime, e.g. during an incident. Queries already traced finish their spans
+func Disable() {
+	atomic.StoreInt32(&disabled, 1)
+}
+
+// Enable resumes tracing disabled by Disable
+func Enable() {
+	atomic.StoreInt32(&disabled, 0)
+}
+
+// enabled reports whether queries are traced, it's checked once per query before any other work
+func (c *callbacks) enabled() bool {
+	if atomic.LoadInt32(&disabled) == 1 {
+		return false
+	}
+	if f := c.config().enabledFunc; f != nil {
+		return f()
+	}
+	return true
+}
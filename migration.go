@@ -0,0 +1,91 @@
+package otgorm
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// TraceMigration runs fn (AutoMigrate, CreateTable, AddIndex, DropColumn etc.) inside "gorm:migration" span,
+// every DDL statement executed by fn is reported as a child "sql" span.
+// gorm executes DDL without callbacks, so statements are captured with gorm logger of the db passed to fn.
+// The span is started by the tracer of WithTracer or WithGlobalTracer if they are used
+func TraceMigration(ctx context.Context, db *gorm.DB, fn func(db *gorm.DB) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	opts := callbacksFromGorm(db).config().options
+	span, ctx := opts.startSpanFromContext(ctx, "gorm:migration")
+	span = opts.sanitizeSpan(span)
+	defer span.Finish()
+
+	tx := SetSpanToGorm(ctx, db).LogMode(true)
+	tx.SetLogger(&migrationLogger{
+		span:    span,
+		dialect: db.Dialect().GetName(),
+		opts:    opts,
+	})
+
+	err := fn(tx)
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.SetTag("db.err", redactedError(err, opts))
+		logError(span, err, opts)
+	}
+	return err
+}
+
+// migrationLogger implements gorm logger and reports logged DDL statements as spans
+type migrationLogger struct {
+	span    opentracing.Span
+	dialect string
+	opts    options
+}
+
+// Print receives gorm log entries, sql entries are: "sql", source, duration, sql, vars, rows affected
+func (l *migrationLogger) Print(v ...interface{}) {
+	if len(v) < 6 || v[0] != "sql" {
+		return
+	}
+	duration, _ := v[2].(time.Duration)
+	query, _ := v[3].(string)
+	vars, _ := v[4].([]interface{})
+
+	operation, ok := ddlOperation(query)
+	if !ok {
+		// DML statements are traced by callbacks
+		return
+	}
+
+	finish := time.Now()
+	sp := l.opts.sanitizeSpan(l.span.Tracer().StartSpan("sql",
+		opentracing.ChildOf(l.span.Context()),
+		opentracing.StartTime(finish.Add(-duration)),
+	))
+	if l.opts.has(TagType) {
+		ext.DBType.Set(sp, dialectDBType(l.dialect))
+	}
+	if l.opts.has(TagMethod) {
+		sp.SetTag("db.method", operation)
+	}
+	if l.opts.has(TagDuration) {
+		sp.SetTag("db.duration_ms", float64(duration)/float64(time.Millisecond))
+	}
+	if l.opts.has(TagStatement) {
+		ext.DBStatement.Set(sp, formatStatement(interpolate(query, vars, l.opts), l.opts.statementFormat))
+	}
+	sp.FinishWithOptions(opentracing.FinishOptions{FinishTime: finish})
+}
+
+// ddlOperation returns operation of DDL statement, e.g. CREATE TABLE or DROP INDEX
+func ddlOperation(query string) (string, bool) {
+	words := strings.Fields(skipSQLPrefix(query))
+	if len(words) == 0 || !ddlKeywords[strings.ToUpper(leadingWord(words[0]))] {
+		return "", false
+	}
+	return sqlOperation(query), true
+}
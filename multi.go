@@ -0,0 +1,72 @@
+package otgorm
+
+import (
+	"strings"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// multiOperation is db.method of queries with multiple statements
+const multiOperation = "MULTI"
+
+// splitStatements splits query by semicolons outside of quoted literals, identifiers and comments,
+// empty statements are dropped
+func splitStatements(query string) []string {
+	var statements []string
+	start := 0
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			if end := strings.IndexByte(query[i+1:], c); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(query)
+			}
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			if end := strings.IndexByte(query[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(query)
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			if end := strings.Index(query[i:], "*/"); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(query)
+			}
+		case c == ';':
+			statements = appendStatement(statements, query[start:i])
+			start = i + 1
+		}
+	}
+	if start < len(query) {
+		statements = appendStatement(statements, query[start:])
+	}
+	return statements
+}
+
+func appendStatement(statements []string, statement string) []string {
+	if skipSQLPrefix(statement) == "" {
+		return statements
+	}
+	return append(statements, statement)
+}
+
+// queryOperation returns operation of query, queries with multiple statements are tagged with db.multi_statement
+// and the number of statements as db.statement_count, operation of each statement is logged and MULTI is returned
+func queryOperation(sp opentracing.Span, query string) string {
+	if strings.IndexByte(query, ';') < 0 {
+		return sqlOperation(query)
+	}
+	statements := splitStatements(query)
+	if len(statements) < 2 {
+		return sqlOperation(query)
+	}
+	sp.SetTag("db.multi_statement", true)
+	sp.SetTag("db.statement_count", len(statements))
+	for i, statement := range statements {
+		sp.LogFields(log.String("event", "statement"), log.Int("db.statement_index", i), log.String("db.method", sqlOperation(statement)))
+	}
+	return multiOperation
+}
@@ -0,0 +1,27 @@
+// Package mysqltypes registers converters of github.com/go-sql-driver/mysql types for db.statement tag,
+// import it for side effects:
+//
+//	import _ "github.com/smacker/opentracing-gorm/mysqltypes"
+//
+// Use otgorm.WithTimeFormat to render times the way the server interprets them
+package mysqltypes
+
+import (
+	"github.com/go-sql-driver/mysql"
+	otgorm "github.com/smacker/opentracing-gorm"
+)
+
+func init() {
+	otgorm.RegisterValueConverter(Convert)
+}
+
+// Convert converts mysql.NullTime to time.Time or nil
+func Convert(val interface{}) (interface{}, bool) {
+	if v, ok := val.(mysql.NullTime); ok {
+		if !v.Valid {
+			return nil, true
+		}
+		return v.Time, true
+	}
+	return nil, false
+}
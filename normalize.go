@@ -0,0 +1,96 @@
+package otgorm
+
+// prettyKeywords start lines of pretty printed statements, longer keywords go first
+var prettyKeywords = []string{
+	"LEFT OUTER JOIN", "RIGHT OUTER JOIN", "FULL OUTER JOIN",
+	"LEFT JOIN", "RIGHT JOIN", "INNER JOIN", "FULL JOIN", "CROSS JOIN", "JOIN",
+	"FROM", "WHERE", "GROUP BY", "HAVING", "ORDER BY", "LIMIT", "OFFSET",
+	"SET", "VALUES", "RETURNING", "ON CONFLICT", "UNION",
+}
+
+// formatStatement formats statement as configured by WithStatementFormat
+func formatStatement(statement string, format StatementFormat) string {
+	switch format {
+	case StatementCompact:
+		return compactStatement(statement)
+	case StatementPretty:
+		return prettyStatement(statement)
+	}
+	return statement
+}
+
+// compactStatement collapses whitespace outside of quoted literals and identifiers into single spaces
+func compactStatement(query string) string {
+	out := make([]byte, 0, len(query))
+	var quote byte
+	space := false
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		if quote != 0 {
+			out = append(out, ch)
+			if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch ch {
+		case ' ', '\t', '\n', '\r':
+			space = len(out) > 0
+			continue
+		case '\'', '"', '`':
+			quote = ch
+		}
+		if space {
+			out = append(out, ' ')
+			space = false
+		}
+		out = append(out, ch)
+	}
+	return string(out)
+}
+
+// prettyStatement compacts the statement and starts clauses of the top level statement on new lines
+func prettyStatement(query string) string {
+	query = compactStatement(query)
+	out := make([]byte, 0, len(query)+16)
+	var quote byte
+	depth := 0
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		if quote != 0 {
+			out = append(out, ch)
+			if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch ch {
+		case '\'', '"', '`':
+			quote = ch
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if depth == 0 && i > 0 && query[i-1] == ' ' {
+			if keyword := keywordAt(query, i); keyword != "" {
+				out[len(out)-1] = '\n'
+				out = append(out, query[i:i+len(keyword)]...)
+				i += len(keyword) - 1
+				continue
+			}
+		}
+		out = append(out, ch)
+	}
+	return string(out)
+}
+
+// keywordAt returns pretty printed keyword starting at i of query
+func keywordAt(query string, i int) string {
+	for _, keyword := range prettyKeywords {
+		if hasKeyword(query, i, keyword) {
+			return keyword
+		}
+	}
+	return ""
+}
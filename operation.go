@@ -0,0 +1,113 @@
+package otgorm
+
+import (
+	"strings"
+)
+
+// ddlKeywords are first keywords of DDL statements
+var ddlKeywords = map[string]bool{
+	"CREATE":   true,
+	"ALTER":    true,
+	"DROP":     true,
+	"TRUNCATE": true,
+	"RENAME":   true,
+	"COMMENT":  true,
+}
+
+// ddlObjects are objects of DDL statements reported with the keyword, e.g. CREATE TABLE
+var ddlObjects = map[string]bool{
+	"TABLE":     true,
+	"INDEX":     true,
+	"VIEW":      true,
+	"SEQUENCE":  true,
+	"SCHEMA":    true,
+	"DATABASE":  true,
+	"TYPE":      true,
+	"FUNCTION":  true,
+	"PROCEDURE": true,
+	"TRIGGER":   true,
+	"EXTENSION": true,
+}
+
+// ddlModifiers are skipped between DDL keyword and object, e.g. CREATE UNIQUE INDEX is CREATE INDEX
+var ddlModifiers = map[string]bool{
+	"UNIQUE":    true,
+	"OR":        true,
+	"REPLACE":   true,
+	"TEMPORARY": true,
+	"TEMP":      true,
+	"UNLOGGED":  true,
+}
+
+// sqlOperation returns operation of query by its leading keywords, leading comments and parentheses are skipped.
+// DDL statements are reported with their object, e.g. CREATE TABLE or DROP INDEX.
+// ClickHouse mutations ALTER TABLE … DELETE and ALTER TABLE … UPDATE are reported as DELETE and UPDATE
+func sqlOperation(query string) string {
+	words := strings.Fields(skipSQLPrefix(query))
+	if len(words) == 0 {
+		return ""
+	}
+	operation := strings.ToUpper(leadingWord(words[0]))
+	if !ddlKeywords[operation] {
+		return operation
+	}
+
+	if operation == "ALTER" && len(words) >= 4 && strings.EqualFold(words[1], "TABLE") {
+		// table name may be followed by ON CLUSTER name
+		i := 3
+		if len(words) > 6 && strings.EqualFold(words[3], "ON") && strings.EqualFold(words[4], "CLUSTER") {
+			i = 6
+		}
+		if mutation := strings.ToUpper(words[i]); mutation == "DELETE" || mutation == "UPDATE" {
+			return mutation
+		}
+	}
+
+	for _, word := range words[1:] {
+		word = strings.ToUpper(word)
+		if ddlModifiers[word] {
+			continue
+		}
+		if word == "MATERIALIZED" {
+			return operation + " MATERIALIZED VIEW"
+		}
+		if ddlObjects[word] {
+			return operation + " " + word
+		}
+		break
+	}
+	return operation
+}
+
+// skipSQLPrefix returns query without leading whitespace, comments and opening parentheses
+func skipSQLPrefix(query string) string {
+	for {
+		query = strings.TrimLeft(query, " \t\r\n(")
+		switch {
+		case strings.HasPrefix(query, "--"):
+			end := strings.IndexByte(query, '\n')
+			if end < 0 {
+				return ""
+			}
+			query = query[end+1:]
+		case strings.HasPrefix(query, "/*"):
+			end := strings.Index(query, "*/")
+			if end < 0 {
+				return ""
+			}
+			query = query[end+2:]
+		default:
+			return query
+		}
+	}
+}
+
+// leadingWord returns leading letters of word, e.g. SELECT of SELECT*
+func leadingWord(word string) string {
+	for i := 0; i < len(word); i++ {
+		if c := word[i]; !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return word[:i]
+		}
+	}
+	return word
+}
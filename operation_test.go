@@ -0,0 +1,52 @@
+package otgorm
+
+import "testing"
+
+func TestSQLOperation(t *testing.T) {
+	cases := map[string]string{
+		``:                      ``,
+		`select * from "users"`: `SELECT`,
+		"  INSERT INTO `users` (`name`) VALUES (?)":     `INSERT`,
+		`ALTER TABLE events DELETE WHERE id = 1`:        `DELETE`,
+		`ALTER TABLE events ON CLUSTER main UPDATE a=1`: `UPDATE`,
+		`ALTER TABLE events ADD COLUMN name String`:     `ALTER TABLE`,
+		"\t/* request 42 */ -- comment\nSELECT 1":       `SELECT`,
+		`(SELECT 1) UNION (SELECT 2)`:                   `SELECT`,
+		`SELECT* FROM users`:                            `SELECT`,
+		`CREATE UNIQUE INDEX idx ON users (name)`:       `CREATE INDEX`,
+		`create or replace view v as select 1`:          `CREATE VIEW`,
+		`DROP MATERIALIZED VIEW v`:                      `DROP MATERIALIZED VIEW`,
+		`TRUNCATE users`:                                `TRUNCATE`,
+		`-- only comment`:                               ``,
+	}
+
+	for query, expected := range cases {
+		if actual := sqlOperation(query); actual != expected {
+			t.Errorf("sqlOperation(%q) should be %q but it's %q", query, expected, actual)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	cases := map[string][]string{
+		`SELECT 1`:                          {`SELECT 1`},
+		`SELECT 1;`:                         {`SELECT 1`},
+		`SELECT 1; `:                        {`SELECT 1`},
+		`UPDATE a SET x = 1; DELETE FROM b`: {`UPDATE a SET x = 1`, ` DELETE FROM b`},
+		`SELECT ';'; SELECT "a;b" -- c;d` + "\n; SELECT 2": {`SELECT ';'`, ` SELECT "a;b" -- c;d` + "\n", ` SELECT 2`},
+		`SELECT /* ; */ 1; -- ;`:                           {`SELECT /* ; */ 1`},
+	}
+	for query, expected := range cases {
+		actual := splitStatements(query)
+		if len(actual) != len(expected) {
+			t.Errorf("splitStatements(%q) should be %q but it's %q", query, expected, actual)
+			continue
+		}
+		for i := range expected {
+			if actual[i] != expected[i] {
+				t.Errorf("splitStatements(%q) should be %q but it's %q", query, expected, actual)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,790 @@
+package otgorm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// Option configures callbacks added by AddGormCallbacks
+type Option func(*options)
+
+type options struct {
+	maxInValues int
+	maxParams   int
+
+	operationSpans bool
+	granularity    Granularity
+	// callbackTimings logs durations of gorm callbacks on operation spans
+	callbackTimings bool
+	noRowsMatched   bool
+	lastInsertID    bool
+	// noValues keeps values out of spans, see WithNoValues
+	noValues bool
+
+	statementTimeout        bool
+	statementTimeoutFloor   time.Duration
+	statementTimeoutCeiling time.Duration
+
+	maxExecutionTime        bool
+	maxExecutionTimeFloor   time.Duration
+	maxExecutionTimeCeiling time.Duration
+
+	duplicateMode DuplicateMode
+
+	timeLayout   string
+	timeLocation *time.Location
+
+	serverVersion bool
+
+	slowThreshold    time.Duration
+	samplingPriority bool
+
+	spanBudget int
+
+	asyncWorkers int
+	asyncQueue   int
+
+	tags        Tags
+	tagsFunc    func(scope *gorm.Scope) map[string]interface{}
+	shardTagger func(scope *gorm.Scope) (string, bool)
+	// schemaResolver returns schema of the query tagged as db.schema
+	schemaResolver func(scope *gorm.Scope) string
+	settingsTags   []string
+
+	instanceID bool
+	poolName   string
+
+	backendPIDQuery string
+
+	explainRate   float64
+	explainTables map[string]bool
+
+	allowedColumns map[string]bool
+	allowedParams  map[int]bool
+
+	defaultCapture Capture
+	tableCapture   map[string]Capture
+
+	statementFormat StatementFormat
+
+	overheadTag bool
+
+	operationTableNames bool
+
+	sampled func(sc opentracing.SpanContext) bool
+	// oversizedStatement is the size of statements tagged with db.statement.oversized
+	oversizedStatement int
+	slowQuerySink      func(SlowQuery)
+	runtimeTrace       bool
+	pprofLabels        bool
+	repeatWindow       time.Duration
+	requestSummary     bool
+	parentFinished     func(parent opentracing.Span) bool
+	callbackProfiling  bool
+	profiledCallbacks  []string
+	traceIDFunc        func(sc opentracing.SpanContext) string
+	enabledFunc        func() bool
+	applicationName    string
+
+	watchdogWindow     time.Duration
+	watchdogThreshold  float64
+	watchdogMinQueries int
+	watchdogHook       func(ErrorRateAlert)
+
+	parentTotals     bool
+	parentTimeTags   bool
+	parentMaxQueries int
+	parentMaxTime    time.Duration
+
+	recorderTraces     int
+	recorderStatements int
+	// traceSetting is Postgres setting set to the trace id in transactions
+	traceSetting string
+	// queryID adds query id comment to statements
+	queryID bool
+	// checkpointLogs logs lifecycle checkpoints of sql spans
+	checkpointLogs bool
+	// execTiming splits duration of sql spans into driver and gorm time
+	execTiming bool
+	// failedStatement captures statements of failed and slow queries only
+	failedStatement bool
+
+	scanSpans bool
+
+	tracer       opentracing.Tracer
+	globalTracer bool
+
+	tagSanitizer func(key string, value interface{}) (interface{}, bool)
+
+	updateCapture map[string]bool
+
+	auditSink  func(AuditEvent)
+	auditActor func(ctx context.Context) string
+}
+
+func defaultOptions() options {
+	return options{
+		maxInValues: 100,
+		maxParams:   200,
+		tags:        AllTags,
+	}
+}
+
+// WithMaxInValues sets the maximum number of IN (...) values rendered in db.statement,
+// longer lists are summarized as IN (… n values). Zero or negative value disables summarization
+func WithMaxInValues(n int) Option {
+	return func(o *options) {
+		o.maxInValues = n
+	}
+}
+
+// WithMaxParams sets the maximum number of sql vars interpolated into db.statement,
+// statements with more vars are left as is and tagged with db.statement.truncated_params.
+// Zero or negative value disables the limit
+func WithMaxParams(n int) Option {
+	return func(o *options) {
+		o.maxParams = n
+	}
+}
+
+// WithOperationSpans enables "gorm:<operation>" spans wrapping the whole gorm operation,
+// sql spans become their children. It makes gorm overhead (hooks, associations, scanning)
+// distinguishable from sql execution
+func WithOperationSpans() Option {
+	return func(o *options) {
+		o.operationSpans = true
+	}
+}
+
+// Granularity defines which gorm calls get their own spans
+type Granularity int
+
+const (
+	// GranularityStatement creates sql span per statement, it's the default
+	GranularityStatement Granularity = iota
+	// GranularityOperation wraps sql spans of each gorm operation into "gorm:<operation>" span,
+	// as WithOperationSpans does. Nested operations (association saves, preloads) get their own spans
+	GranularityOperation
+	// GranularityCall creates single "gorm:<operation>" span per gorm call (Create, Find, Save etc.) wrapping
+	// all sql it generates, including sql of nested operations
+	GranularityCall
+)
+
+// WithGranularity sets which gorm calls get their own spans, GranularityOperation and GranularityCall
+// enable WithOperationSpans
+func WithGranularity(g Granularity) Option {
+	return func(o *options) {
+		o.granularity = g
+		o.operationSpans = g != GranularityStatement
+	}
+}
+
+// WithCallbackProfiling wraps callbacks registered by other plugins and the application, e.g. validations,
+// and logs their durations to the sql span, or to the operation span if they run after the query. All of them
+// are wrapped unless names are given. The callbacks must be registered before AddGormCallbacks, the option is
+// applied by AddGormCallbacks only and UpdateConfig keeps it. Gorm callbacks are timed by WithCallbackTimings
+func WithCallbackProfiling(names ...string) Option {
+	return func(o *options) {
+		o.callbackProfiling = true
+		o.profiledCallbacks = names
+	}
+}
+
+// WithCallbackTimings logs duration of each gorm callback of the operation (begin_transaction, before_create hooks,
+// save_associations, commit etc.) on "gorm:<operation>" span, so the trace shows which gorm phase consumed the time.
+// It enables WithOperationSpans
+func WithCallbackTimings() Option {
+	return func(o *options) {
+		o.operationSpans = true
+		o.callbackTimings = true
+	}
+}
+
+// WithOperationTableNames names sql spans "<OPERATION> <table>", e.g. "SELECT users", instead of "sql"
+// as OpenTelemetry recommends, so trace waterfalls are readable at a glance. Raw queries without a model are named
+// by the operation only
+func WithOperationTableNames() Option {
+	return func(o *options) {
+		o.operationTableNames = true
+	}
+}
+
+// WithNoRowsMatched tags UPDATE and DELETE spans which affected no rows with db.no_rows_matched
+func WithNoRowsMatched() Option {
+	return func(o *options) {
+		o.noRowsMatched = true
+	}
+}
+
+// WithLastInsertID tags INSERT spans with primary key of the created row as db.last_insert_id
+func WithLastInsertID() Option {
+	return func(o *options) {
+		o.lastInsertID = true
+	}
+}
+
+// WithStatementTimeout bounds Postgres queries by the remaining deadline of the context passed to SetSpanToGorm,
+// gorm doesn't pass context to the driver. The timeout is clamped to [floor, ceiling] and is applied with
+// SET LOCAL statement_timeout inside a savepoint of the operation transaction, SELECTs are bounded only inside
+// a transaction of the application. Zero ceiling means no upper bound
+func WithStatementTimeout(floor, ceiling time.Duration) Option {
+	return func(o *options) {
+		o.statementTimeout = true
+		o.statementTimeoutFloor = floor
+		o.statementTimeoutCeiling = ceiling
+	}
+}
+
+// WithMaxExecutionTime bounds MySQL SELECTs by the remaining deadline of the context passed to SetSpanToGorm
+// with /*+ MAX_EXECUTION_TIME(n) */ optimizer hint. The timeout is clamped to [floor, ceiling],
+// zero ceiling means no upper bound. Queries with custom Select or Raw queries are left as is
+func WithMaxExecutionTime(floor, ceiling time.Duration) Option {
+	return func(o *options) {
+		o.maxExecutionTime = true
+		o.maxExecutionTimeFloor = floor
+		o.maxExecutionTimeCeiling = ceiling
+	}
+}
+
+// DuplicateMode defines how queries already traced by another instrumentation are handled
+type DuplicateMode int
+
+const (
+	// DuplicateSuppress doesn't create duplicate spans, it's the default
+	DuplicateSuppress DuplicateMode = iota
+	// DuplicateTag creates duplicate spans tagged with db.duplicate
+	DuplicateTag
+)
+
+// WithDuplicateMode sets how queries already traced by another instrumentation are handled:
+// stacked WrapDriver drivers or callbacks added more than once for the same operation
+func WithDuplicateMode(mode DuplicateMode) Option {
+	return func(o *options) {
+		o.duplicateMode = mode
+	}
+}
+
+// WithTimeFormat sets layout and location of time parameters rendered in db.statement,
+// so they match the way the server interprets them, e.g. for MySQL with loc=UTC DSN parameter:
+//
+//	otgorm.WithTimeFormat("2006-01-02 15:04:05.999999", time.UTC)
+//
+// Empty layout keeps the default time.Time.String() format, nil location keeps location of the parameter
+func WithTimeFormat(layout string, loc *time.Location) Option {
+	return func(o *options) {
+		o.timeLayout = layout
+		o.timeLocation = loc
+	}
+}
+
+// WithServerVersion queries server version once per db outside of transactions and tags spans with db.version,
+// CockroachDB connected with the postgres dialect is reported as db.type cockroachdb
+func WithServerVersion() Option {
+	return func(o *options) {
+		o.serverVersion = true
+	}
+}
+
+// WithSlowThreshold tags queries which took at least d with db.slow
+func WithSlowThreshold(d time.Duration) Option {
+	return func(o *options) {
+		o.slowThreshold = d
+	}
+}
+
+// WithSamplingPriority sets sampling.priority 1 on spans of failed queries and queries slower than WithSlowThreshold,
+// so they survive probabilistic head-based sampling of tracers which support the hint
+func WithSamplingPriority() Option {
+	return func(o *options) {
+		o.samplingPriority = true
+	}
+}
+
+// WithSpanBudget caps the number of sql spans per table and operation per second to n,
+// queries over the budget aren't traced and the next traced one is tagged with their count as db.budget.dropped
+func WithSpanBudget(n int) Option {
+	return func(o *options) {
+		o.spanBudget = n
+	}
+}
+
+// WithAsyncFinish moves statement interpolation and span finishing off the request path to the pool of workers
+// with the queue of the given size, spans are finished synchronously when the queue is full.
+// Vars are formatted after the query returns, so values mutated by the caller right after it may be rendered
+// with the new values. Call Flush before closing the tracer
+func WithAsyncFinish(workers, queue int) Option {
+	return func(o *options) {
+		o.asyncWorkers = workers
+		o.asyncQueue = queue
+	}
+}
+
+// Tags is a set of built-in tags
+type Tags uint
+
+const (
+	// TagType is db.type
+	TagType Tags = 1 << iota
+	// TagInstance is db.instance
+	TagInstance
+	// TagTable is db.table and db.sql.tables
+	TagTable
+	// TagMethod is db.method
+	TagMethod
+	// TagCount is db.count and db.rows_returned
+	TagCount
+	// TagErr is db.err
+	TagErr
+	// TagStatement is db.statement and db.statement.truncated_params
+	TagStatement
+	// TagDuration is db.duration_ms
+	TagDuration
+	// TagParams is db.params.count and db.params.bytes
+	TagParams
+	// TagUpdatedColumns is db.updated_columns
+	TagUpdatedColumns
+	// TagModel is db.model
+	TagModel
+	// TagSoftDelete is db.soft_delete and db.unscoped
+	TagSoftDelete
+	// TagPagination is db.limit and db.offset
+	TagPagination
+	// TagBatchSize is db.batch.size
+	TagBatchSize
+	// TagVersion is db.version of WithServerVersion
+	TagVersion
+	// TagDeadline is db.deadline_remaining_ms
+	TagDeadline
+
+	// AllTags are all built-in tags, it's the default
+	AllTags = TagType | TagInstance | TagTable | TagMethod | TagCount | TagErr | TagStatement | TagDuration | TagParams |
+		TagUpdatedColumns | TagModel | TagSoftDelete | TagPagination | TagBatchSize | TagVersion | TagDeadline
+)
+
+// WithTags sets built-in tags set on spans, e.g. AllTags &^ TagStatement.
+// Tags of other options and error tag are set regardless
+func WithTags(tags Tags) Option {
+	return func(o *options) {
+		o.tags = tags
+	}
+}
+
+func (o options) has(tag Tags) bool {
+	return o.tags&tag != 0
+}
+
+// WithTagsFunc sets function which returns additional tags of the query, e.g. derived from the model or the table name.
+// It's called after the query is executed
+func WithTagsFunc(f func(scope *gorm.Scope) map[string]interface{}) Option {
+	return func(o *options) {
+		o.tagsFunc = f
+	}
+}
+
+// WithShardTagger sets function which returns shard or partition targeted by the query, e.g. derived from the table
+// suffix, gorm settings or scope.SQLVars, spans are tagged with it as db.shard. It's called after the query is executed
+func WithShardTagger(f func(scope *gorm.Scope) (shard string, ok bool)) Option {
+	return func(o *options) {
+		o.shardTagger = f
+	}
+}
+
+// WithSchemaResolver tags spans with the schema returned by resolver as db.schema, e.g. the tenant schema
+// of schema-per-tenant Postgres setups. Empty schema isn't tagged, see SchemaSetting
+func WithSchemaResolver(resolver func(scope *gorm.Scope) string) Option {
+	return func(o *options) {
+		o.schemaResolver = resolver
+	}
+}
+
+// SchemaSetting returns schema resolver which reads the schema from gorm setting with the key,
+// e.g. WithSchemaResolver(SchemaSetting("tenant_schema")) with db.Set("tenant_schema", "tenant_42").
+// Schema of table names qualified with it, e.g. "tenant_42.orders", is returned if the setting isn't set
+func SchemaSetting(key string) func(scope *gorm.Scope) string {
+	return func(scope *gorm.Scope) string {
+		if val, ok := scope.Get(key); ok && val != nil {
+			return fmt.Sprint(val)
+		}
+		if table := scope.TableName(); strings.Contains(table, ".") {
+			return table[:strings.IndexByte(table, '.')]
+		}
+		return ""
+	}
+}
+
+// WithSettingsTags copies values of gorm settings with the keys set by db.Set to span tags named by the keys,
+// e.g. WithSettingsTags("request_id", "feature") with db.Set("feature", "checkout")
+func WithSettingsTags(keys ...string) Option {
+	return func(o *options) {
+		o.settingsTags = keys
+	}
+}
+
+// WithErrorRateWatchdog tracks error rates of traced queries per table and operation in the sliding window
+// and calls hook with ErrorRateAlert when the rate reaches threshold, e.g. 0.2, with at least minQueries queries
+// in the window, and again with Recovered when it falls below threshold. "Record not found" isn't an error.
+// hook is called synchronously after the query, alerting tables are listed by DebugHandler as well
+func WithErrorRateWatchdog(window time.Duration, threshold float64, minQueries int, hook func(ErrorRateAlert)) Option {
+	return func(o *options) {
+		o.watchdogWindow = window
+		o.watchdogThreshold = threshold
+		o.watchdogMinQueries = minQueries
+		o.watchdogHook = hook
+	}
+}
+
+// WithEnabledFunc traces queries only while f returns true, e.g. a feature flag, it's called once per query
+// and should be as cheap as an atomic load. Disable turns tracing off regardless of f
+func WithEnabledFunc(f func() bool) Option {
+	return func(o *options) {
+		o.enabledFunc = f
+	}
+}
+
+// WithRuntimeTrace starts runtime/trace task and region named like the span, e.g. "SELECT products", for each
+// traced query, so execution traces show queries aligned with goroutine scheduling and GC. They cost nothing
+// unless the execution trace is collected
+func WithRuntimeTrace() Option {
+	return func(o *options) {
+		o.runtimeTrace = true
+	}
+}
+
+// WithPprofLabels labels the goroutine with db.table and db.op pprof labels while the traced query runs,
+// so CPU profiles attribute building statements and scanning rows to queries. Labels of the context passed
+// to SetSpanToGorm are restored when the query finishes
+func WithPprofLabels() Option {
+	return func(o *options) {
+		o.pprofLabels = true
+	}
+}
+
+// WithRepeatCompression merges identical consecutive statements of the span set by SetSpanToGorm, e.g. inserts
+// in a loop. The first statement has its own span, the repeats started within window after the previous one are
+// merged into the span of the second one tagged with db.repeat_count and their total duration as db.repeat_total_ms,
+// spans of the other repeats are never started. The merged span is finished by the next different statement,
+// by Flush or by Summarize, which the middlewares, the interceptors and StartJobSpan call. Failed statements are never merged
+func WithRepeatCompression(window time.Duration) Option {
+	return func(o *options) {
+		o.repeatWindow = window
+	}
+}
+
+// WithRequestSummary aggregates queries of the span set by SetSpanToGorm per table and operation,
+// call Summarize before finishing the span to report them as its "db.summary" child
+func WithRequestSummary() Option {
+	return func(o *options) {
+		o.requestSummary = true
+	}
+}
+
+// WithLateQueryCheck tags queries finished after the span set by SetSpanToGorm with db.after_parent
+// and logs "query finished after parent span" event. Tracers don't expose whether the span is finished,
+// so finished is tracer specific, nil uses SpanFinished
+func WithLateQueryCheck(finished func(parent opentracing.Span) bool) Option {
+	return func(o *options) {
+		if finished == nil {
+			finished = SpanFinished
+		}
+		o.parentFinished = finished
+	}
+}
+
+// WithParentTotals tags the span set by SetSpanToGorm with the number of its queries as db.query_count
+// and their total duration as db.total_ms. When maxQueries or maxTime is exceeded, "db budget exceeded" event is logged
+// to the span once, zero disables the limit. Totals are kept in the db returned by SetSpanToGorm
+func WithParentTotals(maxQueries int, maxTime time.Duration) Option {
+	return func(o *options) {
+		o.parentTotals = true
+		o.parentMaxQueries = maxQueries
+		o.parentMaxTime = maxTime
+	}
+}
+
+// WithParentTimeTags tags the span set by SetSpanToGorm with the total duration of its queries as db.time_ms
+// and their number as db.calls, so requests spending most of their time in the database can be queried
+// in the tracing backend. It shares totals with WithParentTotals
+func WithParentTimeTags() Option {
+	return func(o *options) {
+		o.parentTimeTags = true
+	}
+}
+
+// WithPoolName tags spans with the name of the connection pool of the db as db.pool.name, e.g. "primary-rw",
+// so pools to the same database can be told apart
+func WithPoolName(name string) Option {
+	return func(o *options) {
+		o.poolName = name
+	}
+}
+
+// WithInstanceID tags spans with gorm instance id as db.instance instead of the name of the current database
+func WithInstanceID() Option {
+	return func(o *options) {
+		o.instanceID = true
+	}
+}
+
+// WithBackendPID makes WrapDriver query pg_backend_pid() once per new connection and tag its spans with db.backend_pid,
+// so spans can be correlated with server logs and pg_stat_activity. It's supported by Postgres drivers only.
+// Spans of callbacks added with the option are tagged if db is opened with WrapDriver using it, gorm v1 doesn't pass
+// context to the driver, so the connection is matched by the statement and the goroutine running it as WithExecTiming does
+func WithBackendPID() Option {
+	return func(o *options) {
+		o.backendPIDQuery = "SELECT pg_backend_pid()"
+	}
+}
+
+// WithExplainAnalyze re-runs the rate fraction of SELECTs under EXPLAIN (ANALYZE, BUFFERS) on a dedicated connection
+// and logs the plan to the span. Only queries of the tables are sampled, all tables if none given.
+// Sampled queries are executed twice, keep the rate tiny. Queries inside transactions aren't sampled.
+// The plan is queried when the span is finished, by workers of WithAsyncFinish if it's used,
+// otherwise synchronously, so the sampled gorm call returns only after its plan query
+func WithExplainAnalyze(rate float64, tables ...string) Option {
+	return func(o *options) {
+		o.explainRate = rate
+		o.explainTables = make(map[string]bool, len(tables))
+		for _, table := range tables {
+			o.explainTables[table] = true
+		}
+	}
+}
+
+// WithAllowedColumns interpolates only values compared with or inserted into the columns,
+// other values are rendered as ?. Values of expressions which columns can't be recognized are never interpolated
+func WithAllowedColumns(columns ...string) Option {
+	return func(o *options) {
+		o.allowedColumns = make(map[string]bool, len(columns))
+		for _, column := range columns {
+			o.allowedColumns[strings.ToLower(column)] = true
+		}
+	}
+}
+
+// WithAllowedParams interpolates only values bound to placeholders at the positions starting from 1,
+// other values are rendered as ?. It can be combined with WithAllowedColumns
+func WithAllowedParams(positions ...int) Option {
+	return func(o *options) {
+		o.allowedParams = make(map[int]bool, len(positions))
+		for _, n := range positions {
+			o.allowedParams[n] = true
+		}
+	}
+}
+
+// Capture defines how db.statement is captured
+type Capture int
+
+const (
+	// CaptureFull captures statement with interpolated values, it's the default
+	CaptureFull Capture = iota
+	// CapturePlaceholders captures statement with placeholders
+	CapturePlaceholders
+	// CaptureNone doesn't capture statement
+	CaptureNone
+)
+
+// WithUpdateCapture logs columns updated by UPDATE of the tables as db.columns, with values bound to placeholders
+// as db.value.<column> if values is true. Values are rendered as in db.statement, allowlists and no values mode apply
+func WithUpdateCapture(values bool, tables ...string) Option {
+	return func(o *options) {
+		if o.updateCapture == nil {
+			o.updateCapture = make(map[string]bool)
+		}
+		for _, table := range tables {
+			o.updateCapture[table] = values
+		}
+	}
+}
+
+// WithTableCapture sets statement capture of queries touching the tables, e.g. CaptureNone for users and payments.
+// The most restrictive capture of all tables in FROM, JOIN and INTO clauses and the target of UPDATE is used,
+// tables qualified with schema use capture of the table unless the qualified name is configured
+func WithTableCapture(capture Capture, tables ...string) Option {
+	return func(o *options) {
+		if o.tableCapture == nil {
+			o.tableCapture = make(map[string]Capture)
+		}
+		for _, table := range tables {
+			o.tableCapture[table] = capture
+		}
+	}
+}
+
+// WithDefaultCapture sets statement capture of tables not configured with WithTableCapture
+// and of queries traced by WrapDriver
+func WithDefaultCapture(capture Capture) Option {
+	return func(o *options) {
+		o.defaultCapture = capture
+	}
+}
+
+// WithOverheadTag tags spans with time spent in the callbacks on the query path as otgorm.overhead_us,
+// including statement interpolation unless it's done by WithAsyncFinish workers
+func WithOverheadTag() Option {
+	return func(o *options) {
+		o.overheadTag = true
+	}
+}
+
+// WithSampledStatement sets db.statement only on spans which sampled reports as sampled, so the most expensive
+// and sensitive tag isn't computed for spans the tracer drops, other tags are set regardless.
+// IsSampled supports tracers which span contexts implement IsSampled() bool, e.g. Jaeger:
+//
+//	otgorm.WithSampledStatement(otgorm.IsSampled)
+func WithSampledStatement(sampled func(sc opentracing.SpanContext) bool) Option {
+	return func(o *options) {
+		o.sampled = sampled
+	}
+}
+
+// WithFailedStatement sets db.statement only on failed queries and queries slower than WithSlowThreshold,
+// which gives most of the debugging value of statements at a fraction of the data volume and risk.
+// Capture mode of WithTableCapture and WithDefaultCapture still applies to them
+func WithFailedStatement() Option {
+	return func(o *options) {
+		o.failedStatement = true
+	}
+}
+
+// WithOversizedStatement tags spans of statements longer than n bytes with db.statement.oversized and their size
+// as db.statement.bytes, so code generating huge SQL can be found even if WithTagSanitizer truncates db.statement
+func WithOversizedStatement(n int) Option {
+	return func(o *options) {
+		o.oversizedStatement = n
+	}
+}
+
+// WithExecTiming tags sql spans with time spent in the driver as db.exec_ms and the rest of the span duration,
+// spent in gorm building the statement and scanning results, as db.build_ms. gorm v1 doesn't pass context to the driver,
+// so driver time is matched by the statement and the goroutine running it and requires db to be opened with WrapDriver
+// before AddGormCallbacks
+func WithExecTiming() Option {
+	return func(o *options) {
+		o.execTiming = true
+	}
+}
+
+// WithApplicationName sets application_name of new connections of WrapDriver to "<service> otgorm/<version>",
+// so pg_stat_activity and server logs attribute connections to the service. With WithTraceSetting of application_name
+// the trace id is appended to it inside transactions. Postgres truncates it to 63 bytes. It's supported by Postgres
+// drivers only, callbacks without WrapDriver stamp it only together with WithTraceSetting
+func WithApplicationName(service string) Option {
+	return func(o *options) {
+		o.applicationName = service
+	}
+}
+
+// WithTraceSetting sets Postgres setting, application_name if empty or a custom one like app.trace_id,
+// to 'trace:<trace id>' with SET LOCAL before each operation inside a transaction, so pg_stat_activity, locks
+// and log_line_prefix output carry the trace id. Create, update and delete run in transactions,
+// queries only in transactions started with Begin. It costs an extra round trip per operation
+func WithTraceSetting(setting string) Option {
+	return func(o *options) {
+		if setting == "" {
+			setting = "application_name"
+		}
+		o.traceSetting = setting
+	}
+}
+
+// WithQueryID adds comment with random query id to statements, e.g. /* query_id=5f0c6c4a7e4f1b2d */ SELECT ...,
+// and tags spans with it as db.query_id, so an execution seen in pg_stat_activity or the slow log can be matched
+// to its span. Queries are prefixed with the comment, other statements are suffixed with it
+func WithQueryID() Option {
+	return func(o *options) {
+		o.queryID = true
+	}
+}
+
+// WithCheckpointLogs logs lifecycle checkpoints of sql spans: sql.build.done, driver.exec.start, driver.exec.done
+// and scan.done for queries, so timing inside the span is available without extra spans. Driver checkpoints
+// are matched as WithExecTiming does and require the connection to be opened with WrapDriver
+func WithCheckpointLogs() Option {
+	return func(o *options) {
+		o.checkpointLogs = true
+	}
+}
+
+// WithScanSpans starts "gorm:scan" span as a child of "gorm:rows" span of Rows covering iteration and scanning of the rows,
+// so slow mapping of many rows is told from slow query. gorm scans results of Find and First inside its query callback
+// together with the query execution, so their scanning is part of sql span and can't be separated
+func WithScanSpans() Option {
+	return func(o *options) {
+		o.scanSpans = true
+	}
+}
+
+// WithTracer starts spans with tracer instead of the tracer of the parent span,
+// so spans of the db are reported by the tracer even if the parent span comes from another one
+func WithTracer(tracer opentracing.Tracer) Option {
+	return func(o *options) {
+		o.tracer = tracer
+	}
+}
+
+// WithGlobalTracer starts spans with opentracing.GlobalTracer() at the time the span is started
+// instead of the tracer of the parent span, e.g. when the parent is a span of a bridge tracer.
+// WithTracer takes precedence over it
+func WithGlobalTracer() Option {
+	return func(o *options) {
+		o.globalTracer = true
+	}
+}
+
+// spanTracer returns tracer starting spans which parent is started by parent tracer
+func (o options) spanTracer(parent opentracing.Tracer) opentracing.Tracer {
+	if o.tracer != nil {
+		return o.tracer
+	}
+	if o.globalTracer {
+		return opentracing.GlobalTracer()
+	}
+	return parent
+}
+
+// startSpanFromContext starts span as a child of the span of ctx with the tracer returned by spanTracer,
+// opentracing.StartSpanFromContext always uses the global tracer
+func (o options) startSpanFromContext(ctx context.Context, operationName string) (opentracing.Span, context.Context) {
+	parentTracer := opentracing.GlobalTracer()
+	var opts []opentracing.StartSpanOption
+	if parent := opentracing.SpanFromContext(ctx); parent != nil {
+		parentTracer = parent.Tracer()
+		opts = append(opts, opentracing.ChildOf(parent.Context()))
+	}
+	span := o.spanTracer(parentTracer).StartSpan(operationName, opts...)
+	return span, opentracing.ContextWithSpan(ctx, span)
+}
+
+// WithTagSanitizer applies f to every tag before it's set on spans, f returns the value to set or false to drop the tag.
+// It enforces policies like hashing or truncation of values regardless of options setting the tags
+func WithTagSanitizer(f func(key string, value interface{}) (interface{}, bool)) Option {
+	return func(o *options) {
+		o.tagSanitizer = f
+	}
+}
+
+// StatementFormat defines how whitespace of db.statement is formatted
+type StatementFormat int
+
+const (
+	// StatementRaw keeps statement as built by gorm, it's the default
+	StatementRaw StatementFormat = iota
+	// StatementCompact collapses whitespace into single spaces, so multi-line statements are one line
+	StatementCompact
+	// StatementPretty compacts statement and starts clauses like FROM, WHERE and ORDER BY on new lines
+	StatementPretty
+)
+
+// WithStatementFormat sets how whitespace of db.statement is formatted,
+// quoted literals and identifiers are never changed
+func WithStatementFormat(format StatementFormat) Option {
+	return func(o *options) {
+		o.statementFormat = format
+	}
+}
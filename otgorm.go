@@ -2,21 +2,45 @@ package otgorm
 
 import (
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
-	"github.com/bmizerany/pq"
 	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jinzhu/gorm"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
 )
 
-const (
-	parentSpanGormKey = "opentracingParentSpan"
-	spanGormKey       = "opentracingSpan"
+// Keys of gorm settings used by the instrumentation. Change them if another library uses the same keys,
+// before AddGormCallbacks is called and the db is used. Values of unexpected types found under the keys are ignored
+var (
+	// ParentSpanGormKey holds the span set by SetSpanToGorm or the span context set by SetSpanContextToGorm
+	ParentSpanGormKey = "opentracingParentSpan"
+	// SpanGormKey holds the sql span of the operation, it isn't set for statements of WithRepeatCompression
+	// whose spans are started only when they are finished
+	SpanGormKey = "opentracingSpan"
+	// StartTimeGormKey holds the start time of the sql span
+	StartTimeGormKey = "opentracingStartTime"
+	// OperationSpanGormKey holds the span of WithOperationSpans
+	OperationSpanGormKey = "opentracingOperationSpan"
+	// CallbacksGormKey holds the callbacks added by AddGormCallbacks
+	CallbacksGormKey = "opentracingCallbacks"
+	// ContextGormKey holds the context passed to SetSpanToGorm
+	ContextGormKey = "opentracingContext"
+	// SpanOwnerGormKey holds the callbacks which started the sql span
+	SpanOwnerGormKey = "opentracingSpanOwner"
+	// OverheadGormKey holds time spent in the before callback
+	OverheadGormKey = "opentracingOverhead"
+	// ParentStatsGormKey holds totals, summary and the last statement of the span set by SetSpanToGorm
+	// with WithParentTotals, WithParentTimeTags, WithRepeatCompression or WithRequestSummary
+	ParentStatsGormKey = "opentracingParentStats"
+	// NestedOperationGormKey is set for operations nested into the span of GranularityCall
+	NestedOperationGormKey = "opentracingNestedOperation"
 )
 
 // SetSpanToGorm sets span to gorm settings, returns cloned DB
@@ -28,73 +52,687 @@ func SetSpanToGorm(ctx context.Context, db *gorm.DB) *gorm.DB {
 	if parentSpan == nil {
 		return db
 	}
-	return db.Set(parentSpanGormKey, parentSpan)
+	db = db.Set(ParentSpanGormKey, parentSpan).InstantSet(ContextGormKey, ctx)
+	if stats := newParentStats(db, parentSpan); stats != nil {
+		db.InstantSet(ParentStatsGormKey, stats)
+	}
+	return db
+}
+
+// SetSpanContextToGorm sets span context to gorm settings, returns cloned DB.
+// It's used when only span context is available, e.g. extracted from message headers.
+// Spans are started by opentracing.GlobalTracer()
+func SetSpanContextToGorm(sc opentracing.SpanContext, db *gorm.DB) *gorm.DB {
+	if sc == nil {
+		return db
+	}
+	return db.Set(ParentSpanGormKey, sc)
+}
+
+// spanParent returns parent of spans and tracer starting them from the value set by SetSpanToGorm, SetSpanContextToGorm
+// or SpanToGormFromCarrier
+func spanParent(val interface{}) (opentracing.SpanContext, opentracing.Tracer, bool) {
+	switch v := val.(type) {
+	case opentracing.Span:
+		return v.Context(), v.Tracer(), true
+	case remoteParent:
+		return v.sc, v.tracer, true
+	case opentracing.SpanContext:
+		return v, opentracing.GlobalTracer(), true
+	}
+	return nil, nil, false
+}
+
+// spanParent returns parent of spans and tracer starting them, WithTracer and WithGlobalTracer override tracer of the parent
+func (cfg *config) spanParent(val interface{}) (opentracing.SpanContext, opentracing.Tracer, bool) {
+	parent, tr, ok := spanParent(val)
+	if !ok {
+		return nil, nil, false
+	}
+	return parent, cfg.spanTracer(tr), true
+}
+
+// contextFromScope returns context passed to SetSpanToGorm
+func contextFromScope(scope *gorm.Scope) (context.Context, bool) {
+	val, ok := scope.Get(ContextGormKey)
+	if !ok {
+		return nil, false
+	}
+	ctx, ok := val.(context.Context)
+	return ctx, ok
 }
 
 // AddGormCallbacks adds callbacks for tracing, you should call SetSpanToGorm to make them work
-func AddGormCallbacks(db *gorm.DB) {
-	callbacks := newCallbacks()
+func AddGormCallbacks(db *gorm.DB, opts ...Option) {
+	callbacks := newCallbacks(opts...)
+	db.InstantSet(CallbacksGormKey, callbacks)
+	callbacks.linkDriver(db)
 	registerCallbacks(db, "create", callbacks)
 	registerCallbacks(db, "query", callbacks)
 	registerCallbacks(db, "update", callbacks)
 	registerCallbacks(db, "delete", callbacks)
 	registerCallbacks(db, "row_query", callbacks)
+	callbacks.registerOptionalCallbacks(db)
+	if callbacks.config().callbackProfiling {
+		profileCallbacks(db, callbacks)
+	}
+}
+
+// UpdateConfig replaces options of callbacks added to db by AddGormCallbacks, so capture modes,
+// thresholds and redaction rules can be changed at runtime. Options are applied to defaults as in AddGormCallbacks,
+// WithAsyncFinish can't be changed, the span budget and the trace recorder start over. Operation and timing callbacks
+// are registered when WithOperationSpans or WithCallbackTimings is enabled for the first time, gorm doesn't
+// synchronize registration with running queries, so enable them before db is used if possible.
+// WithNoValues can't be turned off, it's kept and the attempt is reported to the audit logger.
+// WithCallbackProfiling is applied by AddGormCallbacks only, it's kept as is
+func UpdateConfig(db *gorm.DB, opts ...Option) error {
+	val, ok := db.Get(CallbacksGormKey)
+	if !ok {
+		return errors.New("otgorm: callbacks aren't added to db")
+	}
+	c, ok := val.(*callbacks)
+	if !ok {
+		return errors.New("otgorm: callbacks aren't added to db")
+	}
+	old := c.config()
+	cfg := newConfig(opts...)
+	if old.noValues && !cfg.noValues {
+		audit("UpdateConfig without WithNoValues, no values mode can't be turned off")
+		cfg = newConfig(append(opts[:len(opts):len(opts)], WithNoValues())...)
+	}
+	// profiled callbacks are wrapped once by AddGormCallbacks
+	cfg.callbackProfiling, cfg.profiledCallbacks = old.callbackProfiling, old.profiledCallbacks
+	c.cfg.Store(cfg)
+	if cfg.noValues && c.driver != nil {
+		c.driver.enforceNoValues()
+	}
+	c.registerOptionalCallbacks(db)
+	c.enableExecTimings()
+	return nil
+}
+
+type callbacks struct {
+	// status is reported by DebugHandler, it goes first to keep its int64 counter aligned
+	status status
+
+	// cfg holds *config, it's swapped by UpdateConfig
+	cfg atomic.Value
+
+	// versions caches server versions by dialect, dialect is shared by all clones and transactions of db
+	versions sync.Map
+	// databases caches names of current databases by dialect
+	databases sync.Map
+	// finisher finishes spans asynchronously, it's nil unless WithAsyncFinish is used
+	finisher *asyncFinisher
+	// execs are execution times recorded by connections of WrapDriver
+	execs execTimings
+	// driver are callbacks of WrapDriver db is opened with, it's nil unless db is opened with it
+	driver *callbacks
+
+	// registerMu guards registration of operation and timing callbacks by AddGormCallbacks and UpdateConfig
+	registerMu sync.Mutex
+	// operationCallbacks and timingCallbacks are set once the callbacks are registered
+	operationCallbacks bool
+	timingCallbacks    bool
 }
 
-type callbacks struct{}
+// config is configuration of callbacks
+type config struct {
+	options
+
+	// budget caps spans per table and operation, it's nil unless WithSpanBudget is used
+	budget *spanBudget
+	// watchdog tracks error rates, it's nil unless WithErrorRateWatchdog is used
+	watchdog *errorWatchdog
+	// recorder keeps statements of recent traces, it's nil unless WithTraceRecorder is used
+	recorder *traceRecorder
+}
+
+func newConfig(opts ...Option) *config {
+	cfg := &config{options: defaultOptions()}
+	for _, opt := range opts {
+		opt(&cfg.options)
+	}
+	cfg.audit()
+	if cfg.spanBudget > 0 {
+		cfg.budget = newSpanBudget(cfg.spanBudget)
+	}
+	if cfg.watchdogWindow >= watchdogBuckets && cfg.watchdogHook != nil {
+		cfg.watchdog = newErrorWatchdog(cfg.watchdogWindow, cfg.watchdogThreshold, cfg.watchdogMinQueries, cfg.watchdogHook)
+	}
+	if cfg.recorderTraces > 0 && cfg.recorderStatements > 0 {
+		cfg.recorder = newTraceRecorder(cfg.recorderTraces, cfg.recorderStatements)
+	}
+	return cfg
+}
 
-func newCallbacks() *callbacks {
-	return &callbacks{}
+func (c *callbacks) config() *config {
+	return c.cfg.Load().(*config)
 }
 
-func (c *callbacks) beforeCreate(scope *gorm.Scope)   { c.before(scope) }
+// callbacksFromGorm returns callbacks added to db, or callbacks with default options
+func callbacksFromGorm(db *gorm.DB) *callbacks {
+	if val, ok := db.Get(CallbacksGormKey); ok {
+		if c, ok := val.(*callbacks); ok {
+			return c
+		}
+	}
+	return newCallbacks()
+}
+
+func newCallbacks(opts ...Option) *callbacks {
+	c := &callbacks{}
+	cfg := newConfig(opts...)
+	c.cfg.Store(cfg)
+	if cfg.asyncWorkers > 0 {
+		c.finisher = newAsyncFinisher(c, cfg.asyncWorkers, cfg.asyncQueue)
+	}
+	return c
+}
+
+func (c *callbacks) beforeCreate(scope *gorm.Scope)   { c.before(scope, "INSERT") }
 func (c *callbacks) afterCreate(scope *gorm.Scope)    { c.after(scope, "INSERT") }
-func (c *callbacks) beforeQuery(scope *gorm.Scope)    { c.before(scope) }
+func (c *callbacks) beforeQuery(scope *gorm.Scope)    { c.before(scope, "SELECT") }
 func (c *callbacks) afterQuery(scope *gorm.Scope)     { c.after(scope, "SELECT") }
-func (c *callbacks) beforeUpdate(scope *gorm.Scope)   { c.before(scope) }
+func (c *callbacks) beforeUpdate(scope *gorm.Scope)   { c.before(scope, "UPDATE") }
 func (c *callbacks) afterUpdate(scope *gorm.Scope)    { c.after(scope, "UPDATE") }
-func (c *callbacks) beforeDelete(scope *gorm.Scope)   { c.before(scope) }
+func (c *callbacks) beforeDelete(scope *gorm.Scope)   { c.before(scope, "DELETE") }
 func (c *callbacks) afterDelete(scope *gorm.Scope)    { c.after(scope, "DELETE") }
-func (c *callbacks) beforeRowQuery(scope *gorm.Scope) { c.before(scope) }
+func (c *callbacks) beforeRowQuery(scope *gorm.Scope) { c.before(scope, "") }
 func (c *callbacks) afterRowQuery(scope *gorm.Scope)  { c.after(scope, "") }
 
-func (c *callbacks) before(scope *gorm.Scope) {
-	val, ok := scope.Get(parentSpanGormKey)
+func (c *callbacks) before(scope *gorm.Scope, operation string) {
+	cfg := c.config()
+	if !c.enabled() {
+		return
+	}
+	defer c.recoverCallback(scope, false)
+	// untraced queries return after a single lookup without allocations, see TestUntracedAllocs
+	val, ok := scope.Get(ParentSpanGormKey)
 	if !ok {
+		c.status.countUntraced()
 		return
 	}
-	parentSpan := val.(opentracing.Span)
-	tr := parentSpan.Tracer()
-	sp := tr.StartSpan("sql", opentracing.ChildOf(parentSpan.Context()))
-	ext.DBType.Set(sp, scope.DB().Dialect().GetName())
-	ext.DBInstance.Set(sp, scope.InstanceID())
-	scope.Set(spanGormKey, sp)
+	parent, tr, ok := cfg.spanParent(val)
+	if !ok {
+		c.status.countUntraced()
+		return
+	}
+	callStart := time.Now()
+
+	// the query is already traced by another callbacks instance
+	duplicate := false
+	if val, ok := scope.Get(SpanOwnerGormKey); ok && val != nil && val != c {
+		if cfg.duplicateMode == DuplicateSuppress {
+			return
+		}
+		duplicate = true
+	}
+
+	// sql span is a child of operation span when operation spans are enabled
+	if val, ok := scope.Get(OperationSpanGormKey); ok {
+		if opSpan, ok := val.(opentracing.Span); ok {
+			parent, tr = opSpan.Context(), opSpan.Tracer()
+		}
+	}
+	// hot loops are traced up to the budget, the next traced query reports how many were dropped
+	var dropped int64
+	if budget := cfg.budget; budget != nil {
+		var allowed bool
+		if allowed, dropped = budget.allow(scope.TableName(), operation, time.Now()); !allowed {
+			return
+		}
+	}
+
+	dbType, version := c.dbType(scope, cfg)
+	start := time.Now()
+	var sp opentracing.Span
+	spanKey := SpanGormKey
+	if _, ok := parentStatsFromScope(scope); ok && cfg.repeatWindow > 0 {
+		// the statement isn't known yet, the span is started when it's finished unless it's merged
+		sp = cfg.sanitizeSpan(newPendingSpan(tr, "sql", parent, start))
+		spanKey = pendingSpanGormKey
+	} else {
+		sp = cfg.sanitizeSpan(tr.StartSpan("sql", opentracing.ChildOf(parent), opentracing.StartTime(start)))
+	}
+	c.status.countSpan(scope.TableName())
+	if cfg.has(TagType) {
+		ext.DBType.Set(sp, dbType)
+	}
+	if cfg.has(TagInstance) {
+		if instance := c.instance(scope, cfg); instance != "" {
+			ext.DBInstance.Set(sp, instance)
+		}
+	}
+	if version != "" && cfg.has(TagVersion) {
+		sp.SetTag("db.version", version)
+	}
+	if pool := cfg.poolName; pool != "" {
+		sp.SetTag("db.pool.name", pool)
+	}
+	if dropped > 0 {
+		sp.SetTag("db.budget.dropped", dropped)
+	}
+	if duplicate {
+		sp.SetTag("db.duplicate", true)
+	}
+	if cfg.queryID {
+		setQueryID(scope, sp, operation)
+	}
+	if cfg.traceSetting != "" {
+		c.setTraceSetting(scope, sp, cfg)
+	}
+	if cfg.runtimeTrace {
+		startRuntimeTrace(scope, operation)
+	}
+	if cfg.pprofLabels {
+		setPprofLabels(scope, operation)
+	}
+
+	// queries started with almost no budget left are likely to time out
+	if ctx, ok := contextFromScope(scope); ok {
+		if deadline, ok := ctx.Deadline(); ok && cfg.has(TagDeadline) {
+			sp.SetTag("db.deadline_remaining_ms", float64(deadline.Sub(start))/float64(time.Millisecond))
+		}
+		// gorm ignores context, so the query is executed even if the caller already gave up
+		if err := ctx.Err(); err != nil {
+			sp.LogFields(log.String("event", "context cancelled before query"), log.Error(err))
+		}
+
+		if cfg.statementTimeout && operation != "" {
+			c.setStatementTimeout(scope, ctx, sp, operation, cfg)
+		}
+		if cfg.maxExecutionTime && operation == "SELECT" {
+			c.setMaxExecutionTime(scope, ctx, cfg)
+		}
+	}
+
+	scope.Set(spanKey, sp)
+	scope.Set(SpanOwnerGormKey, c)
+	scope.Set(StartTimeGormKey, start)
+	scope.Set(OverheadGormKey, time.Since(callStart))
 }
 
 func (c *callbacks) after(scope *gorm.Scope, operation string) {
-	val, ok := scope.Get(spanGormKey)
+	cfg := c.config()
+	defer c.recoverCallback(scope, true)
+	if cfg.auditSink != nil {
+		c.emitAudit(scope, operation, cfg)
+	}
+	if val, ok := scope.Get(SpanOwnerGormKey); !ok || val != c {
+		return
+	}
+	sp, ok := spanFromScope(scope)
 	if !ok {
 		return
 	}
-	sp := val.(opentracing.Span)
+	if cfg.runtimeTrace {
+		defer endRuntimeTrace(scope)
+	}
+	if cfg.pprofLabels {
+		defer restorePprofLabels(scope)
+	}
+	afterStart := time.Now()
 	if operation == "" {
-		operation = strings.ToUpper(strings.Split(scope.SQL, " ")[0])
+		operation = queryOperation(sp, scope.SQL)
 	}
 	ext.Error.Set(sp, scope.HasError())
-	sp.SetTag("db.table", scope.TableName())
-	sp.SetTag("db.method", operation)
-	sp.SetTag("db.count", scope.DB().RowsAffected)
+	if cfg.operationTableNames {
+		sp.SetOperationName(spanName(operation, scope.TableName()))
+	}
+	if cfg.has(TagTable) {
+		sp.SetTag("db.table", scope.TableName())
+		if tables, ok := parseJoinedTables(scope.SQL); ok {
+			sp.SetTag("db.sql.tables", strings.Join(tables, ","))
+		}
+	}
+	if cfg.has(TagModel) {
+		if model := modelName(scope.Value); model != "" {
+			sp.SetTag("db.model", model)
+		}
+	}
+	if cfg.has(TagMethod) {
+		sp.SetTag("db.method", operation)
+	}
+	// rows of db.Row and db.Rows are read after the query, RowsAffected is always 0 for them,
+	// Close of Rows tags its span with the number of rows
+	_, rowQuery := scope.InstanceGet("row_query_result")
+	if cfg.has(TagCount) && !rowQuery {
+		sp.SetTag("db.count", scope.DB().RowsAffected)
+	}
+	if cfg.has(TagParams) {
+		c.setParams(sp, scope.SQLVars, cfg)
+	}
+
+	if operation == "UPDATE" && cfg.has(TagUpdatedColumns) {
+		if columns := updatedColumns(scope); len(columns) > 0 {
+			sp.SetTag("db.updated_columns", strings.Join(columns, ","))
+		}
+	}
+	if operation == "UPDATE" && len(cfg.updateCapture) > 0 {
+		c.logUpdate(sp, scope.TableName(), scope.SQL, scope.SQLVars, cfg)
+	}
+
+	// mirror gorm's soft delete decision, see gorm's deleteCallback
+	unscoped := scope.Search != nil && scope.Search.Unscoped
+	switch {
+	case !cfg.has(TagSoftDelete):
+	case operation == "DELETE":
+		_, hasDeletedAt := scope.FieldByName("DeletedAt")
+		sp.SetTag("db.soft_delete", !unscoped && hasDeletedAt)
+	case operation == "SELECT" && unscoped:
+		sp.SetTag("db.unscoped", true)
+	}
+
+	// primary key assigned on INSERT correlates the span with the created row
+	if cfg.lastInsertID && !cfg.noValues && operation == "INSERT" && !scope.HasError() && !scope.PrimaryKeyZero() {
+		sp.SetTag("db.last_insert_id", scope.PrimaryKeyValue())
+	}
+
+	// batch insert plugins and updates of slices process multiple records at once
+	if (operation == "INSERT" || operation == "UPDATE") && cfg.has(TagBatchSize) {
+		if value := scope.IndirectValue(); value.Kind() == reflect.Slice {
+			sp.SetTag("db.batch.size", value.Len())
+		}
+	}
+
+	// Find scans into the slice inside gorm:query callback, so its length is the number of returned rows
+	if operation == "SELECT" && !rowQuery && cfg.has(TagCount) {
+		if value := scope.IndirectValue(); value.Kind() == reflect.Slice {
+			sp.SetTag("db.rows_returned", value.Len())
+		}
+	}
+
+	// raw queries are built from conditions, so check the hint made it into the statement
+	if cfg.maxExecutionTime && operation == "SELECT" {
+		if ms, ok := parseMaxExecutionTime(scope.SQL); ok {
+			sp.SetTag("db.max_execution_time_ms", ms)
+		}
+	}
+
+	// huge offsets reveal deep pagination
+	if operation == "SELECT" && cfg.has(TagPagination) {
+		limit, hasLimit, offset, hasOffset := parsePagination(scope.SQL, scope.SQLVars)
+		if hasLimit {
+			sp.SetTag("db.limit", limit)
+		}
+		if hasOffset {
+			sp.SetTag("db.offset", offset)
+		}
+	}
+
+	// UPDATE and DELETE which matched nothing are often caused by a wrong condition
+	if cfg.noRowsMatched && !scope.HasError() && scope.DB().RowsAffected == 0 &&
+		(operation == "UPDATE" || operation == "DELETE") {
+		sp.SetTag("db.no_rows_matched", true)
+	}
+
+	// queries leaked past the request, e.g. in a spawned goroutine, finish after their parent
+	if cfg.parentFinished != nil {
+		c.checkLateQuery(scope, sp, cfg)
+	}
+
+	// distinguish queries abandoned by the client from genuinely slow ones
+	if ctx, ok := contextFromScope(scope); ok && ctx.Err() != nil {
+		sp.SetTag("db.context_cancelled", true)
+		sp.LogFields(log.String("event", "context cancelled"), log.Error(ctx.Err()))
+	}
 
-	// set db error message tracing tag
 	if scope.HasError() {
-		sp.SetTag("db.err", scope.DB().Error)
+		c.setError(sp, scope.DB().Error, cfg)
+	} else if cfg.has(TagErr) {
+		sp.SetTag("db.err", false)
+	}
+
+	// application specific tags may carry values, they are audited by newConfig in no values mode
+	for _, key := range cfg.settingsTags {
+		if cfg.noValues {
+			break
+		}
+		if value, ok := scope.Get(key); ok {
+			sp.SetTag(key, value)
+		}
+	}
+	if cfg.tagsFunc != nil && !cfg.noValues {
+		for key, value := range cfg.tagsFunc(scope) {
+			sp.SetTag(key, value)
+		}
+	}
+	// resolvers receive the scope with its values, they are ignored in no values mode
+	if cfg.schemaResolver != nil && !cfg.noValues {
+		if schema := cfg.schemaResolver(scope); schema != "" {
+			sp.SetTag("db.schema", schema)
+		}
+	}
+	if cfg.shardTagger != nil && !cfg.noValues {
+		if shard, ok := cfg.shardTagger(scope); ok {
+			sp.SetTag("db.shard", shard)
+		}
+	}
+
+	// set explicit duration tag for backends which can't compute it from span timestamps
+	finish := time.Now()
+	if watchdog := cfg.watchdog; watchdog != nil {
+		// missing records are expected, they don't signal database trouble
+		failed := scope.HasError() && !gorm.IsRecordNotFoundError(scope.DB().Error)
+		watchdog.record(scope.TableName(), operation, failed, finish)
+	}
+	slow := false
+	var duration time.Duration
+	var exec execTiming
+	execOK := false
+	if cfg.execTiming || cfg.checkpointLogs || cfg.backendPIDQuery != "" {
+		exec, execOK = c.takeExecTime(scope.SQL)
+	}
+	if execOK && exec.backendPID != 0 && cfg.backendPIDQuery != "" {
+		sp.SetTag("db.backend_pid", exec.backendPID)
+	}
+	val, _ := scope.Get(StartTimeGormKey)
+	if start, ok := val.(time.Time); ok {
+		duration = finish.Sub(start)
+		if cfg.parentTotals || cfg.parentTimeTags {
+			c.addParentStats(scope, duration, cfg)
+		}
+		if cfg.requestSummary {
+			c.addSummary(scope, operation, start, duration, cfg)
+		}
+		if cfg.has(TagDuration) {
+			sp.SetTag("db.duration_ms", float64(finish.Sub(start))/float64(time.Millisecond))
+		}
+		slow = c.setSlow(sp, scope.HasError(), finish.Sub(start), cfg)
+		if slow && cfg.slowQuerySink != nil {
+			c.emitSlowQuery(scope, sp, finish.Sub(start), cfg)
+		}
+		if cfg.execTiming && execOK {
+			setExecTime(sp, exec, finish.Sub(start))
+		}
+	}
+
+	// set db full statement tracing tag and finish the span, asynchronously with WithAsyncFinish
+	job := finishJob{
+		sp:         sp,
+		query:      scope.SQL,
+		vars:       scope.SQLVars,
+		capture:    c.captureOnFailure(c.statementCapture(scope.TableName(), scope.SQL, cfg), scope.HasError(), slow, cfg),
+		finish:     finish,
+		afterStart: afterStart,
+		cfg:        cfg,
+	}
+	if val, ok := scope.Get(OverheadGormKey); ok {
+		job.overhead, _ = val.(time.Duration)
+	}
+	if cfg.checkpointLogs {
+		job.logs = checkpointLogs(exec, execOK, operation, finish)
+	}
+	// plan is sampled after the finish time is taken, so it doesn't inflate the duration
+	if operation == "SELECT" && !cfg.noValues && c.shouldExplain(scope, cfg) {
+		job.plan = c.planQuery(scope)
+	}
+	if cfg.callbackProfiling {
+		job.logs = append(job.logs, takeCallbackProfile(scope)...)
+	}
+	if cfg.recorder != nil {
+		c.recordStatement(scope, job, duration)
+	}
+	if query, ok := rowsQueryFromScope(scope); ok && rowQuery {
+		query.job, query.ok = job, true
+	} else if stats, ok := parentStatsFromScope(scope); ok && cfg.repeatWindow > 0 {
+		if !c.mergeRepeat(stats, job, scope.HasError(), duration) {
+			c.finishSpan(job)
+		}
+	} else {
+		c.finishSpan(job)
+	}
+
+	// nested operations cloned from this scope are not duplicates
+	clearSpan(scope)
+}
+
+// spanFromScope returns the sql span of the operation, or the pending span of WithRepeatCompression
+func spanFromScope(scope *gorm.Scope) (opentracing.Span, bool) {
+	val, ok := scope.Get(SpanGormKey)
+	if !ok || val == nil {
+		val, ok = scope.Get(pendingSpanGormKey)
+	}
+	if !ok {
+		return nil, false
+	}
+	sp, ok := val.(opentracing.Span)
+	return sp, ok
+}
+
+// clearSpan forgets the sql span of the operation once it's finished
+func clearSpan(scope *gorm.Scope) {
+	scope.Set(SpanGormKey, nil)
+	scope.Set(pendingSpanGormKey, nil)
+	scope.Set(SpanOwnerGormKey, nil)
+}
+
+// spanName returns "<OPERATION> <table>" name of the sql span of WithOperationTableNames
+func spanName(operation, table string) string {
+	switch {
+	case operation == "":
+		return "sql"
+	case table == "":
+		return operation
+	}
+	return operation + " " + table
+}
+
+// modelName returns name of the struct type of the model, e.g. User for &[]*User{}, it's empty for raw queries
+func modelName(value interface{}) string {
+	if value == nil {
+		return ""
 	}
+	t := reflect.TypeOf(value)
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return ""
+	}
+	return t.Name()
+}
+
+// setError logs error of the query with standard error fields, db.err tag is kept for compatibility, it's false
+// for succeeded queries and dropped with WithTags(AllTags &^ TagErr)
+func (c *callbacks) setError(sp opentracing.Span, err error, cfg *config) {
+	if cfg.has(TagErr) {
+		sp.SetTag("db.err", redactedError(err, cfg.options))
+	}
+	logError(sp, err, cfg.options)
+	if IsRetryable(err) {
+		sp.SetTag("db.retryable", true)
+	}
+}
+
+// logError logs error.kind, error.object and message fields of the OpenTracing spec
+func logError(sp opentracing.Span, err error, opts options) {
+	redacted := redactedError(err, opts)
+	sp.LogFields(
+		log.String("event", "error"),
+		log.String("error.kind", fmt.Sprintf("%T", err)),
+		log.Error(redacted),
+		log.String("message", redacted.Error()),
+	)
+}
+
+// setSlow tags queries slower than WithSlowThreshold and asks to sample slow and failed queries with WithSamplingPriority
+func (c *callbacks) setSlow(sp opentracing.Span, failed bool, duration time.Duration, cfg *config) bool {
+	slow := cfg.slowThreshold > 0 && duration >= cfg.slowThreshold
+	if slow {
+		sp.SetTag("db.slow", true)
+	}
+	if cfg.samplingPriority && (slow || failed) {
+		ext.SamplingPriority.Set(sp, 1)
+	}
+	return slow
+}
+
+// captureOnFailure drops statement capture of successful queries which aren't slow with WithFailedStatement
+func (c *callbacks) captureOnFailure(capture Capture, failed, slow bool, cfg *config) Capture {
+	if cfg.failedStatement && !failed && !slow {
+		return CaptureNone
+	}
+	return capture
+}
+
+// beforeOperation starts span covering the whole gorm operation: hooks, associations, transaction and scanning
+func (c *callbacks) beforeOperation(scope *gorm.Scope, name string) {
+	cfg := c.config()
+	if !cfg.operationSpans || !c.enabled() {
+		return
+	}
+	val, ok := scope.Get(ParentSpanGormKey)
+	if !ok {
+		return
+	}
+	parent, tr, ok := cfg.spanParent(val)
+	if !ok {
+		return
+	}
+	if cfg.granularity == GranularityCall {
+		// sql of nested operation is a child of the span of the outer gorm call
+		if val, ok := scope.Get(OperationSpanGormKey); ok && val != nil {
+			scope.Set(NestedOperationGormKey, true)
+			scope.Set(callbackTimingsGormKey, nil)
+			return
+		}
+	}
+	sp := cfg.sanitizeSpan(tr.StartSpan("gorm:"+name, opentracing.ChildOf(parent)))
+	if cfg.has(TagType) {
+		ext.DBType.Set(sp, dialectDBType(scope.DB().Dialect().GetName()))
+	}
+	scope.Set(OperationSpanGormKey, sp)
+	c.startCallbackTimings(scope, name)
+}
 
-	// set db full statement tracing tag
-	statement := setStatement(scope)
-	ext.DBStatement.Set(sp, statement)
+func (c *callbacks) afterOperation(scope *gorm.Scope) {
+	cfg := c.config()
+	if nested, ok := scope.Get(NestedOperationGormKey); ok && nested == true {
+		return
+	}
+	val, ok := scope.Get(OperationSpanGormKey)
+	if !ok {
+		return
+	}
+	sp, ok := val.(opentracing.Span)
+	if !ok {
+		return
+	}
+	ext.Error.Set(sp, scope.HasError())
+	if cfg.has(TagTable) {
+		sp.SetTag("db.table", scope.TableName())
+	}
+	finish := time.Now()
+	logs := c.finishCallbackTimings(scope, finish)
+	if cfg.callbackProfiling {
+		logs = append(logs, takeCallbackProfile(scope)...)
+	}
+	sp.FinishWithOptions(opentracing.FinishOptions{FinishTime: finish, LogRecords: logs})
 
-	sp.Finish()
+	// nested operations cloned from this scope must not use finished span as a parent
+	scope.Set(OperationSpanGormKey, nil)
 }
 
 func registerCallbacks(db *gorm.DB, name string, c *callbacks) {
@@ -121,72 +759,49 @@ func registerCallbacks(db *gorm.DB, name string, c *callbacks) {
 	}
 }
 
-func setStatement(scope *gorm.Scope) string {
-	var result = scope.SQL
-
-	for i := 1; i <= len(scope.SQLVars); i++ {
-		var sqlValue = "NULL"
-
-		// get value from sql vars
-		val := scope.SQLVars[i-1]
-
-		// get reflect
-		ref := reflect.ValueOf(val).Kind()
-
-		// check for reflect kind of string
-		switch ref {
-		case reflect.String:
-			sqlValue = fmt.Sprintf(`'%s'`, val)
-		case reflect.Interface:
-
-			// check type of interface
-			switch val.(type) {
-			case time.Time:
-				time := val.(time.Time)
-				sqlValue = fmt.Sprintf(`'%v'`, time.String())
-			case sql.NullTime:
-				null := val.(sql.NullTime)
-				if null.Valid {
-					sqlValue = fmt.Sprintf(`'%v'`, null.Time.String())
-				}
-			case sql.NullString:
-				null := val.(sql.NullString)
-				if null.Valid {
-					sqlValue = fmt.Sprintf(`'%v'`, null.String)
-				}
-			case sql.NullInt64:
-				null := val.(sql.NullInt64)
-				if null.Valid {
-					sqlValue = fmt.Sprintf(`%v`, null.Int64)
-				}
-			case sql.NullInt32:
-				null := val.(sql.NullInt32)
-				if null.Valid {
-					sqlValue = fmt.Sprintf(`%v`, null.Int32)
-				}
-			case sql.NullBool:
-				null := val.(sql.NullBool)
-				if null.Valid {
-					sqlValue = fmt.Sprintf(`%v`, null.Bool)
-				}
-			case sql.NullFloat64:
-				null := val.(sql.NullFloat64)
-				if null.Valid {
-					sqlValue = fmt.Sprintf(`%v`, null.Float64)
-				}
-			case pq.NullTime:
-				null := val.(pq.NullTime)
-				if null.Valid {
-					sqlValue = fmt.Sprintf(`'%v'`, null.Time)
-				}
-			}
-		default:
-			sqlValue = fmt.Sprintf(`%v`, val)
-		}
-
-		// replace string
-		result = strings.Replace(result, fmt.Sprintf(`$%d`, i), sqlValue, -1)
-	}
-
-	return result
+// registerOptionalCallbacks registers operation callbacks of WithOperationSpans and timing callbacks
+// of WithCallbackTimings unless they are registered or disabled, so traced operations don't run them for nothing
+func (c *callbacks) registerOptionalCallbacks(db *gorm.DB) {
+	c.registerMu.Lock()
+	defer c.registerMu.Unlock()
+	if c.config().operationSpans && !c.operationCallbacks {
+		for _, name := range []string{"create", "query", "update", "delete"} {
+			registerOperationCallbacks(db, name, c)
+		}
+		c.operationCallbacks = true
+	}
+	if c.config().callbackTimings && !c.timingCallbacks {
+		for _, name := range []string{"create", "query", "update", "delete"} {
+			registerTimingCallbacks(db, name, c)
+		}
+		c.timingCallbacks = true
+	}
+}
+
+// hasOperationCallbacks returns whether operation callbacks are registered
+func (c *callbacks) hasOperationCallbacks() bool {
+	c.registerMu.Lock()
+	defer c.registerMu.Unlock()
+	return c.operationCallbacks
+}
+
+func registerOperationCallbacks(db *gorm.DB, name string, c *callbacks) {
+	beforeName := fmt.Sprintf("tracing:%v_operation_before", name)
+	afterName := fmt.Sprintf("tracing:%v_operation_after", name)
+	before := func(scope *gorm.Scope) { c.beforeOperation(scope, name) }
+	// operation callbacks wrap the whole gorm callbacks chain
+	switch name {
+	case "create":
+		db.Callback().Create().Before("gorm:begin_transaction").Register(beforeName, before)
+		db.Callback().Create().After("gorm:commit_or_rollback_transaction").Register(afterName, c.afterOperation)
+	case "query":
+		db.Callback().Query().Before("tracing:query_before").Register(beforeName, before)
+		db.Callback().Query().After("gorm:after_query").Register(afterName, c.afterOperation)
+	case "update":
+		db.Callback().Update().Before("gorm:assign_updating_attributes").Register(beforeName, before)
+		db.Callback().Update().After("gorm:commit_or_rollback_transaction").Register(afterName, c.afterOperation)
+	case "delete":
+		db.Callback().Delete().Before("gorm:begin_transaction").Register(beforeName, before)
+		db.Callback().Delete().After("gorm:commit_or_rollback_transaction").Register(afterName, c.afterOperation)
+	}
 }
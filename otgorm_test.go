@@ -1,12 +1,26 @@
 package otgorm_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
 	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
 	"github.com/opentracing/opentracing-go/mocktracer"
 	otgorm "github.com/smacker/opentracing-gorm"
 )
@@ -59,18 +73,31 @@ func TestPool(t *testing.T) {
 	}
 
 	expectedTags := map[string]interface{}{
-		"error":        false,
-		"db.table":     "products",
-		"db.method":    "SELECT",
-		"db.type":      "sql",
-		"db.statement": `SELECT * FROM "products"  WHERE "products"."deleted_at" IS NULL AND (("products"."id" = 1)) ORDER BY "products"."id" ASC LIMIT 1`,
-		"db.err":       false,
-		"db.count":     int64(1),
+		"error":           false,
+		"db.table":        "products",
+		"db.model":        "Product",
+		"db.method":       "SELECT",
+		"db.type":         "sql",
+		"db.instance":     "main",
+		"db.statement":    `SELECT * FROM "products"  WHERE "products"."deleted_at" IS NULL AND (("products"."id" = 1)) ORDER BY "products"."id" ASC LIMIT 1`,
+		"db.err":          false,
+		"db.count":        int64(1),
+		"db.limit":        int64(1),
+		"db.params.count": 0,
+		"db.params.bytes": 0,
 	}
 
+	// these tags differ between runs, only check they are present
+	dynamicTags := []string{"db.duration_ms"}
+
 	sqlTags := sqlSpan.Tags()
-	if len(sqlTags) != len(expectedTags) {
-		t.Errorf("sql span should have %d tags but it has %d", len(expectedTags), len(sqlTags))
+	for _, name := range dynamicTags {
+		if _, ok := sqlTags[name]; !ok {
+			t.Errorf("sql span doesn't have tag '%s'", name)
+		}
+	}
+	if len(sqlTags) != len(expectedTags)+len(dynamicTags) {
+		t.Errorf("sql span should have %d tags but it has %d", len(expectedTags)+len(dynamicTags), len(sqlTags))
 	}
 
 	for name, expected := range expectedTags {
@@ -88,3 +115,1747 @@ func TestPool(t *testing.T) {
 		t.Errorf("second span operation should be handler but it's '%s'", spans[1].OperationName)
 	}
 }
+
+func newDB(t *testing.T, opts ...otgorm.Option) *gorm.DB {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.DB().SetMaxOpenConns(1)
+	db.AutoMigrate(&Product{})
+	otgorm.AddGormCallbacks(db, opts...)
+	tracer.Reset()
+	return db
+}
+
+func tracedDB(db *gorm.DB) (*gorm.DB, opentracing.Span) {
+	span := tracer.StartSpan("test")
+	return otgorm.SetSpanToGorm(opentracing.ContextWithSpan(context.Background(), span), db), span
+}
+
+func TestMaxParams(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithMaxParams(1)))
+	db.Create(&Product{Code: "L1212"})
+	span.Finish()
+
+	sqlSpan := tracer.FinishedSpans()[0]
+	if truncated := sqlSpan.Tag("db.statement.truncated_params"); truncated != true {
+		t.Errorf("sql span tag 'db.statement.truncated_params' should be true but it's '%v'", truncated)
+	}
+}
+
+func TestOperationSpans(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithOperationSpans()))
+	var product Product
+	db.Create(&Product{Code: "L1212"})
+	db.First(&product)
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 5 {
+		t.Fatalf("should be 5 finished spans but there are %d: %v", len(spans), spans)
+	}
+	for i, name := range []string{"sql", "gorm:create", "sql", "gorm:query", "test"} {
+		if spans[i].OperationName != name {
+			t.Errorf("span %d operation should be %s but it's '%s'", i, name, spans[i].OperationName)
+		}
+	}
+	if spans[0].ParentID != spans[1].SpanContext.SpanID || spans[2].ParentID != spans[3].SpanContext.SpanID {
+		t.Errorf("sql spans should be children of operation spans")
+	}
+	if spans[1].ParentID != spans[4].SpanContext.SpanID {
+		t.Errorf("operation span should be a child of parent span")
+	}
+}
+
+type Cart struct {
+	gorm.Model
+	Items []CartItem
+}
+
+type CartItem struct {
+	gorm.Model
+	CartID uint
+	Name   string
+}
+
+func TestGranularityCall(t *testing.T) {
+	db := newDB(t, otgorm.WithGranularity(otgorm.GranularityCall))
+	db.AutoMigrate(&Cart{}, &CartItem{})
+	tracer.Reset()
+	db, span := tracedDB(db)
+	db.Create(&Cart{Items: []CartItem{{Name: "a"}, {Name: "b"}}})
+	db.Preload("Items").Find(&[]Cart{})
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	var names []string
+	for _, sp := range spans {
+		names = append(names, sp.OperationName)
+	}
+	expected := "sql,sql,sql,gorm:create,sql,sql,gorm:query,test"
+	if strings.Join(names, ",") != expected {
+		t.Fatalf("spans should be %s but they are %v", expected, names)
+	}
+	for i, parent := range []int{3, 3, 3, 7, 6, 6, 7} {
+		if spans[i].ParentID != spans[parent].SpanContext.SpanID {
+			t.Errorf("span %d should be a child of span %d", i, parent)
+		}
+	}
+}
+
+func TestCallbackTimings(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithCallbackTimings()))
+	db.Create(&Product{Code: "L1212"})
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 3 || spans[1].OperationName != "gorm:create" {
+		t.Fatalf("should be sql, gorm:create and test spans but there are %v", spans)
+	}
+	var events []string
+	for _, l := range spans[1].Logs() {
+		events = append(events, l.Fields[0].ValueString)
+		if l.Fields[1].Key != "duration_ms" {
+			t.Errorf("callback log should have duration_ms but it has %v", l.Fields)
+		}
+	}
+	expected := []string{
+		"gorm:begin_transaction", "gorm:before_create", "gorm:save_before_associations", "gorm:update_time_stamp",
+		"gorm:create", "gorm:force_reload_after_create", "gorm:save_after_associations", "gorm:after_create",
+		"gorm:commit_or_rollback_transaction",
+	}
+	if strings.Join(events, ",") != strings.Join(expected, ",") {
+		t.Errorf("callback logs should be %v but they are %v", expected, events)
+	}
+}
+
+func TestNoRowsMatched(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithNoRowsMatched()))
+	db.Create(&Product{Code: "L1212"})
+	db.Model(&Product{}).Where("code = ?", "L1212").Update("code", "L1213")
+	db.Model(&Product{}).Where("code = ?", "L1212").Update("code", "L1214")
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	for i, expected := range []interface{}{nil, nil, true} {
+		if matched := spans[i].Tag("db.no_rows_matched"); matched != expected {
+			t.Errorf("span %d tag 'db.no_rows_matched' should be '%v' but it's '%v'", i, expected, matched)
+		}
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	db, span := tracedDB(newDB(t))
+	db.Create(&Product{Code: "L1212"})
+	db.Where("code = ?", "L1212").Delete(&Product{})
+	db.Unscoped().Find(&[]Product{})
+	db.Unscoped().Where("code = ?", "L1212").Delete(&Product{})
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if softDelete := spans[1].Tag("db.soft_delete"); softDelete != true {
+		t.Errorf("span tag 'db.soft_delete' should be true but it's '%v'", softDelete)
+	}
+	if unscoped := spans[2].Tag("db.unscoped"); unscoped != true {
+		t.Errorf("span tag 'db.unscoped' should be true but it's '%v'", unscoped)
+	}
+	if softDelete := spans[3].Tag("db.soft_delete"); softDelete != false {
+		t.Errorf("span tag 'db.soft_delete' should be false but it's '%v'", softDelete)
+	}
+}
+
+type Order struct {
+	gorm.Model
+	Number string `gorm:"index"`
+}
+
+func TestTraceMigration(t *testing.T) {
+	db := newDB(t)
+	err := otgorm.TraceMigration(context.Background(), db, func(db *gorm.DB) error {
+		return db.AutoMigrate(&Order{}).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := tracer.FinishedSpans()
+	if len(spans) < 2 {
+		t.Fatalf("should be at least 2 finished spans but there are %d: %v", len(spans), spans)
+	}
+	migrationSpan := spans[len(spans)-1]
+	if migrationSpan.OperationName != "gorm:migration" {
+		t.Errorf("last span operation should be gorm:migration but it's '%s'", migrationSpan.OperationName)
+	}
+	if method := spans[0].Tag("db.method"); method != "CREATE TABLE" {
+		t.Errorf("first span tag 'db.method' should be 'CREATE TABLE' but it's '%v'", method)
+	}
+	for _, sp := range spans[:len(spans)-1] {
+		if sp.ParentID != migrationSpan.SpanContext.SpanID {
+			t.Errorf("span '%v' should be a child of migration span", sp.Tag("db.statement"))
+		}
+	}
+
+	dbTracer := mocktracer.New()
+	err = otgorm.TraceMigration(context.Background(), newDB(t, otgorm.WithTracer(dbTracer)), func(db *gorm.DB) error {
+		return db.AutoMigrate(&Order{}).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spans := dbTracer.FinishedSpans(); len(spans) == 0 || spans[len(spans)-1].OperationName != "gorm:migration" {
+		t.Errorf("migration span should be started by the tracer of WithTracer but its spans are %v", spans)
+	}
+}
+
+func TestFirstOrCreate(t *testing.T) {
+	db, span := tracedDB(newDB(t))
+	var product Product
+	otgorm.FirstOrCreate(db, &product, Product{Code: "L1212"})
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 4 {
+		t.Fatalf("should be 4 finished spans but there are %d: %v", len(spans), spans)
+	}
+	groupSpan := spans[2]
+	if groupSpan.OperationName != "gorm:first_or_create" {
+		t.Errorf("third span operation should be gorm:first_or_create but it's '%s'", groupSpan.OperationName)
+	}
+	for i, method := range []string{"SELECT", "INSERT"} {
+		if spans[i].Tag("db.method") != method || spans[i].ParentID != groupSpan.SpanContext.SpanID {
+			t.Errorf("%s span should be a child of first_or_create span", method)
+		}
+	}
+	if groupSpan.ParentID != spans[3].SpanContext.SpanID {
+		t.Errorf("first_or_create span should be a child of parent span")
+	}
+}
+
+func TestLastInsertID(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithLastInsertID()))
+	product := Product{Code: "L1212"}
+	db.Create(&product)
+	span.Finish()
+
+	if id := tracer.FinishedSpans()[0].Tag("db.last_insert_id"); id != product.ID {
+		t.Errorf("span tag 'db.last_insert_id' should be '%v' but it's '%v'", product.ID, id)
+	}
+}
+
+func TestBatchSize(t *testing.T) {
+	db, span := tracedDB(newDB(t))
+	db.Create(&Product{Code: "L1212"})
+	db.Create(&Product{Code: "L1213"})
+	var products []Product
+	db.Find(&products)
+	db.Model(&products).Update("code", "L1214")
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if size := spans[0].Tag("db.batch.size"); size != nil {
+		t.Errorf("single record span shouldn't have tag 'db.batch.size' but it has '%v'", size)
+	}
+	if size := spans[3].Tag("db.batch.size"); size != 2 {
+		t.Errorf("span tag 'db.batch.size' should be 2 but it's '%v'", size)
+	}
+}
+
+func TestStartJobSpan(t *testing.T) {
+	db := newDB(t)
+	ctx, span, jobDB := otgorm.StartJobSpan(context.Background(), db, "cleanup")
+	jobDB.Find(&[]Product{})
+	otgorm.FromContext(ctx).Find(&[]Product{})
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 3 {
+		t.Fatalf("should be 3 finished spans but there are %d: %v", len(spans), spans)
+	}
+	jobSpan := spans[2]
+	if jobSpan.OperationName != "cleanup" || jobSpan.ParentID != 0 {
+		t.Errorf("job span should be a root span named cleanup")
+	}
+	for _, sp := range spans[:2] {
+		if sp.ParentID != jobSpan.SpanContext.SpanID {
+			t.Errorf("sql span should be a child of job span")
+		}
+	}
+
+	dbTracer := mocktracer.New()
+	_, span, jobDB = otgorm.StartJobSpan(context.Background(), newDB(t, otgorm.WithTracer(dbTracer)), "cleanup")
+	jobDB.Find(&[]Product{})
+	span.Finish()
+	if spans := dbTracer.FinishedSpans(); len(spans) != 2 || spans[1].OperationName != "cleanup" {
+		t.Errorf("job span should be started by the tracer of WithTracer but its spans are %v", spans)
+	}
+
+	// the job ending on repeated statements doesn't lose them
+	_, span, jobDB = otgorm.StartJobSpan(context.Background(), newDB(t, otgorm.WithRepeatCompression(time.Hour)), "cleanup")
+	for i := 0; i < 3; i++ {
+		jobDB.Find(&[]Product{})
+	}
+	span.Finish()
+	spans = tracer.FinishedSpans()
+	if len(spans) != 3 || spans[1].Tag("db.repeat_count") != int64(2) || spans[2].OperationName != "cleanup" {
+		t.Errorf("repeated statements should be finished with the job span but spans are %v", spans)
+	}
+}
+
+func TestDeadlineRemaining(t *testing.T) {
+	db := newDB(t)
+	span := tracer.StartSpan("test")
+	ctx, cancel := context.WithTimeout(opentracing.ContextWithSpan(context.Background(), span), time.Minute)
+	defer cancel()
+	otgorm.SetSpanToGorm(ctx, db).Find(&[]Product{})
+	span.Finish()
+
+	remaining, ok := tracer.FinishedSpans()[0].Tag("db.deadline_remaining_ms").(float64)
+	if !ok || remaining <= 0 || remaining > float64(time.Minute/time.Millisecond) {
+		t.Errorf("span tag 'db.deadline_remaining_ms' should be within a minute but it's '%v'", remaining)
+	}
+}
+
+func TestContextCancelled(t *testing.T) {
+	db := newDB(t)
+	span := tracer.StartSpan("test")
+	ctx, cancel := context.WithCancel(opentracing.ContextWithSpan(context.Background(), span))
+	cancel()
+	otgorm.SetSpanToGorm(ctx, db).Find(&[]Product{})
+	span.Finish()
+
+	sqlSpan := tracer.FinishedSpans()[0]
+	if cancelled := sqlSpan.Tag("db.context_cancelled"); cancelled != true {
+		t.Errorf("span tag 'db.context_cancelled' should be true but it's '%v'", cancelled)
+	}
+	if logs := sqlSpan.Logs(); len(logs) != 2 {
+		t.Errorf("span should have 2 logs but it has %d", len(logs))
+	}
+}
+
+func TestRetry(t *testing.T) {
+	db := newDB(t)
+	attempts := 0
+	err := otgorm.Retry(context.Background(), db, otgorm.DefaultRetryPolicy, func(db *gorm.DB) error {
+		attempts++
+		if err := db.Find(&[]Product{}).Error; err != nil {
+			return err
+		}
+		if attempts < 3 {
+			return errors.New("Error 1213: Deadlock found when trying to get lock; try restarting transaction")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 4 {
+		t.Fatalf("should be 4 finished spans but there are %d: %v", len(spans), spans)
+	}
+	retrySpan := spans[3]
+	if retrySpan.OperationName != "gorm:retry" {
+		t.Errorf("last span operation should be gorm:retry but it's '%s'", retrySpan.OperationName)
+	}
+	if logs := retrySpan.Logs(); len(logs) != 2 {
+		t.Errorf("retry span should have 2 logs but it has %d", len(logs))
+	}
+	if attempts := retrySpan.Tag("db.retry.attempts"); attempts != 3 {
+		t.Errorf("retry span tag 'db.retry.attempts' should be 3 but it's '%v'", attempts)
+	}
+
+	tracer.Reset()
+	notRetryable := errors.New("syntax error")
+	err = otgorm.Retry(context.Background(), db, otgorm.DefaultRetryPolicy, func(db *gorm.DB) error {
+		return notRetryable
+	})
+	if err != notRetryable {
+		t.Errorf("not retryable error should be returned as is but it's '%v'", err)
+	}
+
+	dbTracer := mocktracer.New()
+	otgorm.Retry(context.Background(), newDB(t, otgorm.WithTracer(dbTracer)), otgorm.DefaultRetryPolicy, func(db *gorm.DB) error {
+		return db.Find(&[]Product{}).Error
+	})
+	if spans := dbTracer.FinishedSpans(); len(spans) != 2 || spans[1].OperationName != "gorm:retry" {
+		t.Errorf("retry span should be started by the tracer of WithTracer but its spans are %v", spans)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	cases := map[string]bool{
+		"pq: restart transaction: TransactionRetryWithProtoRefreshError: ReadWithinUncertaintyIntervalError": true,
+		"pq: could not serialize access due to concurrent update":                                            true,
+		"Error 1213: Deadlock found when trying to get lock; try restarting transaction":                     true,
+		"pq: duplicate key value violates unique constraint":                                                 false,
+	}
+
+	for msg, expected := range cases {
+		if actual := otgorm.IsRetryable(errors.New(msg)); actual != expected {
+			t.Errorf("IsRetryable(%q) should be %v but it's %v", msg, expected, actual)
+		}
+	}
+}
+
+func TestServerVersion(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithServerVersion()))
+	db.Find(&[]Product{})
+	db.Find(&[]Product{})
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 3 {
+		t.Fatalf("should be 3 finished spans but there are %d: %v", len(spans), spans)
+	}
+	for _, sqlSpan := range spans[:2] {
+		if version, ok := sqlSpan.Tag("db.version").(string); !ok || !strings.HasPrefix(version, "3.") {
+			t.Errorf("sql span tag 'db.version' should be sqlite version but it's '%v'", sqlSpan.Tag("db.version"))
+		}
+	}
+}
+
+func TestSamplingPriority(t *testing.T) {
+	// children of not sampled span aren't sampled unless they ask for it
+	db, span := tracedDB(newDB(t, otgorm.WithSlowThreshold(time.Nanosecond), otgorm.WithSamplingPriority()))
+	ext.SamplingPriority.Set(span, 0)
+	db.Find(&[]Product{})
+	span.Finish()
+
+	sqlSpan := tracer.FinishedSpans()[0]
+	if slow := sqlSpan.Tag("db.slow"); slow != true {
+		t.Errorf("sql span tag 'db.slow' should be true but it's '%v'", slow)
+	}
+	if !sqlSpan.Context().(mocktracer.MockSpanContext).Sampled {
+		t.Errorf("slow sql span should be sampled")
+	}
+
+	db, span = tracedDB(newDB(t, otgorm.WithSamplingPriority()))
+	ext.SamplingPriority.Set(span, 0)
+	db.Find(&[]Product{})
+	db.Table("missing").Find(&[]Product{})
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if spans[0].Context().(mocktracer.MockSpanContext).Sampled {
+		t.Errorf("sql span should not be sampled")
+	}
+	if !spans[1].Context().(mocktracer.MockSpanContext).Sampled {
+		t.Errorf("failed sql span should be sampled")
+	}
+}
+
+func TestSpanBudget(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithSpanBudget(2)))
+	for i := 0; i < 5; i++ {
+		db.Find(&[]Product{})
+	}
+	span.Finish()
+
+	// 2 sql spans and the test span, up to 2 more if the loop crossed a second boundary
+	if spans := tracer.FinishedSpans(); len(spans) < 3 || len(spans) > 5 {
+		t.Errorf("should be 3 finished spans but there are %d: %v", len(spans), spans)
+	}
+}
+
+func TestAsyncFinish(t *testing.T) {
+	db := newDB(t, otgorm.WithAsyncFinish(2, 10))
+	tdb, span := tracedDB(db)
+	for i := 0; i < 5; i++ {
+		tdb.Where("code = ?", "L1212").Find(&[]Product{})
+	}
+	otgorm.Flush(db)
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 6 {
+		t.Fatalf("should be 6 finished spans but there are %d: %v", len(spans), spans)
+	}
+	for _, sqlSpan := range spans[:5] {
+		if statement := sqlSpan.Tag("db.statement"); statement != "SELECT * FROM \"products\"  WHERE \"products\".\"deleted_at\" IS NULL AND ((code = 'L1212'))" {
+			t.Errorf("sql span tag 'db.statement' is wrong: '%v'", statement)
+		}
+	}
+}
+
+func TestTags(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithTags(otgorm.AllTags&^(otgorm.TagStatement|otgorm.TagCount))))
+	db.Find(&[]Product{})
+	span.Finish()
+
+	sqlSpan := tracer.FinishedSpans()[0]
+	for _, tag := range []string{"db.statement", "db.count"} {
+		if value := sqlSpan.Tag(tag); value != nil {
+			t.Errorf("sql span tag '%s' should be empty but it's '%v'", tag, value)
+		}
+	}
+	if table := sqlSpan.Tag("db.table"); table != "products" {
+		t.Errorf("sql span tag 'db.table' should be 'products' but it's '%v'", table)
+	}
+
+	db, span = tracedDB(newDB(t, otgorm.WithTags(otgorm.AllTags&^(otgorm.TagSoftDelete|otgorm.TagPagination|otgorm.TagBatchSize))))
+	db.Limit(1).Offset(1).Find(&[]Product{})
+	db.Delete(&Product{}, "code = ?", "L1")
+	span.Finish()
+	for _, sqlSpan := range tracer.FinishedSpans()[:2] {
+		for _, tag := range []string{"db.limit", "db.offset", "db.soft_delete"} {
+			if value := sqlSpan.Tag(tag); value != nil {
+				t.Errorf("sql span tag '%s' should be empty but it's '%v'", tag, value)
+			}
+		}
+	}
+}
+
+func TestTagsFunc(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithTagsFunc(func(scope *gorm.Scope) map[string]interface{} {
+		return map[string]interface{}{"app.model": scope.GetModelStruct().ModelType.Name()}
+	})))
+	db.Find(&[]Product{})
+	span.Finish()
+
+	if model := tracer.FinishedSpans()[0].Tag("app.model"); model != "Product" {
+		t.Errorf("sql span tag 'app.model' should be 'Product' but it's '%v'", model)
+	}
+}
+
+func TestExplainAnalyze(t *testing.T) {
+	for name, opts := range map[string][]otgorm.Option{
+		"sync":  nil,
+		"async": {otgorm.WithAsyncFinish(1, 10)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tracer.Reset()
+			// sqlite reports plan with EXPLAIN QUERY PLAN
+			db := newDB(t, append(opts, otgorm.WithExplainAnalyze(1, "products"))...)
+			tdb, span := tracedDB(db)
+			tdb.Where("code = ?", "L1212").Find(&[]Product{})
+			otgorm.Flush(db)
+			span.Finish()
+
+			logs := tracer.FinishedSpans()[0].Logs()
+			if len(logs) != 1 || len(logs[0].Fields) != 2 {
+				t.Fatalf("sql span should have explain log but it has %v", logs)
+			}
+			if plan := logs[0].Fields[1].ValueString; !strings.Contains(plan, "products") {
+				t.Errorf("plan should mention products table but it's %q", plan)
+			}
+		})
+	}
+}
+
+func TestTableCapture(t *testing.T) {
+	db, span := tracedDB(newDB(t,
+		otgorm.WithTableCapture(otgorm.CaptureNone, "users"),
+		otgorm.WithTableCapture(otgorm.CapturePlaceholders, "products"),
+	))
+	db.Where("code = ?", "L1212").Find(&[]Product{})
+	db.Joins("JOIN users ON users.id = products.id").Find(&[]Product{})
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if statement := spans[0].Tag("db.statement"); statement != `SELECT * FROM "products"  WHERE "products"."deleted_at" IS NULL AND ((code = ?))` {
+		t.Errorf("sql span tag 'db.statement' should have placeholders but it's '%v'", statement)
+	}
+	if statement := spans[1].Tag("db.statement"); statement != nil {
+		t.Errorf("sql span tag 'db.statement' should be empty but it's '%v'", statement)
+	}
+}
+
+func TestUpdateConfig(t *testing.T) {
+	db := newDB(t)
+	tdb, span := tracedDB(db)
+	tdb.Find(&[]Product{})
+	if db.Callback().Query().Get("tracing:query_operation_before") != nil {
+		t.Errorf("operation callbacks shouldn't be registered until operation spans are enabled")
+	}
+	if err := otgorm.UpdateConfig(db, otgorm.WithTags(otgorm.AllTags&^otgorm.TagStatement), otgorm.WithOperationSpans()); err != nil {
+		t.Fatal(err)
+	}
+	tdb.Find(&[]Product{})
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 4 {
+		t.Fatalf("should be 4 finished spans but there are %d: %v", len(spans), spans)
+	}
+	if statement := spans[0].Tag("db.statement"); statement == nil {
+		t.Errorf("sql span tag 'db.statement' should be set before config update")
+	}
+	if statement := spans[1].Tag("db.statement"); statement != nil {
+		t.Errorf("sql span tag 'db.statement' should be empty after config update but it's '%v'", statement)
+	}
+	if spans[2].OperationName != "gorm:query" {
+		t.Errorf("operation span should be started after config update but it's '%s'", spans[2].OperationName)
+	}
+
+	plain, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := otgorm.UpdateConfig(plain); err == nil {
+		t.Errorf("config update of db without callbacks should fail")
+	}
+}
+
+func TestDebugHandler(t *testing.T) {
+	db := newDB(t, otgorm.WithSlowThreshold(time.Second))
+	db.Find(&[]Product{})
+	tdb, span := tracedDB(db)
+	tdb.Find(&[]Product{})
+	tdb.Find(&[]Product{})
+	span.Finish()
+
+	rec := httptest.NewRecorder()
+	otgorm.DebugHandler(db).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/otgorm", nil))
+	var status struct {
+		Callbacks map[string]bool
+		Config    map[string]interface{}
+		Spans     map[string]int64
+		Untraced  int64
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("response should be json but it's %q: %v", rec.Body.String(), err)
+	}
+	if !status.Callbacks["query/tracing:query_before"] || !status.Callbacks["row_query/tracing:row_query_after"] {
+		t.Errorf("callbacks should be registered but they are %v", status.Callbacks)
+	}
+	if status.Config["slow_threshold"] != "1s" {
+		t.Errorf("config should have slow_threshold 1s but it's %v", status.Config)
+	}
+	if status.Spans["products"] != 2 {
+		t.Errorf("there should be 2 spans of products but there are %v", status.Spans)
+	}
+	if status.Untraced != 1 {
+		t.Errorf("there should be 1 untraced query but there are %d", status.Untraced)
+	}
+
+	rec = httptest.NewRecorder()
+	plain, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otgorm.DebugHandler(plain).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/otgorm", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status of db without callbacks should be 404 but it's %d", rec.Code)
+	}
+}
+
+func TestOverhead(t *testing.T) {
+	db := newDB(t, otgorm.WithOverheadTag())
+	tdb, span := tracedDB(db)
+	tdb.Where("code = ?", "L1212").Find(&[]Product{})
+	span.Finish()
+
+	overhead, ok := tracer.FinishedSpans()[0].Tag("otgorm.overhead_us").(float64)
+	if !ok || overhead <= 0 {
+		t.Errorf("sql span should have positive otgorm.overhead_us tag but it's %v", tracer.FinishedSpans()[0].Tag("otgorm.overhead_us"))
+	}
+	if total, spans := otgorm.Overhead(db); total <= 0 || spans != 1 {
+		t.Errorf("overhead should be positive for 1 span but it's %v for %d spans", total, spans)
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	db := newDB(t, otgorm.WithTagsFunc(func(scope *gorm.Scope) map[string]interface{} {
+		panic("unexpected type")
+	}))
+	tdb, span := tracedDB(db)
+	var products []Product
+	if err := tdb.Find(&products).Error; err != nil {
+		t.Fatalf("query should succeed but it failed: %v", err)
+	}
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 2 {
+		t.Fatalf("should be 2 finished spans but there are %d: %v", len(spans), spans)
+	}
+	if spans[0].Tag("otgorm.panic") != true {
+		t.Errorf("sql span should be tagged with otgorm.panic")
+	}
+	if logs := spans[0].Logs(); len(logs) != 1 || logs[0].Fields[1].ValueString != "unexpected type" {
+		t.Errorf("sql span should have panic log but it has %v", logs)
+	}
+}
+
+func TestSettingsKeyCollision(t *testing.T) {
+	db := newDB(t)
+	// another library uses the same key for something else
+	var products []Product
+	if err := db.Set(otgorm.ParentSpanGormKey, "not a span").Find(&products).Error; err != nil {
+		t.Fatalf("query should succeed but it failed: %v", err)
+	}
+	if spans := tracer.FinishedSpans(); len(spans) != 0 {
+		t.Errorf("should be 0 finished spans but there are %d: %v", len(spans), spans)
+	}
+
+	defer func(key string) { otgorm.ParentSpanGormKey = key }(otgorm.ParentSpanGormKey)
+	otgorm.ParentSpanGormKey = "otgormParentSpan"
+	tdb, span := tracedDB(db)
+	tdb.Set("opentracingParentSpan", "not a span").Find(&products)
+	span.Finish()
+	if spans := tracer.FinishedSpans(); len(spans) != 2 {
+		t.Errorf("should be 2 finished spans but there are %d: %v", len(spans), spans)
+	}
+}
+
+func TestSetSpanContextToGorm(t *testing.T) {
+	db := newDB(t)
+	span := tracer.StartSpan("producer")
+	span.Finish()
+
+	otgorm.SetSpanContextToGorm(span.Context(), db).Find(&[]Product{})
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 2 {
+		t.Fatalf("should be 2 finished spans but there are %d: %v", len(spans), spans)
+	}
+	parent := span.Context().(mocktracer.MockSpanContext)
+	if spans[1].OperationName != "sql" || spans[1].ParentID != parent.SpanID || spans[1].SpanContext.TraceID != parent.TraceID {
+		t.Errorf("sql span should be a child of span context but it's %v", spans[1])
+	}
+}
+
+func TestSpanToGormFromCarrier(t *testing.T) {
+	db := newDB(t)
+	span := tracer.StartSpan("producer")
+	span.Finish()
+	headers := opentracing.TextMapCarrier{}
+	if err := tracer.Inject(span.Context(), opentracing.TextMap, headers); err != nil {
+		t.Fatal(err)
+	}
+
+	tdb, err := otgorm.SpanToGormFromCarrier(tracer, headers, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tdb.Find(&[]Product{})
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 2 {
+		t.Fatalf("should be 2 finished spans but there are %d: %v", len(spans), spans)
+	}
+	parent := span.Context().(mocktracer.MockSpanContext)
+	if spans[1].ParentID != parent.SpanID || spans[1].SpanContext.TraceID != parent.TraceID {
+		t.Errorf("sql span should continue the trace of the carrier but it's %v", spans[1])
+	}
+
+	if _, err := otgorm.SpanToGormFromCarrier(nil, opentracing.TextMapCarrier{}, db); err != opentracing.ErrSpanContextNotFound {
+		t.Errorf("empty carrier should return ErrSpanContextNotFound but it's %v", err)
+	}
+}
+
+func TestErrorLog(t *testing.T) {
+	db, span := tracedDB(newDB(t))
+	db.Table("missing").Find(&[]Product{})
+	span.Finish()
+
+	sqlSpan := tracer.FinishedSpans()[0]
+	if err := sqlSpan.Tag("db.err"); err == nil {
+		t.Errorf("sql span tag 'db.err' should be set for compatibility")
+	}
+	logs := sqlSpan.Logs()
+	if len(logs) != 1 || len(logs[0].Fields) != 4 {
+		t.Fatalf("sql span should have error log but it has %v", logs)
+	}
+	fields := map[string]string{}
+	for _, f := range logs[0].Fields {
+		fields[f.Key] = f.ValueString
+	}
+	if fields["event"] != "error" || fields["error.kind"] != "sqlite3.Error" || !strings.Contains(fields["message"], "no such table") {
+		t.Errorf("error log should have standard fields but it's %v", fields)
+	}
+	if _, ok := fields["error.object"]; !ok {
+		t.Errorf("error log should have error.object field but it's %v", fields)
+	}
+
+	db, span = tracedDB(newDB(t, otgorm.WithTags(otgorm.AllTags&^otgorm.TagErr)))
+	db.Table("missing").Find(&[]Product{})
+	span.Finish()
+	if err := tracer.FinishedSpans()[0].Tag("db.err"); err != nil {
+		t.Errorf("sql span tag 'db.err' should be empty but it's '%v'", err)
+	}
+}
+
+func TestSampledStatement(t *testing.T) {
+	sampled := func(sc opentracing.SpanContext) bool {
+		return sc.(mocktracer.MockSpanContext).Sampled
+	}
+	db, span := tracedDB(newDB(t, otgorm.WithSampledStatement(sampled)))
+	ext.SamplingPriority.Set(span, 0)
+	db.Find(&[]Product{})
+	span.Finish()
+
+	sqlSpan := tracer.FinishedSpans()[0]
+	if statement := sqlSpan.Tag("db.statement"); statement != nil {
+		t.Errorf("sql span tag 'db.statement' should be empty for not sampled span but it's '%v'", statement)
+	}
+	if table := sqlSpan.Tag("db.table"); table != "products" {
+		t.Errorf("sql span tag 'db.table' should be products but it's '%v'", table)
+	}
+
+	db, span = tracedDB(newDB(t, otgorm.WithSampledStatement(sampled)))
+	db.Find(&[]Product{})
+	span.Finish()
+	if statement := tracer.FinishedSpans()[0].Tag("db.statement"); statement == nil {
+		t.Errorf("sql span tag 'db.statement' should be set for sampled span")
+	}
+}
+
+func TestRows(t *testing.T) {
+	db := newDB(t)
+	db.Create(&Product{Code: "L1"})
+	db.Create(&Product{Code: "L2"})
+	tracer.Reset()
+
+	tdb, span := tracedDB(db)
+	rows, err := otgorm.Rows(tdb.Model(&Product{}).Select("code"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var codes []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			t.Fatal(err)
+		}
+		codes = append(codes, code)
+	}
+	if spans := tracer.FinishedSpans(); len(spans) != 0 {
+		t.Fatalf("spans shouldn't be finished before rows are closed but there are %v", spans)
+	}
+	closed := time.Now()
+	rows.Close()
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 3 {
+		t.Fatalf("should be 3 finished spans but there are %d: %v", len(spans), spans)
+	}
+	if count := spans[0].Tag("db.count"); count != int64(2) {
+		t.Errorf("sql span of rows should have tag 'db.count' 2 but it's '%v'", count)
+	}
+	if !spans[0].FinishTime.Before(closed) {
+		t.Errorf("sql span should finish when the query is done, not when rows are closed")
+	}
+	rowsSpan := spans[1]
+	if rowsSpan.OperationName != "gorm:rows" || spans[0].ParentID != rowsSpan.SpanContext.SpanID {
+		t.Errorf("sql span should be a child of gorm:rows span but spans are %v", spans)
+	}
+	if n := rowsSpan.Tag("db.rows"); n != int64(2) || len(codes) != 2 {
+		t.Errorf("gorm:rows span tag 'db.rows' should be 2 but it's '%v'", n)
+	}
+	if _, ok := rowsSpan.Tag("db.scan_ms").(float64); !ok {
+		t.Errorf("gorm:rows span should have tag 'db.scan_ms'")
+	}
+}
+
+func TestScanSpans(t *testing.T) {
+	db := newDB(t, otgorm.WithScanSpans())
+	db.Create(&Product{Code: "L1"})
+	db.Create(&Product{Code: "L2"})
+	tracer.Reset()
+
+	tdb, span := tracedDB(db)
+	rows, err := otgorm.Rows(tdb.Model(&Product{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var product Product
+		if err := rows.ScanRows(db, &product); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows.Close()
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 4 {
+		t.Fatalf("should be 4 finished spans but there are %d: %v", len(spans), spans)
+	}
+	scanSpan, rowsSpan := spans[1], spans[2]
+	if scanSpan.OperationName != "gorm:scan" || scanSpan.ParentID != rowsSpan.SpanContext.SpanID {
+		t.Errorf("gorm:scan span should be a child of gorm:rows span but spans are %v", spans)
+	}
+	if n := scanSpan.Tag("db.rows"); n != int64(2) {
+		t.Errorf("gorm:scan span tag 'db.rows' should be 2 but it's '%v'", n)
+	}
+}
+
+func TestWithTracer(t *testing.T) {
+	dbTracer := mocktracer.New()
+	db, span := tracedDB(newDB(t, otgorm.WithTracer(dbTracer)))
+	db.Find(&[]Product{})
+	span.Finish()
+
+	if spans := tracer.FinishedSpans(); len(spans) != 1 {
+		t.Errorf("only parent span should be reported by global tracer but there are %v", spans)
+	}
+	spans := dbTracer.FinishedSpans()
+	if len(spans) != 1 {
+		t.Fatalf("should be 1 span reported by db tracer but there are %d: %v", len(spans), spans)
+	}
+	if spans[0].OperationName != "sql" || spans[0].ParentID != span.Context().(mocktracer.MockSpanContext).SpanID {
+		t.Errorf("sql span should be a child of parent span but it's %v", spans[0])
+	}
+}
+
+func TestWithGlobalTracer(t *testing.T) {
+	// parent span is started by another tracer, e.g. a bridge
+	bridge := mocktracer.New()
+	db := newDB(t, otgorm.WithGlobalTracer())
+	span := bridge.StartSpan("bridge")
+	db = otgorm.SetSpanToGorm(opentracing.ContextWithSpan(context.Background(), span), db)
+	db.Find(&[]Product{})
+	span.Finish()
+
+	if spans := bridge.FinishedSpans(); len(spans) != 1 {
+		t.Errorf("only parent span should be reported by bridge tracer but there are %v", spans)
+	}
+	spans := tracer.FinishedSpans()
+	if len(spans) != 1 || spans[0].OperationName != "sql" {
+		t.Fatalf("sql span should be reported by global tracer but there are %v", spans)
+	}
+	if spans[0].ParentID != span.Context().(mocktracer.MockSpanContext).SpanID {
+		t.Errorf("sql span should be a child of parent span but it's %v", spans[0])
+	}
+}
+
+func TestSettingsTags(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithSettingsTags("feature", "request_id")))
+	db.Set("feature", "checkout").Find(&[]Product{})
+	span.Finish()
+
+	sqlSpan := tracer.FinishedSpans()[0]
+	if feature := sqlSpan.Tag("feature"); feature != "checkout" {
+		t.Errorf("sql span tag 'feature' should be checkout but it's '%v'", feature)
+	}
+	if _, ok := sqlSpan.Tags()["request_id"]; ok {
+		t.Errorf("sql span tag 'request_id' should be empty")
+	}
+}
+
+func TestInstanceID(t *testing.T) {
+	db := newDB(t, otgorm.WithInstanceID())
+	tdb, span := tracedDB(db)
+	tdb.Find(&[]Product{})
+	span.Finish()
+
+	instance, ok := tracer.FinishedSpans()[0].Tag("db.instance").(string)
+	if !ok || instance == "main" || instance == "" {
+		t.Errorf("sql span tag 'db.instance' should be gorm instance id but it's '%v'", instance)
+	}
+}
+
+func TestTagSanitizer(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithTagSanitizer(func(key string, value interface{}) (interface{}, bool) {
+		switch key {
+		case "db.statement":
+			return "redacted", true
+		case "db.count":
+			return nil, false
+		}
+		return value, true
+	})))
+	db.Find(&[]Product{})
+	span.Finish()
+
+	sqlSpan := tracer.FinishedSpans()[0]
+	if statement := sqlSpan.Tag("db.statement"); statement != "redacted" {
+		t.Errorf("sql span tag 'db.statement' should be sanitized but it's '%v'", statement)
+	}
+	if _, ok := sqlSpan.Tags()["db.count"]; ok {
+		t.Errorf("sql span tag 'db.count' should be dropped")
+	}
+	if table := sqlSpan.Tag("db.table"); table != "products" {
+		t.Errorf("sql span tag 'db.table' should be products but it's '%v'", table)
+	}
+}
+
+func TestOperationTableNames(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithOperationTableNames()))
+	db.Find(&[]Product{})
+	db.Create(&Product{Code: "L1"})
+	db.Raw("SELECT 1").Row()
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 4 {
+		t.Fatalf("should be 4 finished spans but there are %d: %v", len(spans), spans)
+	}
+	for i, name := range []string{"SELECT products", "INSERT products", "SELECT"} {
+		if spans[i].OperationName != name {
+			t.Errorf("span operation should be '%s' but it's '%s'", name, spans[i].OperationName)
+		}
+	}
+}
+
+func TestMultiStatement(t *testing.T) {
+	db, span := tracedDB(newDB(t))
+	db.Raw("UPDATE products SET code = 'a;b'; DELETE FROM products").Row()
+	span.Finish()
+
+	sqlSpan := tracer.FinishedSpans()[0]
+	if method := sqlSpan.Tag("db.method"); method != "MULTI" {
+		t.Errorf("sql span tag 'db.method' should be MULTI but it's '%v'", method)
+	}
+	if count := sqlSpan.Tag("db.statement_count"); count != 2 || sqlSpan.Tag("db.multi_statement") != true {
+		t.Errorf("sql span should be tagged as multi statement with 2 statements but it's '%v'", count)
+	}
+	logs := sqlSpan.Logs()
+	if len(logs) != 2 || logs[0].Fields[2].ValueString != "UPDATE" || logs[1].Fields[2].ValueString != "DELETE" {
+		t.Errorf("sql span should have log per statement but it has %v", logs)
+	}
+}
+
+type actorKey struct{}
+
+// mockTraceID reads trace ids of mocktracer span contexts, which have TraceID field instead of method
+var mockTraceID = otgorm.WithTraceIDFunc(func(sc opentracing.SpanContext) string {
+	return fmt.Sprint(sc.(mocktracer.MockSpanContext).TraceID)
+})
+
+func TestAuditSink(t *testing.T) {
+	var events []otgorm.AuditEvent
+	db := newDB(t, otgorm.WithAuditSink(func(e otgorm.AuditEvent) {
+		events = append(events, e)
+	}, func(ctx context.Context) string {
+		actor, _ := ctx.Value(actorKey{}).(string)
+		return actor
+	}), mockTraceID)
+	span := tracer.StartSpan("test")
+	ctx := context.WithValue(opentracing.ContextWithSpan(context.Background(), span), actorKey{}, "alice")
+	tdb := otgorm.SetSpanToGorm(ctx, db)
+	tdb.Create(&Product{Code: "L1"})
+	tdb.Find(&[]Product{})
+	db.Model(&Product{}).Where("code = ?", "L1").Update("code", "L2")
+	span.Finish()
+
+	if len(events) != 2 {
+		t.Fatalf("should be 2 audit events but there are %d: %v", len(events), events)
+	}
+	created := events[0]
+	if created.Operation != "INSERT" || created.Table != "products" || created.Actor != "alice" || created.RowsAffected != 1 {
+		t.Errorf("insert audit event is wrong: %+v", created)
+	}
+	if traceID := fmt.Sprint(span.Context().(mocktracer.MockSpanContext).TraceID); created.TraceID != traceID {
+		t.Errorf("insert audit event trace id should be %s but it's %s", traceID, created.TraceID)
+	}
+	if updated := events[1]; updated.Operation != "UPDATE" || updated.Actor != "" || updated.TraceID != "" {
+		t.Errorf("untraced update audit event is wrong: %+v", updated)
+	}
+}
+
+func TestAuditSinkNoValues(t *testing.T) {
+	var events []otgorm.AuditEvent
+	db := newDB(t, otgorm.WithNoValues(), otgorm.WithAuditSink(func(e otgorm.AuditEvent) {
+		events = append(events, e)
+	}, nil))
+	db.Callback().Create().Before("gorm:create").Register("test:duplicate", func(scope *gorm.Scope) {
+		scope.Err(errors.New("Duplicate entry 'john@example.com' for key 'email'"))
+	})
+	db.Create(&Product{Code: "L1"})
+
+	if len(events) != 1 || events[0].Err == nil || events[0].Err.Error() != "Duplicate entry '?' for key '?'" {
+		t.Errorf("audit event error should be redacted: %+v", events)
+	}
+}
+
+func TestUpdateCapture(t *testing.T) {
+	db := newDB(t, otgorm.WithUpdateCapture(true, "products"))
+	db.Create(&Product{Code: "L1"})
+	tdb, span := tracedDB(db)
+	tdb.Model(&Product{}).Where("code = ?", "L1").Update("code", "L2")
+	span.Finish()
+
+	logs := tracer.FinishedSpans()[0].Logs()
+	if len(logs) != 1 {
+		t.Fatalf("update span should have update log but it has %v", logs)
+	}
+	fields := map[string]string{}
+	for _, f := range logs[0].Fields {
+		fields[f.Key] = f.ValueString
+	}
+	if fields["db.columns"] != "code,updated_at" || fields["db.value.code"] != "'L2'" {
+		t.Errorf("update log should have columns and values but it's %v", fields)
+	}
+}
+
+func TestUpdatedColumns(t *testing.T) {
+	db := newDB(t)
+	product := Product{Code: "L1"}
+	db.Create(&product)
+	tdb, span := tracedDB(db)
+	tdb.Model(&product).Updates(map[string]interface{}{"code": "L2"})
+	product.Code = "L3"
+	tdb.Save(&product)
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if columns := spans[0].Tag("db.updated_columns"); columns != "code,updated_at" {
+		t.Errorf("sql span tag 'db.updated_columns' of Updates should be code,updated_at but it's '%v'", columns)
+	}
+	if columns := spans[1].Tag("db.updated_columns"); columns != "created_at,updated_at,deleted_at,code" {
+		t.Errorf("sql span tag 'db.updated_columns' of Save should be all columns but it's '%v'", columns)
+	}
+}
+
+func TestModelTag(t *testing.T) {
+	db, span := tracedDB(newDB(t))
+	db.Find(&[]*Product{})
+	db.Model(&Product{}).Count(new(int))
+	db.Exec("DELETE FROM products")
+	db.Raw("SELECT 1").Scan(&struct{ N int }{})
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	for i, expected := range []interface{}{"Product", "Product", nil} {
+		if model := spans[i].Tag("db.model"); model != expected {
+			t.Errorf("span %d tag 'db.model' should be '%v' but it's '%v'", i, expected, model)
+		}
+	}
+}
+
+func TestCountPluck(t *testing.T) {
+	db := newDB(t)
+	db.Create(&Product{Code: "L1"})
+	db.Create(&Product{Code: "L2"})
+	tdb, span := tracedDB(db)
+	var count int
+	var codes []string
+	otgorm.Count(tdb.Model(&Product{}), &count)
+	otgorm.Pluck(tdb.Model(&Product{}), "code", &codes)
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 5 || spans[1].OperationName != "gorm:count" || spans[3].OperationName != "gorm:pluck" {
+		t.Fatalf("should be sql, gorm:count, sql, gorm:pluck and test spans but there are %v", spans)
+	}
+	if result := spans[1].Tag("db.result"); result != 2 || count != 2 {
+		t.Errorf("gorm:count span tag 'db.result' should be 2 but it's '%v'", result)
+	}
+	if rows := spans[3].Tag("db.rows"); rows != 2 || len(codes) != 2 {
+		t.Errorf("gorm:pluck span tag 'db.rows' should be 2 but it's '%v'", rows)
+	}
+	if table := spans[3].Tag("db.table"); table != "products" {
+		t.Errorf("gorm:pluck span tag 'db.table' should be products but it's '%v'", table)
+	}
+}
+
+func TestRowsReturned(t *testing.T) {
+	db := newDB(t)
+	db.Create(&Product{Code: "L1"})
+	db.Create(&Product{Code: "L2"})
+	tdb, span := tracedDB(db)
+	tdb.Find(&[]Product{})
+	tdb.Where("code = ?", "L3").Find(&[]Product{})
+	tdb.First(&Product{})
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	for i, expected := range []interface{}{2, 0, nil} {
+		if rows := spans[i].Tag("db.rows_returned"); rows != expected {
+			t.Errorf("span %d tag 'db.rows_returned' should be '%v' but it's '%v'", i, expected, rows)
+		}
+	}
+}
+
+func TestFailedStatement(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithFailedStatement()))
+	db.Find(&[]Product{})
+	db.Table("missing").Find(&[]Product{})
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if statement := spans[0].Tag("db.statement"); statement != nil {
+		t.Errorf("successful query shouldn't have tag 'db.statement' but it's '%v'", statement)
+	}
+	if statement := spans[1].Tag("db.statement"); statement != `SELECT * FROM "missing"  WHERE "missing"."deleted_at" IS NULL` {
+		t.Errorf("failed query should have tag 'db.statement' but it's '%v'", statement)
+	}
+}
+
+func TestOversizedStatement(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithOversizedStatement(50)))
+	db.Create(&Product{Code: "L1"})
+	db.Raw("SELECT 1").Scan(&struct{ N int }{})
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	statement, _ := spans[0].Tag("db.statement").(string)
+	if oversized := spans[0].Tag("db.statement.oversized"); oversized != true {
+		t.Errorf("long statement should have tag 'db.statement.oversized' but it's '%v'", oversized)
+	}
+	if size := spans[0].Tag("db.statement.bytes"); size != len(statement) {
+		t.Errorf("span tag 'db.statement.bytes' should be %d but it's '%v'", len(statement), size)
+	}
+	if oversized := spans[1].Tag("db.statement.oversized"); oversized != nil {
+		t.Errorf("short statement shouldn't have tag 'db.statement.oversized' but it's '%v'", oversized)
+	}
+}
+
+func TestQueryID(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithQueryID()))
+	db.Set("gorm:insert_option", "ON CONFLICT DO NOTHING").Create(&Product{Code: "L1"})
+	db.Find(&[]Product{})
+	db.Model(&Product{}).Where("code = ?", "L1").Update("code", "L2")
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	for i, sp := range spans[:3] {
+		id, _ := sp.Tag("db.query_id").(string)
+		statement, _ := sp.Tag("db.statement").(string)
+		if len(id) != 16 || strings.Count(statement, "/* query_id="+id+" */") != 1 {
+			t.Errorf("span %d statement should have comment with query id '%s' but it's '%s'", i, id, statement)
+		}
+	}
+	if statement := spans[0].Tag("db.statement").(string); !strings.Contains(statement, "ON CONFLICT DO NOTHING /* query_id=") {
+		t.Errorf("query id should follow insert option but statement is '%s'", statement)
+	}
+	if statement := spans[1].Tag("db.statement").(string); !strings.HasPrefix(statement, "/* query_id=") {
+		t.Errorf("query should be prefixed with query id but statement is '%s'", statement)
+	}
+}
+
+func TestSlowQuerySink(t *testing.T) {
+	var digests []otgorm.SlowQuery
+	db, span := tracedDB(newDB(t, otgorm.WithSlowThreshold(time.Nanosecond), otgorm.WithSlowQuerySink(func(q otgorm.SlowQuery) {
+		digests = append(digests, q)
+	}), mockTraceID))
+	db.Where("code = ?", "L1").Find(&[]Product{})
+	db.Where("code = ?", "L2").Find(&[]Product{})
+	span.Finish()
+
+	if len(digests) != 2 {
+		t.Fatalf("should be 2 slow query digests but there are %d", len(digests))
+	}
+	q := digests[0]
+	if q.Statement != `SELECT * FROM "products" WHERE "products"."deleted_at" IS NULL AND ((code = ?))` {
+		t.Errorf("slow query statement should have placeholders but it's '%s'", q.Statement)
+	}
+	if q.Table != "products" || q.Duration <= 0 || q.TraceID == "" {
+		t.Errorf("slow query digest is wrong: %+v", q)
+	}
+	if q.Fingerprint == "" || q.Fingerprint != digests[1].Fingerprint {
+		t.Errorf("fingerprints of the same statement should match but they are '%s' and '%s'", q.Fingerprint, digests[1].Fingerprint)
+	}
+}
+
+func TestPoolName(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithPoolName("primary-rw")))
+	db.Find(&[]Product{})
+	span.Finish()
+
+	if pool := tracer.FinishedSpans()[0].Tag("db.pool.name"); pool != "primary-rw" {
+		t.Errorf("sql span tag 'db.pool.name' should be primary-rw but it's '%v'", pool)
+	}
+}
+
+func TestShardTagger(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithShardTagger(func(scope *gorm.Scope) (string, bool) {
+		name := scope.TableName()
+		if i := strings.LastIndexByte(name, '_'); i >= 0 {
+			return name[i+1:], true
+		}
+		return "", false
+	})))
+	db.Find(&[]Product{})
+	db.Table("products_eu").Find(&[]Product{})
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	for i, expected := range []interface{}{nil, "eu"} {
+		if shard := spans[i].Tag("db.shard"); shard != expected {
+			t.Errorf("span %d tag 'db.shard' should be '%v' but it's '%v'", i, expected, shard)
+		}
+	}
+}
+
+func TestSchemaResolver(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithSchemaResolver(otgorm.SchemaSetting("tenant_schema"))))
+	db.Find(&[]Product{})
+	db.Set("tenant_schema", "tenant_42").Find(&[]Product{})
+	db.Table("main.products").Find(&[]Product{})
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	for i, expected := range []interface{}{nil, "tenant_42", "main"} {
+		if schema := spans[i].Tag("db.schema"); schema != expected {
+			t.Errorf("span %d tag 'db.schema' should be '%v' but it's '%v'", i, expected, schema)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	db := newDB(t)
+	if report := otgorm.Verify(db, true); !report.OK() {
+		t.Errorf("instrumentation should be verified but report is:\n%s", report)
+	}
+	tracer.Reset()
+
+	db.Callback().Query().Remove("tracing:query_before")
+	report := otgorm.Verify(db, false)
+	var failed []string
+	for _, check := range report.Checks {
+		if !check.OK {
+			failed = append(failed, check.Name)
+		}
+	}
+	if strings.Join(failed, ",") != "callbacks" {
+		t.Errorf("callbacks check should fail but report is:\n%s", report)
+	}
+
+	db.Callback().RowQuery().Remove("gorm:row_query")
+	report = otgorm.Verify(db, false)
+	failed = nil
+	for _, check := range report.Checks {
+		if !check.OK {
+			failed = append(failed, check.Name)
+		}
+	}
+	if strings.Join(failed, ",") != "callbacks,gorm/row_query" {
+		t.Errorf("callbacks and gorm/row_query checks should fail but report is:\n%s", report)
+	}
+
+	plain, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	if report := otgorm.Verify(plain, true); report.OK() || len(report.Checks) != 1 {
+		t.Errorf("db without callbacks should fail single check but report is:\n%s", report)
+	}
+}
+
+func TestTraceRecorder(t *testing.T) {
+	db := newDB(t, otgorm.WithTraceRecorder(1, 2), mockTraceID)
+	tdb, span := tracedDB(db)
+	tdb.Create(&Product{Code: "L1"})
+	tdb.Where("code = ?", "L1").Find(&[]Product{})
+	tdb.Find(&[]Product{})
+	span.Finish()
+	traceID := fmt.Sprint(span.Context().(mocktracer.MockSpanContext).TraceID)
+
+	statements := otgorm.RecordedStatements(db, traceID)
+	if len(statements) != 2 {
+		t.Fatalf("should be 2 recorded statements but there are %d: %v", len(statements), statements)
+	}
+	if s := statements[1]; s.Statement != `SELECT * FROM "products"  WHERE "products"."deleted_at" IS NULL AND ((code = 'L1'))` || s.Table != "products" {
+		t.Errorf("recorded statement is wrong: %+v", s)
+	}
+
+	rec := httptest.NewRecorder()
+	otgorm.DebugHandler(db).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/otgorm?trace="+traceID, nil))
+	var reported []otgorm.RecordedStatement
+	if err := json.Unmarshal(rec.Body.Bytes(), &reported); err != nil || len(reported) != 2 {
+		t.Errorf("debug handler should report 2 statements of the trace but it reported %s", rec.Body.String())
+	}
+
+	tdb, span = tracedDB(db)
+	tdb.Find(&[]Product{})
+	span.Finish()
+	if statements := otgorm.RecordedStatements(db, traceID); len(statements) != 0 {
+		t.Errorf("statements of the oldest trace should be forgotten but there are %v", statements)
+	}
+}
+
+func TestParentTotals(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithParentTotals(2, 0)))
+	for i := 0; i < 3; i++ {
+		db.Find(&[]Product{})
+	}
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	parent := spans[len(spans)-1]
+	if count := parent.Tag("db.query_count"); count != int64(3) {
+		t.Errorf("parent span tag 'db.query_count' should be 3 but it's '%v'", count)
+	}
+	if total, ok := parent.Tag("db.total_ms").(float64); !ok || total <= 0 {
+		t.Errorf("parent span should have tag 'db.total_ms' but it's '%v'", parent.Tag("db.total_ms"))
+	}
+	if logs := parent.Logs(); len(logs) != 1 || logs[0].Fields[0].ValueString != "db budget exceeded" {
+		t.Errorf("parent span should have single budget log but it has %v", logs)
+	}
+}
+
+func TestRuntimeTrace(t *testing.T) {
+	var buf bytes.Buffer
+	if err := trace.Start(&buf); err != nil {
+		t.Skipf("execution trace is already collected: %v", err)
+	}
+	db, span := tracedDB(newDB(t, otgorm.WithRuntimeTrace()))
+	db.Find(&[]Product{})
+	span.Finish()
+	trace.Stop()
+
+	if !bytes.Contains(buf.Bytes(), []byte("SELECT products")) {
+		t.Error("execution trace should have region 'SELECT products'")
+	}
+}
+
+func TestPprofLabels(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithPprofLabels()))
+	var profile bytes.Buffer
+	db.Callback().Query().Before("gorm:query").Register("test:labels", func(scope *gorm.Scope) {
+		// goroutine labels can't be read directly, goroutine profile lists them
+		pprof.Lookup("goroutine").WriteTo(&profile, 1)
+	})
+	db.Find(&[]Product{})
+	span.Finish()
+
+	if !strings.Contains(profile.String(), `"db.op":"SELECT"`) || !strings.Contains(profile.String(), `"db.table":"products"`) {
+		t.Errorf("query should be labeled with 'db.table' and 'db.op' but profile is:\n%s", profile.String())
+	}
+	profile.Reset()
+	pprof.Lookup("goroutine").WriteTo(&profile, 1)
+	if strings.Contains(profile.String(), `"db.op"`) {
+		t.Error("labels should be restored after the query")
+	}
+}
+
+// countingTracer counts spans started by the callbacks
+type countingTracer struct {
+	*mocktracer.MockTracer
+	started int32
+}
+
+func (t *countingTracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	atomic.AddInt32(&t.started, 1)
+	return t.MockTracer.StartSpan(operationName, opts...)
+}
+
+func TestRepeatCompression(t *testing.T) {
+	counting := &countingTracer{MockTracer: tracer}
+	db, span := tracedDB(newDB(t, otgorm.WithRepeatCompression(time.Hour), otgorm.WithTracer(counting)))
+	for i := 0; i < 5; i++ {
+		db.Create(&Product{Code: fmt.Sprintf("R%d", i)})
+	}
+	db.Find(&[]Product{})
+	for i := 0; i < 3; i++ {
+		db.Delete(&Product{}, "code = ?", fmt.Sprintf("R%d", i))
+	}
+	otgorm.Flush(db)
+	span.Finish()
+
+	var names []string
+	var counts []interface{}
+	for _, s := range tracer.FinishedSpans() {
+		names = append(names, s.OperationName)
+		counts = append(counts, s.Tag("db.repeat_count"))
+	}
+	if got := strings.Join(names, ","); got != "sql,sql,sql,sql,sql,test" {
+		t.Fatalf("repeated statements should be merged but spans are %v", got)
+	}
+	want := []interface{}{nil, int64(4), nil, nil, int64(2), nil}
+	for i := range want {
+		if counts[i] != want[i] {
+			t.Errorf("span %d tag 'db.repeat_count' should be %v but it's %v", i, want[i], counts[i])
+		}
+	}
+	if started := atomic.LoadInt32(&counting.started); started != 5 {
+		t.Errorf("only finished sql spans should be started but %d spans are started", started)
+	}
+
+	// repeats after the window aren't merged
+	db, span = tracedDB(newDB(t, otgorm.WithRepeatCompression(time.Nanosecond)))
+	for i := 0; i < 3; i++ {
+		db.Find(&[]Product{})
+		time.Sleep(time.Millisecond)
+	}
+	otgorm.Summarize(db)
+	span.Finish()
+	if spans := tracer.FinishedSpans(); len(spans) != 4 || spans[1].Tag("db.repeat_count") != nil {
+		t.Errorf("statements repeated after the window shouldn't be merged but spans are %v", spans)
+	}
+
+	// spans which aren't started yet aren't exposed as parents
+	plain := newDB(t, otgorm.WithRepeatCompression(time.Hour))
+	var exposed []interface{}
+	plain.Callback().Query().After("tracing:query_before").Register("test:span", func(scope *gorm.Scope) {
+		val, _ := scope.Get(otgorm.SpanGormKey)
+		exposed = append(exposed, val)
+	})
+	db, span = tracedDB(plain)
+	db.Find(&[]Product{})
+	otgorm.Summarize(db)
+	span.Finish()
+	if len(exposed) != 1 || exposed[0] != nil {
+		t.Errorf("pending span shouldn't be exposed under SpanGormKey but it's %v", exposed)
+	}
+	if spans := tracer.FinishedSpans(); len(spans) != 2 || spans[0].OperationName != "sql" {
+		t.Errorf("pending span should still be finished but spans are %v", spans)
+	}
+}
+
+func TestRequestSummary(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithRequestSummary()))
+	db.Create(&Product{Code: "S1"})
+	db.Find(&[]Product{})
+	db.Find(&[]Product{})
+	otgorm.Summarize(db)
+	otgorm.Summarize(db)
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 5 {
+		t.Fatalf("summary should be reported once, but there are %d spans", len(spans))
+	}
+	summary := spans[3]
+	if summary.OperationName != "db.summary" || summary.ParentID != spans[4].SpanContext.SpanID {
+		t.Fatalf("summary span should be a child of the parent span but it's %v", summary)
+	}
+	if count := summary.Tag("db.query_count"); count != int64(3) {
+		t.Errorf("summary span tag 'db.query_count' should be 3 but it's '%v'", count)
+	}
+	var entries []string
+	for _, l := range summary.Logs() {
+		fields := make(map[string]string)
+		for _, f := range l.Fields {
+			fields[f.Key] = f.ValueString
+		}
+		entries = append(entries, fields["db.method"]+" "+fields["db.table"])
+	}
+	sort.Strings(entries)
+	if got := strings.Join(entries, ","); got != "INSERT products,SELECT products" {
+		t.Errorf("summary should have log per table and operation but it has %v", got)
+	}
+
+	dbTracer := mocktracer.New()
+	db, span = tracedDB(newDB(t, otgorm.WithRequestSummary(), otgorm.WithTracer(dbTracer)))
+	db.Find(&[]Product{})
+	otgorm.Summarize(db)
+	span.Finish()
+	if spans := dbTracer.FinishedSpans(); len(spans) != 2 || spans[1].OperationName != "db.summary" {
+		t.Errorf("summary span should be started by the tracer of WithTracer but its spans are %v", spans)
+	}
+}
+
+func TestLateQueryCheck(t *testing.T) {
+	// mock spans export finish time as a field
+	finished := func(parent opentracing.Span) bool {
+		sp := parent.(*mocktracer.MockSpan)
+		sp.RLock()
+		defer sp.RUnlock()
+		return !sp.FinishTime.IsZero()
+	}
+	db, span := tracedDB(newDB(t, otgorm.WithLateQueryCheck(finished)))
+	db.Find(&[]Product{})
+	span.Finish()
+	db.Find(&[]Product{})
+
+	spans := tracer.FinishedSpans()
+	if late := spans[0].Tag("db.after_parent"); late != nil {
+		t.Errorf("query finished before parent shouldn't have tag 'db.after_parent' but it's '%v'", late)
+	}
+	if late := spans[2].Tag("db.after_parent"); late != true {
+		t.Errorf("query finished after parent should have tag 'db.after_parent' but it's '%v'", late)
+	}
+}
+
+func TestDetach(t *testing.T) {
+	db, span := tracedDB(newDB(t))
+	ctx, cancel := context.WithCancel(opentracing.ContextWithSpan(context.Background(), span))
+	detached, detachedSpan := otgorm.Detach(ctx, db)
+	cancel()
+	span.Finish()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer detachedSpan.Finish()
+		detached.Find(&[]Product{})
+	}()
+	<-done
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 3 {
+		t.Fatalf("there should be 3 spans but there are %d", len(spans))
+	}
+	sqlSpan, detachedMock := spans[1], spans[2]
+	if detachedMock.OperationName != "detached" || detachedMock.ParentID != spans[0].SpanContext.SpanID {
+		t.Errorf("detached span should follow from the request span but it's %v", detachedMock)
+	}
+	if sqlSpan.ParentID != detachedMock.SpanContext.SpanID {
+		t.Error("query should be a child of the detached span")
+	}
+	if cancelled := sqlSpan.Tag("db.context_cancelled"); cancelled != nil {
+		t.Errorf("detached query shouldn't be cancelled with the request but tag 'db.context_cancelled' is '%v'", cancelled)
+	}
+}
+
+func TestDetachParentStats(t *testing.T) {
+	db := newDB(t, otgorm.WithParentTotals(0, 0))
+	tdb, span := tracedDB(db)
+	tdb.Find(&[]Product{})
+	detached, detachedSpan := otgorm.Detach(nil, tdb)
+	detached.Find(&[]Product{})
+	detached.Find(&[]Product{})
+	detachedSpan.Finish()
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	detachedMock, parent := spans[len(spans)-2], spans[len(spans)-1]
+	if count := parent.Tag("db.query_count"); count != int64(1) {
+		t.Errorf("request span tag 'db.query_count' should be 1 but it's '%v'", count)
+	}
+	if count := detachedMock.Tag("db.query_count"); count != int64(2) {
+		t.Errorf("detached span tag 'db.query_count' should be 2 but it's '%v'", count)
+	}
+
+	if err := otgorm.UpdateConfig(db); err != nil {
+		t.Fatal(err)
+	}
+	detached, detachedSpan = otgorm.Detach(nil, tdb)
+	defer detachedSpan.Finish()
+	if stats, _ := detached.Get(otgorm.ParentStatsGormKey); stats != nil {
+		t.Errorf("detached db shouldn't share stats of the request span but it has %v", stats)
+	}
+}
+
+func TestCallbackProfiling(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(&Product{})
+	db.Callback().Create().Before("gorm:before_create").Register("test:validate", func(scope *gorm.Scope) {
+		time.Sleep(time.Millisecond)
+	})
+	otgorm.AddGormCallbacks(db, otgorm.WithCallbackProfiling("test:validate", "test:missing"))
+	tracer.Reset()
+
+	db.Create(&Product{Code: "U1"})
+	if len(tracer.FinishedSpans()) != 0 {
+		t.Fatal("untraced query shouldn't be traced")
+	}
+	traced, span := tracedDB(db)
+	traced.Create(&Product{Code: "P1"})
+	span.Finish()
+
+	logs := tracer.FinishedSpans()[0].Logs()
+	if len(logs) != 1 || logs[0].Fields[0].ValueString != "test:validate" {
+		t.Fatalf("sql span should have log of the callback but it has %v", logs)
+	}
+	if ms, err := strconv.ParseFloat(logs[0].Fields[1].ValueString, 64); err != nil || ms < 1 {
+		t.Errorf("callback should take at least 1ms but it's %v", logs[0].Fields[1].ValueString)
+	}
+
+	rec := httptest.NewRecorder()
+	otgorm.DebugHandler(db).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/otgorm", nil))
+	if !strings.Contains(rec.Body.String(), `callback \"test:missing\" isn't registered`) {
+		t.Errorf("unknown callback should be reported but status is %s", rec.Body.String())
+	}
+}
+
+func TestCallbackProfilingAll(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(&Product{})
+	noop := func(scope *gorm.Scope) {}
+	db.Callback().Create().Before("gorm:before_create").Register("test:validate", noop)
+	db.Callback().Create().Before("gorm:before_create").Register("test:audit", noop)
+	db.Callback().Create().Before("gorm:before_create").Register("test:removed", noop)
+	db.Callback().Create().Remove("test:removed")
+	otgorm.AddGormCallbacks(db, otgorm.WithCallbackProfiling())
+	// UpdateConfig doesn't wrap callbacks, profiling stays as set by AddGormCallbacks
+	if err := otgorm.UpdateConfig(db); err != nil {
+		t.Fatal(err)
+	}
+	tracer.Reset()
+
+	traced, span := tracedDB(db)
+	traced.Create(&Product{Code: "P1"})
+	span.Finish()
+
+	var names []string
+	for _, l := range tracer.FinishedSpans()[0].Logs() {
+		names = append(names, l.Fields[0].ValueString)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "test:audit,test:validate" {
+		t.Errorf("sql span should have logs of all registered callbacks but it has %v", names)
+	}
+}
+
+func TestDisable(t *testing.T) {
+	var enabled int32 = 1
+	db, span := tracedDB(newDB(t, otgorm.WithEnabledFunc(func() bool { return atomic.LoadInt32(&enabled) == 1 })))
+	otgorm.Disable()
+	db.Find(&[]Product{})
+	otgorm.Enable()
+	atomic.StoreInt32(&enabled, 0)
+	db.Find(&[]Product{})
+	atomic.StoreInt32(&enabled, 1)
+	db.Find(&[]Product{})
+	span.Finish()
+
+	if spans := tracer.FinishedSpans(); len(spans) != 2 {
+		t.Errorf("only the query traced while enabled should have span but there are %d spans", len(spans))
+	}
+}
+
+func TestErrorRateWatchdog(t *testing.T) {
+	var alerts []otgorm.ErrorRateAlert
+	db, span := tracedDB(newDB(t, otgorm.WithErrorRateWatchdog(time.Minute, 0.5, 2, func(alert otgorm.ErrorRateAlert) {
+		alerts = append(alerts, alert)
+	})))
+	db.Find(&[]Product{})
+	db.First(&Product{}, "code = ?", "missing")
+	db.Table("missing").Find(&[]Product{})
+	db.Table("missing").Find(&[]Product{})
+	span.Finish()
+
+	if len(alerts) != 1 || alerts[0].Table != "missing" || alerts[0].Operation != "SELECT" || alerts[0].Rate != 1 {
+		t.Errorf("failed queries of the missing table should alert once but alerts are %+v", alerts)
+	}
+}
+
+func TestNewTracedDB(t *testing.T) {
+	if _, _, err := otgorm.NewTracedDB(otgorm.Config{}, tracer); err == nil {
+		t.Error("config without dialect should be an error")
+	}
+	if _, _, err := otgorm.NewTracedDB(otgorm.Config{Dialect: "sqlite3", DSN: ":memory:", Granularity: "query"}, tracer); err == nil {
+		t.Error("invalid config should be an error")
+	}
+
+	db, cleanup, err := otgorm.NewTracedDB(otgorm.Config{Dialect: "sqlite3", DSN: ":memory:", PoolName: "primary"}, tracer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer.Reset()
+	db, span := tracedDB(db)
+	db.Exec("CREATE TABLE products (id integer)")
+	db.Find(&[]Product{})
+	span.Finish()
+	cleanup()
+
+	if pool := tracer.FinishedSpans()[0].Tag("db.pool.name"); pool != "primary" {
+		t.Errorf("span should be traced with options of the config but tag 'db.pool.name' is '%v'", pool)
+	}
+	if err := db.DB().Ping(); err == nil {
+		t.Error("cleanup should close db")
+	}
+}
+
+func TestParentTimeTags(t *testing.T) {
+	db, span := tracedDB(newDB(t, otgorm.WithParentTimeTags()))
+	db.Find(&[]Product{})
+	db.Find(&[]Product{})
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	parent := spans[len(spans)-1]
+	if calls := parent.Tag("db.calls"); calls != int64(2) {
+		t.Errorf("parent span tag 'db.calls' should be 2 but it's '%v'", calls)
+	}
+	if ms, ok := parent.Tag("db.time_ms").(float64); !ok || ms <= 0 {
+		t.Errorf("parent span should have tag 'db.time_ms' but it's '%v'", parent.Tag("db.time_ms"))
+	}
+	if total := parent.Tag("db.total_ms"); total != nil {
+		t.Errorf("parent span shouldn't have tag 'db.total_ms' without WithParentTotals but it's '%v'", total)
+	}
+}
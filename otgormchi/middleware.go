@@ -0,0 +1,21 @@
+// Package otgormchi provides chi middleware which binds request span to gorm
+package otgormchi
+
+import (
+	"net/http"
+
+	"github.com/jinzhu/gorm"
+	otgorm "github.com/smacker/opentracing-gorm"
+	"github.com/smacker/opentracing-gorm/otgormhttp"
+)
+
+// Middleware returns chi middleware which stores db traced with request span in request context,
+// handlers get it with GetDB(r). chi uses net/http middlewares, see otgormhttp.Middleware
+func Middleware(db *gorm.DB) func(http.Handler) http.Handler {
+	return otgormhttp.Middleware(db)
+}
+
+// GetDB returns db stored by Middleware, returns nil if the middleware isn't used
+func GetDB(r *http.Request) *gorm.DB {
+	return otgorm.FromContext(r.Context())
+}
@@ -0,0 +1,48 @@
+package otgormchi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	otgorm "github.com/smacker/opentracing-gorm"
+	"github.com/smacker/opentracing-gorm/otgormchi"
+)
+
+type Product struct {
+	gorm.Model
+	Code string
+}
+
+func TestMiddleware(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(&Product{})
+	otgorm.AddGormCallbacks(db)
+
+	r := chi.NewRouter()
+	r.Use(otgormchi.Middleware(db))
+	r.Get("/products", func(w http.ResponseWriter, r *http.Request) {
+		var products []Product
+		otgormchi.GetDB(r).Find(&products)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/products", nil))
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 2 {
+		t.Fatalf("should be 2 finished spans but there are %d: %v", len(spans), spans)
+	}
+	if spans[0].OperationName != "sql" || spans[0].ParentID != spans[1].SpanContext.SpanID {
+		t.Errorf("sql span should be a child of request span")
+	}
+}
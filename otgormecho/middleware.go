@@ -0,0 +1,39 @@
+// Package otgormecho provides echo middleware which binds request span to gorm
+package otgormecho
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo/v4"
+	otgorm "github.com/smacker/opentracing-gorm"
+	"github.com/smacker/opentracing-gorm/otgormhttp"
+)
+
+const dbKey = "otgorm.db"
+
+// Middleware returns echo middleware which stores db traced with request span in echo context,
+// handlers get it with GetDB(c). The db is also available with otgorm.FromContext(c.Request().Context()).
+// Span already started by previous middleware is used as is, otherwise request span is started
+// continuing the trace from request headers, see otgormhttp.StartSpan
+func Middleware(db *gorm.DB) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			ctx, finish := otgormhttp.StartSpan(req)
+			if finish != nil {
+				defer func() { finish(c.Response().Status) }()
+			}
+
+			traced := otgorm.SetSpanToGorm(ctx, db)
+			defer otgorm.Summarize(traced)
+			c.Set(dbKey, traced)
+			c.SetRequest(req.WithContext(otgorm.NewContext(ctx, traced)))
+			return next(c)
+		}
+	}
+}
+
+// GetDB returns db stored by Middleware, returns nil if the middleware isn't used
+func GetDB(c echo.Context) *gorm.DB {
+	db, _ := c.Get(dbKey).(*gorm.DB)
+	return db
+}
@@ -0,0 +1,52 @@
+package otgormecho_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/labstack/echo/v4"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	otgorm "github.com/smacker/opentracing-gorm"
+	"github.com/smacker/opentracing-gorm/otgormecho"
+)
+
+type Product struct {
+	gorm.Model
+	Code string
+}
+
+func TestMiddleware(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(&Product{})
+	otgorm.AddGormCallbacks(db)
+
+	e := echo.New()
+	e.Use(otgormecho.Middleware(db))
+	e.GET("/products", func(c echo.Context) error {
+		var products []Product
+		otgormecho.GetDB(c).Find(&products)
+		return c.NoContent(http.StatusNoContent)
+	})
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/products", nil))
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 2 {
+		t.Fatalf("should be 2 finished spans but there are %d: %v", len(spans), spans)
+	}
+	if spans[0].OperationName != "sql" || spans[0].ParentID != spans[1].SpanContext.SpanID {
+		t.Errorf("sql span should be a child of request span")
+	}
+	if status := spans[1].Tag("http.status_code"); status != uint16(http.StatusNoContent) {
+		t.Errorf("request span tag 'http.status_code' should be 204 but it's '%v'", status)
+	}
+}
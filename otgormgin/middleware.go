@@ -0,0 +1,40 @@
+// Package otgormgin provides gin middleware which binds request span to gorm
+package otgormgin
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	otgorm "github.com/smacker/opentracing-gorm"
+	"github.com/smacker/opentracing-gorm/otgormhttp"
+)
+
+const dbKey = "otgorm.db"
+
+// Middleware returns gin middleware which stores db traced with request span in gin context,
+// handlers get it with GetDB(c). The db is also available with otgorm.FromContext(c.Request.Context()).
+// Span already started by previous middleware is used as is, otherwise request span is started
+// continuing the trace from request headers, see otgormhttp.StartSpan
+func Middleware(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, finish := otgormhttp.StartSpan(c.Request)
+		if finish != nil {
+			defer func() { finish(c.Writer.Status()) }()
+		}
+
+		traced := otgorm.SetSpanToGorm(ctx, db)
+		defer otgorm.Summarize(traced)
+		c.Set(dbKey, traced)
+		c.Request = c.Request.WithContext(otgorm.NewContext(ctx, traced))
+		c.Next()
+	}
+}
+
+// GetDB returns db stored by Middleware, returns nil if the middleware isn't used
+func GetDB(c *gin.Context) *gorm.DB {
+	val, ok := c.Get(dbKey)
+	if !ok {
+		return nil
+	}
+	db, _ := val.(*gorm.DB)
+	return db
+}
@@ -0,0 +1,50 @@
+package otgormgin_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	otgorm "github.com/smacker/opentracing-gorm"
+	"github.com/smacker/opentracing-gorm/otgormgin"
+)
+
+type Product struct {
+	gorm.Model
+	Code string
+}
+
+func TestMiddleware(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(&Product{})
+	otgorm.AddGormCallbacks(db)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(otgormgin.Middleware(db))
+	r.GET("/products", func(c *gin.Context) {
+		var products []Product
+		otgormgin.GetDB(c).Find(&products)
+		c.Status(http.StatusNoContent)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/products", nil))
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 2 {
+		t.Fatalf("should be 2 finished spans but there are %d: %v", len(spans), spans)
+	}
+	if spans[0].OperationName != "sql" || spans[0].ParentID != spans[1].SpanContext.SpanID {
+		t.Errorf("sql span should be a child of request span")
+	}
+}
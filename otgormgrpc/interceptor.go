@@ -0,0 +1,45 @@
+// Package otgormgrpc provides gRPC server interceptors which bind incoming span to gorm
+package otgormgrpc
+
+import (
+	"context"
+
+	"github.com/jinzhu/gorm"
+	otgorm "github.com/smacker/opentracing-gorm"
+	"google.golang.org/grpc"
+)
+
+// UnaryServerInterceptor returns interceptor which stores db traced with the span of incoming call
+// in the handler context, handlers get it with otgorm.FromContext(ctx).
+// It must be chained after the tracing interceptor (e.g. otgrpc.OpenTracingServerInterceptor) which starts the span
+func UnaryServerInterceptor(db *gorm.DB) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		traced := otgorm.SetSpanToGorm(ctx, db)
+		defer otgorm.Summarize(traced)
+		return handler(otgorm.NewContext(ctx, traced), req)
+	}
+}
+
+// StreamServerInterceptor returns interceptor which stores db traced with the span of incoming stream
+// in the stream context, handlers get it with otgorm.FromContext(stream.Context())
+func StreamServerInterceptor(db *gorm.DB) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		traced := otgorm.SetSpanToGorm(ctx, db)
+		defer otgorm.Summarize(traced)
+		return handler(srv, &serverStream{
+			ServerStream: ss,
+			ctx:          otgorm.NewContext(ctx, traced),
+		})
+	}
+}
+
+// serverStream overrides context of wrapped stream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,50 @@
+package otgormgrpc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	otgorm "github.com/smacker/opentracing-gorm"
+	"github.com/smacker/opentracing-gorm/otgormgrpc"
+	"google.golang.org/grpc"
+)
+
+type Product struct {
+	gorm.Model
+	Code string
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	tracer := mocktracer.New()
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(&Product{})
+	otgorm.AddGormCallbacks(db)
+
+	span := tracer.StartSpan("/products.Service/List")
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+	interceptor := otgormgrpc.UnaryServerInterceptor(db)
+	_, err = interceptor(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		var products []Product
+		return products, otgorm.FromContext(ctx).Find(&products).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 2 {
+		t.Fatalf("should be 2 finished spans but there are %d: %v", len(spans), spans)
+	}
+	if spans[0].OperationName != "sql" || spans[0].ParentID != spans[1].SpanContext.SpanID {
+		t.Errorf("sql span should be a child of call span")
+	}
+}
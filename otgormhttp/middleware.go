@@ -0,0 +1,119 @@
+// Package otgormhttp provides net/http middleware which binds request span to gorm
+package otgormhttp
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otgorm "github.com/smacker/opentracing-gorm"
+)
+
+// Middleware returns net/http middleware which stores db traced with request span in request context,
+// handlers get it with otgorm.FromContext(r.Context()).
+// Span already started by previous middleware is used as is, otherwise request span is started
+// continuing the trace from request headers
+func Middleware(db *gorm.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, finish := StartSpan(r)
+			if finish != nil {
+				sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+				defer func() { finish(sw.status) }()
+				w = wrapWriter(sw)
+			}
+
+			traced := otgorm.SetSpanToGorm(ctx, db)
+			// repeated statements and the summary of the request are finished before the request span
+			defer otgorm.Summarize(traced)
+			next.ServeHTTP(w, r.WithContext(otgorm.NewContext(ctx, traced)))
+		})
+	}
+}
+
+// StartSpan returns context of r with request span started continuing the trace from request headers
+// and a function which tags the span with response status and finishes it. Span already started by previous
+// middleware is used as is and the function is nil then. It's shared by the middlewares of web frameworks
+func StartSpan(r *http.Request) (context.Context, func(status int)) {
+	ctx := r.Context()
+	if opentracing.SpanFromContext(ctx) != nil {
+		return ctx, nil
+	}
+	tracer := opentracing.GlobalTracer()
+	wireContext, _ := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header))
+	span := tracer.StartSpan("HTTP "+r.Method, ext.RPCServerOption(wireContext))
+	ext.HTTPMethod.Set(span, r.Method)
+	ext.HTTPUrl.Set(span, r.URL.String())
+	return opentracing.ContextWithSpan(ctx, span), func(status int) {
+		ext.HTTPStatusCode.Set(span, uint16(status))
+		if status >= http.StatusInternalServerError {
+			ext.Error.Set(span, true)
+		}
+		span.Finish()
+	}
+}
+
+// statusWriter records response status code
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// wrapWriter returns sw which implements http.Flusher, http.Hijacker and io.ReaderFrom
+// only if the wrapped writer does, so handlers can stream responses and upgrade connections
+func wrapWriter(sw *statusWriter) http.ResponseWriter {
+	f, flusher := sw.ResponseWriter.(http.Flusher)
+	h, hijacker := sw.ResponseWriter.(http.Hijacker)
+	rf, readerFrom := sw.ResponseWriter.(io.ReaderFrom)
+	switch {
+	case flusher && hijacker && readerFrom:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{sw, f, h, rf}
+	case flusher && hijacker:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+		}{sw, f, h}
+	case flusher && readerFrom:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			io.ReaderFrom
+		}{sw, f, rf}
+	case hijacker && readerFrom:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			io.ReaderFrom
+		}{sw, h, rf}
+	case flusher:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+		}{sw, f}
+	case hijacker:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+		}{sw, h}
+	case readerFrom:
+		return struct {
+			http.ResponseWriter
+			io.ReaderFrom
+		}{sw, rf}
+	}
+	return sw
+}
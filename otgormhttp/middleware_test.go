@@ -0,0 +1,112 @@
+package otgormhttp_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	otgorm "github.com/smacker/opentracing-gorm"
+	"github.com/smacker/opentracing-gorm/otgormhttp"
+)
+
+type Product struct {
+	gorm.Model
+	Code string
+}
+
+func TestMiddleware(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(&Product{})
+	otgorm.AddGormCallbacks(db)
+
+	handler := otgormhttp.Middleware(db)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var products []Product
+		otgorm.FromContext(r.Context()).Find(&products)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/products", nil))
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 2 {
+		t.Fatalf("should be 2 finished spans but there are %d: %v", len(spans), spans)
+	}
+	if spans[0].OperationName != "sql" || spans[0].ParentID != spans[1].SpanContext.SpanID {
+		t.Errorf("sql span should be a child of request span")
+	}
+	if status := spans[1].Tag("http.status_code"); status != uint16(http.StatusNoContent) {
+		t.Errorf("request span tag 'http.status_code' should be 204 but it's '%v'", status)
+	}
+}
+
+func TestMiddlewareRepeatCompression(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(&Product{})
+	otgorm.AddGormCallbacks(db, otgorm.WithRepeatCompression(time.Hour))
+
+	// the request ends on repeated statements
+	handler := otgormhttp.Middleware(db)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 3; i++ {
+			otgorm.FromContext(r.Context()).Find(&[]Product{})
+		}
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/products", nil))
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 3 {
+		t.Fatalf("should be 3 finished spans but there are %d: %v", len(spans), spans)
+	}
+	if count := spans[1].Tag("db.repeat_count"); count != int64(2) || spans[1].ParentID != spans[2].SpanContext.SpanID {
+		t.Errorf("repeated statements should be finished before the request span but spans are %v", spans)
+	}
+}
+
+func TestMiddlewareWriterInterfaces(t *testing.T) {
+	opentracing.SetGlobalTracer(mocktracer.New())
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otgorm.AddGormCallbacks(db)
+
+	var flusher, hijacker, readerFrom bool
+	handler := otgormhttp.Middleware(db)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, flusher = w.(http.Flusher)
+		_, hijacker = w.(http.Hijacker)
+		_, readerFrom = w.(io.ReaderFrom)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/products", nil))
+	if !flusher || hijacker || readerFrom {
+		t.Errorf("writer should be only a flusher like the recorder but flusher %v, hijacker %v, reader from %v",
+			flusher, hijacker, readerFrom)
+	}
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !flusher || !hijacker || !readerFrom {
+		t.Errorf("writer should forward interfaces of the server writer but flusher %v, hijacker %v, reader from %v",
+			flusher, hijacker, readerFrom)
+	}
+}
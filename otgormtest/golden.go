@@ -0,0 +1,151 @@
+// Package otgormtest records statements executed through gorm callbacks and compares them with golden files,
+// so the exact SQL generated by gorm code can be locked down in tests
+package otgormtest
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	otgorm "github.com/smacker/opentracing-gorm"
+)
+
+// UpdateEnv is the environment variable which makes AssertGolden write golden files instead of comparing them
+const UpdateEnv = "OTGORM_UPDATE_GOLDEN"
+
+// Recorder records statements of queries executed with db returned by Record
+type Recorder struct {
+	tracer *mocktracer.MockTracer
+	span   opentracing.Span
+}
+
+// Record returns db which queries are recorded by the recorder. Callbacks must be added to db by AddGormCallbacks
+// with db.statement tag and without WithTracer
+func Record(db *gorm.DB) (*Recorder, *gorm.DB) {
+	tracer := mocktracer.New()
+	span := tracer.StartSpan("otgormtest")
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+	return &Recorder{tracer: tracer, span: span}, otgorm.SetSpanToGorm(ctx, db)
+}
+
+// Statements returns recorded statements in order of execution with values normalized by Normalize
+func (r *Recorder) Statements() []string {
+	var statements []string
+	for _, sp := range r.tracer.FinishedSpans() {
+		if statement, ok := sp.Tag(string(ext.DBStatement)).(string); ok {
+			statements = append(statements, Normalize(statement))
+		}
+	}
+	return statements
+}
+
+// AssertGolden compares recorded statements with the golden file, one statement per line.
+// The file is written instead if UpdateEnv environment variable is set
+func (r *Recorder) AssertGolden(t testing.TB, path string) {
+	t.Helper()
+	actual := strings.Join(r.Statements(), "\n") + "\n"
+	if os.Getenv(UpdateEnv) != "" {
+		if err := ioutil.WriteFile(path, []byte(actual), 0644); err != nil {
+			t.Fatalf("otgormtest: can't write golden file: %v", err)
+		}
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("otgormtest: can't read golden file, set %s=1 to write it: %v", UpdateEnv, err)
+	}
+	expected := string(data)
+	if actual == expected {
+		return
+	}
+	expectedLines := strings.Split(expected, "\n")
+	actualLines := strings.Split(actual, "\n")
+	for i := 0; i < len(expectedLines) || i < len(actualLines); i++ {
+		var e, a string
+		if i < len(expectedLines) {
+			e = expectedLines[i]
+		}
+		if i < len(actualLines) {
+			a = actualLines[i]
+		}
+		if e != a {
+			t.Errorf("otgormtest: statement %d doesn't match golden file %s\nexpected: %s\nactual:   %s", i+1, path, e, a)
+			return
+		}
+	}
+}
+
+// Normalize replaces string and numeric literals of statement with ? and collapses whitespace outside of them,
+// so values which differ between runs like timestamps and ids don't break golden files
+func Normalize(statement string) string {
+	var b strings.Builder
+	space := false
+	for i := 0; i < len(statement); {
+		ch := statement[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			space = b.Len() > 0
+			i++
+			continue
+		case space:
+			b.WriteByte(' ')
+			space = false
+		}
+
+		switch {
+		case ch == '\'':
+			i = skipQuoted(statement, i, '\'')
+			b.WriteString("?")
+		case ch == '"' || ch == '`':
+			end := skipQuoted(statement, i, ch)
+			b.WriteString(statement[i:end])
+			i = end
+		case isDigit(ch) && (i == 0 || !isIdent(statement[i-1])):
+			for i < len(statement) && (isIdent(statement[i]) || statement[i] == '.') {
+				i++
+			}
+			b.WriteString("?")
+		case isIdent(ch) || ch == '$':
+			// identifiers and $n placeholders are kept with their digits
+			start := i
+			i++
+			for i < len(statement) && isIdent(statement[i]) {
+				i++
+			}
+			b.WriteString(statement[start:i])
+		default:
+			b.WriteByte(ch)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// skipQuoted returns index after the quoted part starting at i, doubled quotes are escapes
+func skipQuoted(s string, i int, quote byte) int {
+	for i++; i < len(s); i++ {
+		if s[i] != quote {
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == quote {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return len(s)
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isIdent(ch byte) bool {
+	return ch == '_' || isDigit(ch) || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z'
+}
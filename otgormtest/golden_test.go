@@ -0,0 +1,54 @@
+package otgormtest_test
+
+import (
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	otgorm "github.com/smacker/opentracing-gorm"
+	"github.com/smacker/opentracing-gorm/otgormtest"
+)
+
+type Product struct {
+	gorm.Model
+	Code  string
+	Price uint
+}
+
+func TestAssertGolden(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.AutoMigrate(&Product{})
+	otgorm.AddGormCallbacks(db)
+
+	rec, tdb := otgormtest.Record(db)
+	tdb.Create(&Product{Code: "L1212", Price: 1000})
+	tdb.Where("code = ?", "L1212").Find(&[]Product{})
+	tdb.Model(&Product{}).Where("price > ?", 100).Update("price", 200)
+	rec.AssertGolden(t, "testdata/products.golden")
+}
+
+func TestNormalize(t *testing.T) {
+	cases := []struct {
+		statement string
+		expected  string
+	}{
+		{
+			statement: `SELECT * FROM "products2"  WHERE (code = 'it''s') AND price > 10.5 LIMIT 1`,
+			expected:  `SELECT * FROM "products2" WHERE (code = ?) AND price > ? LIMIT ?`,
+		},
+		{
+			statement: "UPDATE t1 SET a = $1,\n\tb = -3",
+			expected:  "UPDATE t1 SET a = $1, b = -?",
+		},
+	}
+
+	for _, c := range cases {
+		if normalized := otgormtest.Normalize(c.statement); normalized != c.expected {
+			t.Errorf("normalized statement should be %s but it's %s", c.expected, normalized)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package otgorm
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// setOverhead records time spent in the callbacks for the span and tags it with WithOverheadTag
+func (c *callbacks) setOverhead(sp opentracing.Span, overhead time.Duration, cfg *config) {
+	c.status.addOverhead(overhead)
+	if cfg.overheadTag {
+		sp.SetTag("otgorm.overhead_us", float64(overhead)/float64(time.Microsecond))
+	}
+}
+
+func (s *status) addOverhead(overhead time.Duration) {
+	atomic.AddInt64(&s.overheadNanos, int64(overhead))
+	atomic.AddInt64(&s.overheadSpans, 1)
+}
+
+func (s *status) overhead() (time.Duration, int64) {
+	return time.Duration(atomic.LoadInt64(&s.overheadNanos)), atomic.LoadInt64(&s.overheadSpans)
+}
+
+// Overhead returns total time spent in the callbacks of db on the query path and the number of spans it was spent on,
+// export it as a metric to quantify the cost of tracing. Time of the queries themselves isn't included
+func Overhead(db *gorm.DB) (total time.Duration, spans int64) {
+	return callbacksFromGorm(db).status.overhead()
+}
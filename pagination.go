@@ -0,0 +1,89 @@
+package otgorm
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// paginationRegexp matches LIMIT and OFFSET clauses, MySQL and SQLite LIMIT may be followed by the count
+// as in LIMIT offset, count
+var paginationRegexp = regexp.MustCompile(`(?i)\b(LIMIT|OFFSET)\s+(\d+|\$\d+|\?)(?:\s*,\s*(\d+|\$\d+|\?))?`)
+
+// parsePagination returns LIMIT and OFFSET of the outermost query, clauses of subqueries are ignored.
+// gorm renders them as literals but raw queries may bind them as $n or ? vars
+func parsePagination(query string, vars []interface{}) (limit int64, hasLimit bool, offset int64, hasOffset bool) {
+	outermost, placeholders := scanQuery(query)
+	for _, m := range paginationRegexp.FindAllStringSubmatchIndex(query, -1) {
+		if !outermost[m[0]] {
+			continue
+		}
+		keyword := query[m[2]:m[3]]
+		first, ok := paginationValue(query[m[4]:m[5]], placeholders[m[4]], vars)
+		switch {
+		case m[6] >= 0 && (keyword[0] == 'L' || keyword[0] == 'l'):
+			second, ok2 := paginationValue(query[m[6]:m[7]], placeholders[m[6]], vars)
+			offset, hasOffset = first, ok
+			limit, hasLimit = second, ok2
+		case keyword[0] == 'L' || keyword[0] == 'l':
+			limit, hasLimit = first, ok
+		default:
+			offset, hasOffset = first, ok
+		}
+	}
+	return
+}
+
+// scanQuery returns whether each byte of query is outside of parentheses and quotes
+// and the number of ? placeholders preceding it
+func scanQuery(query string) ([]bool, []int) {
+	outermost := make([]bool, len(query))
+	placeholders := make([]int, len(query))
+	depth, count := 0, 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		placeholders[i] = count
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+			continue
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+			continue
+		case ch == '(':
+			depth++
+			continue
+		case ch == ')':
+			depth--
+		case ch == '?':
+			count++
+		}
+		outermost[i] = depth == 0
+	}
+	return outermost, placeholders
+}
+
+// paginationValue returns value of the literal or of the var bound to $n or to ? preceded by placeholders
+func paginationValue(value string, placeholders int, vars []interface{}) (int64, bool) {
+	var i int
+	switch value[0] {
+	case '$':
+		n, err := strconv.Atoi(value[1:])
+		if err != nil {
+			return 0, false
+		}
+		i = n
+	case '?':
+		i = placeholders + 1
+	default:
+		n, err := strconv.ParseInt(value, 10, 64)
+		return n, err == nil
+	}
+	if i < 1 || i > len(vars) {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(formatValue(vars[i-1], defaultOptions()), 10, 64)
+	return n, err == nil
+}
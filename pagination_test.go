@@ -0,0 +1,38 @@
+package otgorm
+
+import "testing"
+
+func TestParsePagination(t *testing.T) {
+	cases := []struct {
+		query  string
+		vars   []interface{}
+		limit  interface{}
+		offset interface{}
+	}{
+		{query: `SELECT * FROM "users"`, limit: nil, offset: nil},
+		{query: `SELECT * FROM "users" LIMIT 10 OFFSET 5000`, limit: int64(10), offset: int64(5000)},
+		{query: `SELECT * FROM "users" WHERE (id IN (SELECT id FROM "orders" LIMIT 1)) LIMIT 20`, limit: int64(20), offset: nil},
+		{query: `SELECT * FROM "users" WHERE (name = $1) LIMIT $2 OFFSET $3`, vars: []interface{}{"john", 10, 20}, limit: int64(10), offset: int64(20)},
+		{query: `SELECT * FROM (SELECT * FROM "users" LIMIT 5 OFFSET 1) AS u`, limit: nil, offset: nil},
+		{query: `SELECT * FROM "users" WHERE (name = 'LIMIT 3') LIMIT 1`, limit: int64(1), offset: nil},
+		// MySQL and SQLite
+		{query: "SELECT * FROM `users` WHERE (name = ?) LIMIT ? OFFSET ?", vars: []interface{}{"john", 10, 20}, limit: int64(10), offset: int64(20)},
+		{query: "SELECT * FROM `users` WHERE (id IN (SELECT id FROM `orders` WHERE (total > ?) LIMIT ?)) LIMIT ?", vars: []interface{}{100, 1, 30}, limit: int64(30), offset: nil},
+		{query: "SELECT * FROM `users` LIMIT 40, 10", limit: int64(10), offset: int64(40)},
+		{query: "SELECT * FROM `users` WHERE (name = '?') LIMIT ?, ?", vars: []interface{}{40, 10}, limit: int64(10), offset: int64(40)},
+	}
+
+	for _, c := range cases {
+		limit, hasLimit, offset, hasOffset := parsePagination(c.query, c.vars)
+		var actualLimit, actualOffset interface{}
+		if hasLimit {
+			actualLimit = limit
+		}
+		if hasOffset {
+			actualOffset = offset
+		}
+		if actualLimit != c.limit || actualOffset != c.offset {
+			t.Errorf("parsePagination(%q) should be %v, %v but it's %v, %v", c.query, c.limit, c.offset, actualLimit, actualOffset)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package otgorm
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// recoverCallback recovers panic of the callback, so the instrumentation never breaks the query.
+// The panic is logged to the span of the scope and counted, the span is finished if the after callback panicked
+func (c *callbacks) recoverCallback(scope *gorm.Scope, finish bool) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	err := c.status.reportPanic(r)
+
+	if val, ok := scope.Get(SpanOwnerGormKey); !ok || val != c {
+		return
+	}
+	sp, ok := spanFromScope(scope)
+	if !ok {
+		return
+	}
+	c.logPanic(sp, err)
+	if finish {
+		sp.Finish()
+		clearSpan(scope)
+	}
+}
+
+// recoverSpan recovers panic while the span is finished by the async worker and finishes it
+func (c *callbacks) recoverSpan(sp opentracing.Span) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	c.logPanic(sp, c.status.reportPanic(r))
+	sp.Finish()
+}
+
+func (c *callbacks) logPanic(sp opentracing.Span, err error) {
+	sp.SetTag("otgorm.panic", true)
+	sp.LogFields(log.String("event", "otgorm panic"), log.Error(redactedError(err, c.config().options)))
+}
+
+// reportPanic counts recovered panic and records it as instrumentation error
+func (s *status) reportPanic(r interface{}) error {
+	atomic.AddInt64(&s.panics, 1)
+	err := fmt.Errorf("%v", r)
+	s.reportError("panic", err)
+	return err
+}
@@ -0,0 +1,69 @@
+package otgorm
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// setParams tags the number of vars and their approximate size, huge lists and blobs slow queries down
+func (c *callbacks) setParams(sp opentracing.Span, vars []interface{}, cfg *config) {
+	sp.SetTag("db.params.count", len(vars))
+	sp.SetTag("db.params.bytes", paramsSize(vars))
+}
+
+// paramsSize returns approximate size of vars sent to the database in bytes
+func paramsSize(vars []interface{}) int {
+	size := 0
+	for _, val := range vars {
+		size += paramSize(val)
+	}
+	return size
+}
+
+func paramSize(val interface{}) int {
+	switch v := val.(type) {
+	case nil:
+		return 0
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	case bool, int8, uint8:
+		return 1
+	case int16, uint16:
+		return 2
+	case int32, uint32, float32:
+		return 4
+	case time.Time:
+		return 8
+	case driver.Valuer:
+		value, err := v.Value()
+		if err != nil || value == val {
+			return 0
+		}
+		return paramSize(value)
+	}
+
+	ref := reflect.ValueOf(val)
+	switch ref.Kind() {
+	case reflect.String:
+		return ref.Len()
+	case reflect.Slice, reflect.Array:
+		// slice bound to a single placeholder is expanded into multiple params
+		size := 0
+		for i := 0; i < ref.Len(); i++ {
+			size += paramSize(ref.Index(i).Interface())
+		}
+		return size
+	case reflect.Ptr:
+		if ref.IsNil() {
+			return 0
+		}
+		return paramSize(ref.Elem().Interface())
+	default:
+		return 8
+	}
+}
@@ -0,0 +1,25 @@
+package otgorm
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestParamsSize(t *testing.T) {
+	name := "john"
+	vars := []interface{}{
+		"john",          // 4
+		[]byte{1, 2, 3}, // 3
+		int64(1),        // 8
+		true,            // 1
+		time.Now(),      // 8
+		nil,             // 0
+		[]int{1, 2},     // 16
+		&name,           // 4
+		sql.NullString{String: "ab", Valid: true}, // 2
+	}
+	if size := paramsSize(vars); size != 46 {
+		t.Errorf("params size should be 46 but it's %d", size)
+	}
+}
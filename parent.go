@@ -0,0 +1,89 @@
+package otgorm
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// parentStats accumulates queries of the span set by SetSpanToGorm for WithParentTotals, WithParentTimeTags,
+// WithRepeatCompression and WithRequestSummary
+type parentStats struct {
+	span opentracing.Span
+
+	mu    sync.Mutex
+	total time.Duration
+	count int64
+	// exceeded is set once the budget warning is logged
+	exceeded bool
+	// repeat is the run of the last statement
+	repeat *repeatRun
+	// summary is keyed by operation and table
+	summary      map[string]*summaryEntry
+	summaryStart time.Time
+}
+
+// newParentStats returns stats of the parent span if callbacks added to db use WithParentTotals,
+// WithParentTimeTags, WithRepeatCompression or WithRequestSummary
+func newParentStats(db *gorm.DB, parent opentracing.Span) *parentStats {
+	val, ok := db.Get(CallbacksGormKey)
+	c, _ := val.(*callbacks)
+	if !ok || c == nil || !c.config().parentTotals && !c.config().parentTimeTags && c.config().repeatWindow <= 0 &&
+		!c.config().requestSummary {
+		return nil
+	}
+	return &parentStats{span: parent}
+}
+
+// addParentStats adds the query to the totals of the parent span and updates its db.total_ms and db.query_count tags
+// of WithParentTotals or db.time_ms and db.calls tags of WithParentTimeTags, the parent is tagged on every query
+// as its finish can't be observed
+func (c *callbacks) addParentStats(scope *gorm.Scope, duration time.Duration, cfg *config) {
+	stats, ok := parentStatsFromScope(scope)
+	if !ok {
+		return
+	}
+	stats.mu.Lock()
+	stats.total += duration
+	stats.count++
+	total, count := stats.total, stats.count
+	exceeded := !stats.exceeded && cfg.overParentBudget(count, total)
+	if exceeded {
+		stats.exceeded = true
+	}
+	stats.mu.Unlock()
+
+	if cfg.parentTotals {
+		stats.span.SetTag("db.total_ms", float64(total)/float64(time.Millisecond))
+		stats.span.SetTag("db.query_count", count)
+	}
+	if cfg.parentTimeTags {
+		stats.span.SetTag("db.time_ms", float64(total)/float64(time.Millisecond))
+		stats.span.SetTag("db.calls", count)
+	}
+	if exceeded {
+		stats.span.LogFields(
+			log.String("event", "db budget exceeded"),
+			log.Int64("db.query_count", count),
+			log.Float64("db.total_ms", float64(total)/float64(time.Millisecond)),
+		)
+	}
+}
+
+// overParentBudget reports whether the totals exceed budget of WithParentTotals
+func (o options) overParentBudget(count int64, total time.Duration) bool {
+	return o.parentMaxQueries > 0 && count > int64(o.parentMaxQueries) ||
+		o.parentMaxTime > 0 && total > o.parentMaxTime
+}
+
+func parentStatsFromScope(scope *gorm.Scope) (*parentStats, bool) {
+	val, ok := scope.Get(ParentStatsGormKey)
+	if !ok {
+		return nil, false
+	}
+	stats, ok := val.(*parentStats)
+	return stats, ok && stats != nil
+}
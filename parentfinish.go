@@ -0,0 +1,45 @@
+package otgorm
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// finishTimeSpan is implemented by spans which finish time is zero until they are finished
+type finishTimeSpan interface {
+	FinishTime() time.Time
+}
+
+// durationSpan is implemented by spans which duration is zero until they are finished, e.g. jaeger spans
+type durationSpan interface {
+	Duration() time.Duration
+}
+
+// SpanFinished reports whether the span is finished for WithLateQueryCheck, it knows spans with FinishTime
+// or Duration method like jaeger spans. It returns false for other spans
+func SpanFinished(sp opentracing.Span) bool {
+	switch sp := sp.(type) {
+	case finishTimeSpan:
+		return !sp.FinishTime().IsZero()
+	case durationSpan:
+		return sp.Duration() > 0
+	}
+	return false
+}
+
+// checkLateQuery tags the span with db.after_parent if the span set by SetSpanToGorm is already finished
+func (c *callbacks) checkLateQuery(scope *gorm.Scope, sp opentracing.Span, cfg *config) {
+	val, ok := scope.Get(ParentSpanGormKey)
+	if !ok {
+		return
+	}
+	parent, ok := val.(opentracing.Span)
+	if !ok || !cfg.parentFinished(parent) {
+		return
+	}
+	sp.SetTag("db.after_parent", true)
+	sp.LogFields(log.String("event", "query finished after parent span"))
+}
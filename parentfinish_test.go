@@ -0,0 +1,41 @@
+package otgorm
+
+import (
+	"testing"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+type finishTimeMock struct {
+	opentracing.Span
+	finish time.Time
+}
+
+func (s finishTimeMock) FinishTime() time.Time { return s.finish }
+
+type durationMock struct {
+	opentracing.Span
+	duration time.Duration
+}
+
+func (s durationMock) Duration() time.Duration { return s.duration }
+
+func TestSpanFinished(t *testing.T) {
+	noop := opentracing.NoopTracer{}.StartSpan("test")
+	cases := []struct {
+		span     opentracing.Span
+		finished bool
+	}{
+		{span: finishTimeMock{Span: noop}, finished: false},
+		{span: finishTimeMock{Span: noop, finish: time.Now()}, finished: true},
+		{span: durationMock{Span: noop}, finished: false},
+		{span: durationMock{Span: noop, duration: time.Millisecond}, finished: true},
+		{span: noop, finished: false},
+	}
+	for i, c := range cases {
+		if finished := SpanFinished(c.span); finished != c.finished {
+			t.Errorf("case %d: SpanFinished should be %v but it's %v", i, c.finished, finished)
+		}
+	}
+}
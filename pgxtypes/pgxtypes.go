@@ -0,0 +1,51 @@
+// Package pgxtypes registers converters of github.com/jackc/pgtype values for db.statement tag,
+// import it for side effects when gorm runs over pgx stdlib:
+//
+//	import _ "github.com/smacker/opentracing-gorm/pgxtypes"
+package pgxtypes
+
+import (
+	"database/sql/driver"
+	"reflect"
+
+	"github.com/jackc/pgtype"
+	otgorm "github.com/smacker/opentracing-gorm"
+)
+
+var pkgPath = reflect.TypeOf(pgtype.Text{}).PkgPath()
+
+func init() {
+	otgorm.RegisterValueConverter(Convert)
+}
+
+// Convert converts pgtype values like Timestamptz, Text, Int8, UUID and JSONB to their driver value,
+// values with Null status are converted to nil
+func Convert(val interface{}) (interface{}, bool) {
+	valuer, ok := val.(driver.Valuer)
+	if !ok {
+		return nil, false
+	}
+	typ := reflect.TypeOf(val)
+	if typ.Kind() == reflect.Ptr {
+		if reflect.ValueOf(val).IsNil() {
+			return nil, false
+		}
+		typ = typ.Elem()
+	}
+	if typ.PkgPath() != pkgPath {
+		return nil, false
+	}
+
+	value, err := valuer.Value()
+	if err != nil {
+		// value with Undefined status
+		return nil, false
+	}
+	switch val.(type) {
+	case pgtype.JSON, *pgtype.JSON, pgtype.JSONB, *pgtype.JSONB:
+		if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+	}
+	return value, true
+}
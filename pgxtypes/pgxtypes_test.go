@@ -0,0 +1,36 @@
+package pgxtypes_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jackc/pgtype"
+	otgorm "github.com/smacker/opentracing-gorm"
+	_ "github.com/smacker/opentracing-gorm/pgxtypes"
+)
+
+func TestConvert(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	cases := []struct {
+		val      interface{}
+		expected string
+	}{
+		{pgtype.Timestamptz{Time: ts, Status: pgtype.Present}, `'2020-01-02 03:04:05 +0000 UTC'`},
+		{pgtype.Text{String: "john", Status: pgtype.Present}, `'john'`},
+		{&pgtype.Text{String: "john", Status: pgtype.Present}, `'john'`},
+		{pgtype.Text{Status: pgtype.Null}, `NULL`},
+		{pgtype.Int8{Int: 42, Status: pgtype.Present}, `42`},
+		{
+			pgtype.UUID{Bytes: [16]byte{0x12, 0x34, 0x56, 0x78, 0x12, 0x34, 0x56, 0x78, 0x12, 0x34, 0x56, 0x78, 0x12, 0x34, 0x56, 0x78}, Status: pgtype.Present},
+			`'12345678-1234-5678-1234-567812345678'`,
+		},
+		{pgtype.JSONB{Bytes: []byte(`{"a":1}`), Status: pgtype.Present}, `'{"a":1}'`},
+	}
+
+	for _, c := range cases {
+		if actual := otgorm.FormatValue(c.val); actual != c.expected {
+			t.Errorf("FormatValue(%#v) should be %q but it's %q", c.val, c.expected, actual)
+		}
+	}
+}
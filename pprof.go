@@ -0,0 +1,39 @@
+package otgorm
+
+import (
+	"context"
+	"runtime/pprof"
+
+	"github.com/jinzhu/gorm"
+)
+
+// pprofLabelsGormKey holds the context restored when the query with WithPprofLabels finishes
+const pprofLabelsGormKey = "opentracingPprofLabels"
+
+// setPprofLabels labels the goroutine with db.table and db.op of the query, so CPU profiles attribute
+// building the statement and scanning rows to it
+func setPprofLabels(scope *gorm.Scope, operation string) {
+	ctx, ok := contextFromScope(scope)
+	if !ok {
+		ctx = context.Background()
+	}
+	op := operation
+	if op == "" {
+		op = "QUERY"
+	}
+	pprof.SetGoroutineLabels(pprof.WithLabels(ctx, pprof.Labels("db.table", scope.TableName(), "db.op", op)))
+	scope.Set(pprofLabelsGormKey, ctx)
+}
+
+// restorePprofLabels restores labels of the context passed to SetSpanToGorm
+func restorePprofLabels(scope *gorm.Scope) {
+	val, ok := scope.Get(pprofLabelsGormKey)
+	if !ok {
+		return
+	}
+	// nested operations cloned from this scope set their own labels
+	scope.Set(pprofLabelsGormKey, nil)
+	if ctx, ok := val.(context.Context); ok {
+		pprof.SetGoroutineLabels(ctx)
+	}
+}
@@ -0,0 +1,33 @@
+// Package pqtypes registers converters of github.com/lib/pq types for db.statement tag, import it for side effects:
+//
+//	import _ "github.com/smacker/opentracing-gorm/pqtypes"
+package pqtypes
+
+import (
+	"database/sql/driver"
+
+	"github.com/lib/pq"
+	otgorm "github.com/smacker/opentracing-gorm"
+)
+
+func init() {
+	otgorm.RegisterValueConverter(Convert)
+}
+
+// Convert converts pq.NullTime to time.Time or nil and pq arrays to postgres array literals
+func Convert(val interface{}) (interface{}, bool) {
+	switch v := val.(type) {
+	case pq.NullTime:
+		if !v.Valid {
+			return nil, true
+		}
+		return v.Time, true
+	case pq.BoolArray, pq.ByteaArray, pq.Float64Array, pq.Int64Array, pq.StringArray, pq.GenericArray:
+		value, err := v.(driver.Valuer).Value()
+		if err != nil {
+			return nil, false
+		}
+		return value, true
+	}
+	return nil, false
+}
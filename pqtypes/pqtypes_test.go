@@ -0,0 +1,30 @@
+package pqtypes_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	otgorm "github.com/smacker/opentracing-gorm"
+	_ "github.com/smacker/opentracing-gorm/pqtypes"
+)
+
+func TestConvert(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	cases := []struct {
+		val      interface{}
+		expected string
+	}{
+		{pq.NullTime{Time: ts, Valid: true}, `'2020-01-02 03:04:05 +0000 UTC'`},
+		{pq.NullTime{}, `NULL`},
+		{pq.StringArray{"a", "b"}, `'{"a","b"}'`},
+		{pq.Int64Array{1, 2}, `'{1,2}'`},
+	}
+
+	for _, c := range cases {
+		if actual := otgorm.FormatValue(c.val); actual != c.expected {
+			t.Errorf("FormatValue(%#v) should be %q but it's %q", c.val, c.expected, actual)
+		}
+	}
+}
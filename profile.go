@@ -0,0 +1,111 @@
+package otgorm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// callbackProfileGormKey holds span logs of third-party callbacks of the operation with WithCallbackProfiling
+const callbackProfileGormKey = "opentracingCallbackProfile"
+
+// profileCallbacks wraps callbacks registered to db by other plugins and the application with timing,
+// all of them unless WithCallbackProfiling names some. Gorm and tracing callbacks aren't wrapped
+func profileCallbacks(db *gorm.DB, c *callbacks) {
+	names := c.config().profiledCallbacks
+	found := map[string]bool{}
+	for _, p := range debugCallbacks {
+		kindNames := names
+		if len(kindNames) == 0 {
+			var err error
+			if kindNames, err = registeredCallbacks(db, p.processor); err != nil {
+				c.status.reportError("callback profiling", err)
+				return
+			}
+		}
+		for _, name := range kindNames {
+			if !profiled(name) {
+				continue
+			}
+			fn := processor(db, p.processor).Get(name)
+			if fn == nil {
+				continue
+			}
+			found[name] = true
+			processor(db, p.processor).Replace(name, profiledCallback(name, fn))
+		}
+	}
+	for _, name := range names {
+		if profiled(name) && !found[name] {
+			c.status.reportError("callback profiling", fmt.Errorf("callback %q isn't registered, it isn't profiled", name))
+		}
+	}
+}
+
+// profiled reports whether the callback is registered by other plugins or the application
+func profiled(name string) bool {
+	return !strings.HasPrefix(name, "gorm:") && !strings.HasPrefix(name, "tracing:")
+}
+
+// registeredCallbacks returns names of callbacks of the kind registered to db in order of registration,
+// gorm doesn't export the list, so it's read from processors of db.Callback()
+func registeredCallbacks(db *gorm.DB, kind string) ([]string, error) {
+	processors := reflect.ValueOf(db.Callback()).Elem().FieldByName("processors")
+	if processors.Kind() != reflect.Slice {
+		return nil, errors.New("callbacks of this gorm version can't be listed, name them in WithCallbackProfiling")
+	}
+	var names []string
+	seen := map[string]bool{}
+	for i := 0; i < processors.Len(); i++ {
+		p := processors.Index(i).Elem()
+		name, pkind := p.FieldByName("name"), p.FieldByName("kind")
+		if name.Kind() != reflect.String || pkind.Kind() != reflect.String {
+			return nil, errors.New("callbacks of this gorm version can't be listed, name them in WithCallbackProfiling")
+		}
+		if pkind.String() == kind && !seen[name.String()] {
+			seen[name.String()] = true
+			names = append(names, name.String())
+		}
+	}
+	return names, nil
+}
+
+// profiledCallback logs duration of the callback of traced operations
+func profiledCallback(name string, fn func(scope *gorm.Scope)) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		if _, ok := scope.Get(ParentSpanGormKey); !ok {
+			fn(scope)
+			return
+		}
+		start := time.Now()
+		fn(scope)
+		record := opentracing.LogRecord{
+			Timestamp: start,
+			Fields: []log.Field{
+				log.String("event", name),
+				log.Float64("duration_ms", float64(time.Since(start))/float64(time.Millisecond)),
+			},
+		}
+		records, _ := scope.Get(callbackProfileGormKey)
+		logs, _ := records.([]opentracing.LogRecord)
+		scope.Set(callbackProfileGormKey, append(logs[:len(logs):len(logs)], record))
+	}
+}
+
+// takeCallbackProfile returns span logs of third-party callbacks run so far and forgets them,
+// they are logged to the sql span or the operation span, whichever finishes first
+func takeCallbackProfile(scope *gorm.Scope) []opentracing.LogRecord {
+	records, ok := scope.Get(callbackProfileGormKey)
+	if !ok {
+		return nil
+	}
+	scope.Set(callbackProfileGormKey, nil)
+	logs, _ := records.([]opentracing.LogRecord)
+	return logs
+}
@@ -0,0 +1,68 @@
+package otgorm
+
+import "time"
+
+// Profile is a built-in combination of options for an environment
+type Profile int
+
+const (
+	// ProfileDevelopment captures full pretty-printed statements and times gorm callbacks
+	ProfileDevelopment Profile = iota
+	// ProfileProduction captures statements of failed and slow queries only and caps spans of hot loops
+	ProfileProduction
+	// ProfileCompliance never interpolates values into statements and keeps them out of update logs
+	ProfileCompliance
+)
+
+var profileNames = map[string]Profile{
+	"development": ProfileDevelopment,
+	"production":  ProfileProduction,
+	"compliance":  ProfileCompliance,
+}
+
+// profileOptions are options of the profile
+func profileOptions(p Profile) []Option {
+	switch p {
+	case ProfileDevelopment:
+		return []Option{
+			WithDefaultCapture(CaptureFull),
+			WithStatementFormat(StatementPretty),
+			WithCallbackTimings(),
+			WithSlowThreshold(100 * time.Millisecond),
+		}
+	case ProfileProduction:
+		return []Option{
+			WithDefaultCapture(CaptureFull),
+			WithFailedStatement(),
+			WithStatementFormat(StatementCompact),
+			WithSlowThreshold(500 * time.Millisecond),
+			WithSamplingPriority(),
+			WithSpanBudget(100),
+			WithMaxParams(50),
+			WithOversizedStatement(64 << 10),
+		}
+	case ProfileCompliance:
+		return []Option{
+			WithNoValues(),
+			WithDefaultCapture(CapturePlaceholders),
+			WithAllowedColumns(),
+			WithStatementFormat(StatementCompact),
+			WithTags(AllTags &^ TagParams),
+			WithSlowThreshold(500 * time.Millisecond),
+			WithSamplingPriority(),
+		}
+	}
+	return nil
+}
+
+// WithProfile applies options of the profile, options following it override them one by one:
+//
+//	otgorm.AddGormCallbacks(db, otgorm.WithProfile(otgorm.ProfileProduction), otgorm.WithSlowThreshold(time.Second))
+func WithProfile(p Profile) Option {
+	opts := profileOptions(p)
+	return func(o *options) {
+		for _, opt := range opts {
+			opt(o)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package otgorm
+
+import (
+	"errors"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// NewTracedDB opens db with Dialect and DSN of cfg and adds callbacks configured by cfg, spans are started
+// by tracer unless it's nil. It's a provider for dependency injection frameworks like google/wire and uber/fx,
+// cleanup finishes pending spans and closes db. The dialect must be imported by the application
+func NewTracedDB(cfg Config, tracer opentracing.Tracer) (*gorm.DB, func(), error) {
+	if cfg.Dialect == "" {
+		return nil, nil, errors.New("otgorm: dialect isn't configured")
+	}
+	opts, err := cfg.Options()
+	if err != nil {
+		return nil, nil, err
+	}
+	if tracer != nil {
+		opts = append(opts, WithTracer(tracer))
+	}
+	db, err := gorm.Open(cfg.Dialect, cfg.DSN)
+	if err != nil {
+		return nil, nil, err
+	}
+	AddGormCallbacks(db, opts...)
+	cleanup := func() {
+		Flush(db)
+		db.Close()
+	}
+	return db, cleanup, nil
+}
@@ -0,0 +1,46 @@
+package otgorm
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// queryIDRegexp matches query id comment added by WithQueryID, nested operations inherit gorm options of the outer one
+var queryIDRegexp = regexp.MustCompile(`/\* query_id=[0-9a-f]+ \*/ ?`)
+
+// queryIDSettings are gorm settings added to the statement of the operation, comments of queries are prefixes,
+// so they survive truncation of long statements in pg_stat_activity
+var queryIDSettings = map[string]string{
+	"INSERT": "gorm:insert_option",
+	"SELECT": "gorm:query_hint",
+	"UPDATE": "gorm:update_option",
+	"DELETE": "gorm:delete_option",
+	"":       "gorm:query_hint",
+}
+
+// setQueryID adds comment with random query id to the statement and tags the span with it as db.query_id
+func setQueryID(scope *gorm.Scope, sp opentracing.Span, operation string) {
+	id := fmt.Sprintf("%016x", rand.Uint64())
+	comment := "/* query_id=" + id + " */"
+
+	key := queryIDSettings[operation]
+	option := ""
+	if val, ok := scope.Get(key); ok && val != nil {
+		option = strings.TrimSpace(queryIDRegexp.ReplaceAllString(fmt.Sprint(val), ""))
+	}
+	switch {
+	case key == "gorm:query_hint":
+		option = comment + " " + option
+	case option != "":
+		option += " " + comment
+	default:
+		option = comment
+	}
+	scope.Set(key, option)
+	sp.SetTag("db.query_id", id)
+}
@@ -0,0 +1,100 @@
+package otgorm
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// RecordedStatement is a statement recorded by WithTraceRecorder
+type RecordedStatement struct {
+	Time      time.Time `json:"time"`
+	Statement string    `json:"statement"`
+	Table     string    `json:"table"`
+	// Duration is in milliseconds
+	Duration float64 `json:"duration_ms"`
+	Err      string  `json:"error,omitempty"`
+}
+
+// traceRecorder keeps statements of the most recent traces
+type traceRecorder struct {
+	maxTraces     int
+	maxStatements int
+
+	mu     sync.Mutex
+	traces map[string][]RecordedStatement
+	// order is trace ids from the oldest
+	order []string
+}
+
+func newTraceRecorder(traces, statements int) *traceRecorder {
+	return &traceRecorder{maxTraces: traces, maxStatements: statements, traces: make(map[string][]RecordedStatement)}
+}
+
+// record adds statement to the trace, the oldest trace is forgotten when a new one doesn't fit
+func (r *traceRecorder) record(traceID string, statement RecordedStatement) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	statements, ok := r.traces[traceID]
+	if !ok {
+		if len(r.order) == r.maxTraces {
+			delete(r.traces, r.order[0])
+			r.order = append(r.order[:0], r.order[1:]...)
+		}
+		r.order = append(r.order, traceID)
+	}
+	if len(statements) < r.maxStatements {
+		r.traces[traceID] = append(statements, statement)
+	}
+}
+
+func (r *traceRecorder) statements(traceID string) []RecordedStatement {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RecordedStatement(nil), r.traces[traceID]...)
+}
+
+// WithTraceRecorder records statements of the most recent traces, up to statements per trace, in memory.
+// Statements are captured as db.statement is, they are retrieved by trace id with RecordedStatements
+// or DebugHandler with ?trace=<id>, so what a problematic request did against the database can be replayed.
+// Trace ids are read from span contexts as WithTraceIDFunc describes
+func WithTraceRecorder(traces, statements int) Option {
+	return func(o *options) {
+		o.recorderTraces = traces
+		o.recorderStatements = statements
+	}
+}
+
+// RecordedStatements returns statements of the trace recorded by WithTraceRecorder in order of execution
+func RecordedStatements(db *gorm.DB, traceID string) []RecordedStatement {
+	recorder := callbacksFromGorm(db).config().recorder
+	if recorder == nil {
+		return nil
+	}
+	return recorder.statements(traceID)
+}
+
+// recordStatement records statement of the scope for WithTraceRecorder
+func (c *callbacks) recordStatement(scope *gorm.Scope, job finishJob, duration time.Duration) {
+	traceID := job.cfg.traceID(job.sp.Context())
+	if traceID == "" {
+		return
+	}
+	statement := redactedStatement(job.query, job.cfg.options)
+	if job.capture == CaptureNone {
+		statement = ""
+	} else if job.capture == CaptureFull {
+		statement = interpolate(job.query, job.vars, job.cfg.options)
+	}
+	recorded := RecordedStatement{
+		Time:      job.finish.Add(-duration),
+		Statement: statement,
+		Table:     scope.TableName(),
+		Duration:  float64(duration) / float64(time.Millisecond),
+	}
+	if err := scope.DB().Error; err != nil {
+		recorded.Err = redactedError(err, job.cfg.options).Error()
+	}
+	job.cfg.recorder.record(traceID, recorded)
+}
@@ -0,0 +1,170 @@
+package otgorm
+
+import (
+	"sync"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// pendingSpanGormKey holds the sql span of WithRepeatCompression, it isn't exposed under SpanGormKey
+// as children and carriers can't refer to the span before it's started
+const pendingSpanGormKey = "opentracingPendingSpan"
+
+// repeatRun is the run of identical consecutive statements of the parent span for WithRepeatCompression
+type repeatRun struct {
+	query string
+	// last is the finish time of the last statement of the run
+	last time.Time
+	// job finishes the span of the repeated statements, it's nil until the statement repeats
+	job   *finishJob
+	count int64
+	total time.Duration
+}
+
+// mergeRepeat merges the statement into the span of repeated statements if it repeats the previous statement
+// of the parent span within the window, spans of merged statements are never started. It returns false if the span
+// should be finished
+func (c *callbacks) mergeRepeat(stats *parentStats, job finishJob, failed bool, duration time.Duration) bool {
+	stats.mu.Lock()
+	run := stats.repeat
+	repeats := run != nil && run.query == job.query && job.finish.Add(-duration).Sub(run.last) <= job.cfg.repeatWindow
+	if !repeats || failed {
+		stats.repeat = nil
+		if !failed {
+			stats.repeat = &repeatRun{query: job.query, last: job.finish}
+		}
+		stats.mu.Unlock()
+		if run != nil {
+			c.flushRepeat(stats, run)
+		}
+		return false
+	}
+	defer stats.mu.Unlock()
+
+	run.count++
+	run.total += duration
+	run.last = job.finish
+	if run.job == nil {
+		// the span is finished with the run, so its overhead is measured now
+		job.overhead += time.Since(job.afterStart)
+		run.job = &job
+		return true
+	}
+	run.job.finish = job.finish
+	return true
+}
+
+// flushRepeat finishes the span of repeated statements of the run, or of the current run if run is nil,
+// with db.repeat_count and db.repeat_total_ms tags
+func (c *callbacks) flushRepeat(stats *parentStats, run *repeatRun) {
+	stats.mu.Lock()
+	if run == nil {
+		run = stats.repeat
+	}
+	if run == nil || run.job == nil {
+		stats.mu.Unlock()
+		return
+	}
+	job, count, total := *run.job, run.count, run.total
+	run.job = nil
+	if stats.repeat == run {
+		stats.repeat = nil
+	}
+	stats.mu.Unlock()
+
+	job.sp.SetTag("db.repeat_count", count)
+	job.sp.SetTag("db.repeat_total_ms", float64(total)/float64(time.Millisecond))
+	job.afterStart = time.Now()
+	c.finishSpan(job)
+}
+
+// pendingSpan records the sql span of WithRepeatCompression until it's finished, it's started with the tracer
+// only then, so spans of merged statements never reach the tracer
+type pendingSpan struct {
+	tracer opentracing.Tracer
+	parent opentracing.SpanContext
+	start  time.Time
+
+	mu   sync.Mutex
+	name string
+	tags opentracing.Tags
+	logs []opentracing.LogRecord
+}
+
+func newPendingSpan(tracer opentracing.Tracer, name string, parent opentracing.SpanContext, start time.Time) *pendingSpan {
+	return &pendingSpan{tracer: tracer, parent: parent, start: start, name: name, tags: opentracing.Tags{}}
+}
+
+func (s *pendingSpan) Finish() {
+	s.FinishWithOptions(opentracing.FinishOptions{})
+}
+
+// FinishWithOptions starts the span with the tracer and finishes it, recorded logs precede logs of opts
+func (s *pendingSpan) FinishWithOptions(opts opentracing.FinishOptions) {
+	s.mu.Lock()
+	sp := s.tracer.StartSpan(s.name, opentracing.ChildOf(s.parent), opentracing.StartTime(s.start), s.tags)
+	opts.LogRecords = append(s.logs[:len(s.logs):len(s.logs)], opts.LogRecords...)
+	s.mu.Unlock()
+	sp.FinishWithOptions(opts)
+}
+
+// Context returns the context of the parent, so the trace id and sampling are known before the span is started.
+// The pending span isn't exposed to the application, so nothing refers to it as a parent
+func (s *pendingSpan) Context() opentracing.SpanContext { return s.parent }
+func (s *pendingSpan) Tracer() opentracing.Tracer       { return s.tracer }
+
+func (s *pendingSpan) SetOperationName(operationName string) opentracing.Span {
+	s.mu.Lock()
+	s.name = operationName
+	s.mu.Unlock()
+	return s
+}
+
+func (s *pendingSpan) SetTag(key string, value interface{}) opentracing.Span {
+	s.mu.Lock()
+	s.tags[key] = value
+	s.mu.Unlock()
+	return s
+}
+
+func (s *pendingSpan) LogFields(fields ...log.Field) {
+	s.mu.Lock()
+	s.logs = append(s.logs, opentracing.LogRecord{Timestamp: time.Now(), Fields: fields})
+	s.mu.Unlock()
+}
+
+func (s *pendingSpan) LogKV(alternatingKeyValues ...interface{}) {
+	fields, err := log.InterleavedKVToFields(alternatingKeyValues...)
+	if err != nil {
+		fields = []log.Field{log.Error(err)}
+	}
+	s.LogFields(fields...)
+}
+
+func (s *pendingSpan) SetBaggageItem(restrictedKey, value string) opentracing.Span { return s }
+
+func (s *pendingSpan) BaggageItem(restrictedKey string) string {
+	var value string
+	s.parent.ForeachBaggageItem(func(k, v string) bool {
+		if k == restrictedKey {
+			value = v
+			return false
+		}
+		return true
+	})
+	return value
+}
+
+func (s *pendingSpan) LogEvent(event string) {
+	s.LogFields(log.String("event", event))
+}
+
+func (s *pendingSpan) LogEventWithPayload(event string, payload interface{}) {
+	s.LogFields(log.String("event", event), log.Object("payload", payload))
+}
+
+func (s *pendingSpan) Log(data opentracing.LogData) {
+	s.LogEventWithPayload(data.Event, data.Payload)
+}
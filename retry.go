@@ -0,0 +1,114 @@
+package otgorm
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// RetryPolicy configures Retry
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts including the first one
+	MaxAttempts int
+	// Backoff is the delay before the second attempt, it's doubled for every next one
+	Backoff time.Duration
+	// MaxBackoff limits the delay between attempts, zero means no limit
+	MaxBackoff time.Duration
+	// Retryable reports whether the error is worth retrying, IsRetryable is used if it's nil
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy retries deadlocks, serialization failures and CockroachDB restarts 3 times in total
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     10 * time.Millisecond,
+	MaxBackoff:  time.Second,
+}
+
+// retryableErrors are fragments of deadlock and serialization failure errors of supported databases
+var retryableErrors = []string{
+	"40001",                         // postgres serialization_failure
+	"40P01",                         // postgres deadlock_detected
+	"deadlock detected",             // postgres
+	"could not serialize access",    // postgres
+	"restart transaction",           // cockroachdb transaction retry error
+	"Error 1213",                    // mysql ER_LOCK_DEADLOCK
+	"Error 1205",                    // mysql ER_LOCK_WAIT_TIMEOUT
+	"Deadlock found when trying to", // mysql
+	"database is locked",            // sqlite SQLITE_BUSY
+	"database table is locked",      // sqlite SQLITE_LOCKED
+}
+
+// IsRetryable reports whether err is a deadlock, a serialization failure or CockroachDB transaction restart
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	for _, fragment := range retryableErrors {
+		if strings.Contains(msg, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// Retry calls fn until it succeeds, returns not retryable error or policy attempts are exhausted.
+// Attempts run inside "gorm:retry" span with db traced with it, every failed attempt is recorded
+// as the span log with attempt number, backoff and error. The span is started by the tracer of WithTracer
+// or WithGlobalTracer if they are used
+func Retry(ctx context.Context, db *gorm.DB, policy RetryPolicy, fn func(db *gorm.DB) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	opts := callbacksFromGorm(db).config().options
+	span, ctx := opts.startSpanFromContext(ctx, "gorm:retry")
+	defer span.Finish()
+	tx := SetSpanToGorm(ctx, db)
+
+	backoff := policy.Backoff
+	for attempt := 1; ; attempt++ {
+		err := fn(tx)
+		if err == nil {
+			span.SetTag("db.retry.attempts", attempt)
+			return nil
+		}
+
+		if attempt >= policy.MaxAttempts || !retryable(err) {
+			span.SetTag("db.retry.attempts", attempt)
+			ext.Error.Set(span, true)
+			span.LogFields(log.String("event", "error"), log.Int("attempt", attempt), log.Error(redactedError(err, opts)))
+			return err
+		}
+		span.LogFields(
+			log.String("event", "retry"),
+			log.Int("attempt", attempt),
+			log.String("backoff", backoff.String()),
+			log.Error(redactedError(err, opts)),
+		)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			span.SetTag("db.retry.attempts", attempt)
+			ext.Error.Set(span, true)
+			return err
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package otgorm
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// rowsQueryGormKey holds the sql span of the query of Rows, Close finishes it with the number of rows
+const rowsQueryGormKey = "opentracingRowsQuery"
+
+// rowsQuery is the sql span of the query of Rows waiting for the rows to be read
+type rowsQuery struct {
+	job finishJob
+	ok  bool
+}
+
+// TracedRows is sql.Rows returned by Rows, its span is open until the rows are closed
+type TracedRows struct {
+	*sql.Rows
+
+	span opentracing.Span
+	rows int64
+	scan time.Duration
+
+	c     *callbacks
+	cfg   *config
+	query *rowsQuery
+	// scanSpan is started by the first Next with WithScanSpans
+	scanSpan opentracing.Span
+}
+
+// Rows calls db.Rows inside "gorm:rows" span, which is finished by Close. The span is tagged with the number of
+// iterated rows as db.rows and time spent in Scan and ScanRows as db.scan_ms. The query itself is a child sql span
+// tagged with the number of rows as db.count, iteration is a child "gorm:scan" span with WithScanSpans
+func Rows(db *gorm.DB) (*TracedRows, error) {
+	val, ok := db.Get(ParentSpanGormKey)
+	if !ok {
+		rows, err := db.Rows()
+		return &TracedRows{Rows: rows}, err
+	}
+	c := callbacksFromGorm(db)
+	cfg := c.config()
+	parent, tr, ok := cfg.spanParent(val)
+	if !ok {
+		rows, err := db.Rows()
+		return &TracedRows{Rows: rows}, err
+	}
+	sp := cfg.sanitizeSpan(tr.StartSpan("gorm:rows", opentracing.ChildOf(parent)))
+	scope := db.NewScope(db.Value)
+	sp.SetTag("db.table", scope.TableName())
+
+	query := &rowsQuery{}
+	rows, err := db.Set(ParentSpanGormKey, sp).Set(rowsQueryGormKey, query).Rows()
+	if err != nil {
+		if query.ok {
+			c.finishSpan(query.job)
+		}
+		ext.Error.Set(sp, true)
+		logError(sp, err, cfg.options)
+		sp.Finish()
+		return &TracedRows{Rows: rows}, err
+	}
+	return &TracedRows{Rows: rows, span: sp, c: c, cfg: cfg, query: query}, nil
+}
+
+// Next counts iterated rows
+func (r *TracedRows) Next() bool {
+	if r.span != nil && r.cfg.scanSpans && r.scanSpan == nil {
+		r.scanSpan = r.cfg.sanitizeSpan(r.span.Tracer().StartSpan("gorm:scan", opentracing.ChildOf(r.span.Context())))
+	}
+	if !r.Rows.Next() {
+		return false
+	}
+	r.rows++
+	return true
+}
+
+// Scan measures time spent in scanning
+func (r *TracedRows) Scan(dest ...interface{}) error {
+	start := time.Now()
+	err := r.Rows.Scan(dest...)
+	r.scan += time.Since(start)
+	return err
+}
+
+// ScanRows scans the current row into result with db.ScanRows and measures time spent in it
+func (r *TracedRows) ScanRows(db *gorm.DB, result interface{}) error {
+	start := time.Now()
+	err := db.ScanRows(r.Rows, result)
+	r.scan += time.Since(start)
+	return err
+}
+
+// Close closes the rows and finishes the spans
+func (r *TracedRows) Close() error {
+	err := r.Rows.Close()
+	if r.span == nil {
+		return err
+	}
+	if r.query.ok {
+		if r.cfg.has(TagCount) {
+			r.query.job.sp.SetTag("db.count", r.rows)
+		}
+		r.c.finishSpan(r.query.job)
+	}
+	r.span.SetTag("db.rows", r.rows)
+	r.span.SetTag("db.scan_ms", float64(r.scan)/float64(time.Millisecond))
+	if r.scanSpan != nil {
+		r.scanSpan.SetTag("db.rows", r.rows)
+		r.scanSpan.Finish()
+	}
+	if iterErr := r.Rows.Err(); iterErr != nil {
+		ext.Error.Set(r.span, true)
+		logError(r.span, iterErr, r.cfg.options)
+	}
+	r.span.Finish()
+	r.span = nil
+	return err
+}
+
+// rowsQueryFromScope returns rowsQuery of Rows the scope is run by
+func rowsQueryFromScope(scope *gorm.Scope) (*rowsQuery, bool) {
+	val, ok := scope.Get(rowsQueryGormKey)
+	if !ok {
+		return nil, false
+	}
+	query, ok := val.(*rowsQuery)
+	return query, ok && query != nil
+}
@@ -0,0 +1,50 @@
+package otgorm
+
+import (
+	"context"
+	"runtime/trace"
+
+	"github.com/jinzhu/gorm"
+)
+
+// runtimeTraceGormKey holds runtime/trace task and region of the query with WithRuntimeTrace
+const runtimeTraceGormKey = "opentracingRuntimeTrace"
+
+type runtimeTrace struct {
+	task   *trace.Task
+	region *trace.Region
+}
+
+// startRuntimeTrace starts runtime/trace task and region of the query, they are recorded only while
+// the execution trace is collected
+func startRuntimeTrace(scope *gorm.Scope, operation string) {
+	if !trace.IsEnabled() {
+		return
+	}
+	ctx, ok := contextFromScope(scope)
+	if !ok {
+		ctx = context.Background()
+	}
+	ctx, task := trace.NewTask(ctx, "otgorm.query")
+	table := scope.TableName()
+	trace.Log(ctx, "db.table", table)
+	// gorm callbacks run on the goroutine of the caller, so the region ends on the same goroutine
+	region := trace.StartRegion(ctx, spanName(operation, table))
+	scope.Set(runtimeTraceGormKey, &runtimeTrace{task: task, region: region})
+}
+
+// endRuntimeTrace ends runtime/trace region and task of the query
+func endRuntimeTrace(scope *gorm.Scope) {
+	val, ok := scope.Get(runtimeTraceGormKey)
+	if !ok {
+		return
+	}
+	// nested operations cloned from this scope start their own region
+	scope.Set(runtimeTraceGormKey, nil)
+	rt, ok := val.(*runtimeTrace)
+	if !ok {
+		return
+	}
+	rt.region.End()
+	rt.task.End()
+}
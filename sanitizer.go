@@ -0,0 +1,26 @@
+package otgorm
+
+import (
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// sanitizedSpan applies WithTagSanitizer to tags set on the span
+type sanitizedSpan struct {
+	opentracing.Span
+	sanitize func(key string, value interface{}) (interface{}, bool)
+}
+
+func (s sanitizedSpan) SetTag(key string, value interface{}) opentracing.Span {
+	if value, ok := s.sanitize(key, value); ok {
+		s.Span.SetTag(key, value)
+	}
+	return s
+}
+
+// sanitizeSpan returns span applying WithTagSanitizer to its tags
+func (o options) sanitizeSpan(sp opentracing.Span) opentracing.Span {
+	if o.tagSanitizer == nil {
+		return sp
+	}
+	return sanitizedSpan{Span: sp, sanitize: o.tagSanitizer}
+}
@@ -0,0 +1,40 @@
+package otgorm
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// setTraceSetting sets Postgres setting of WithTraceSetting to the trace id of the span with SET LOCAL,
+// which lasts until the end of the transaction, so queries outside of transactions are skipped
+func (c *callbacks) setTraceSetting(scope *gorm.Scope, sp opentracing.Span, cfg *config) {
+	if scope.Dialect().GetName() != "postgres" {
+		return
+	}
+	if _, ok := scope.SQLDB().(*sql.Tx); !ok {
+		return
+	}
+	id := cfg.traceID(sp.Context())
+	if id == "" {
+		return
+	}
+	statement := traceSettingStatement(cfg.traceSetting, id)
+	// application_name keeps the service stamped by WithApplicationName
+	if service := cfg.applicationName; service != "" && cfg.traceSetting == "application_name" {
+		statement = applicationNameStatement(applicationName(service, "trace:"+id), true)
+	}
+	if _, err := scope.SQLDB().Exec(statement); err != nil {
+		sp.LogFields(log.String("event", "trace setting failed"), log.Error(err))
+		c.status.reportError("trace setting", err)
+	}
+}
+
+// traceSettingStatement returns SET LOCAL statement setting the setting to trace:<id>
+func traceSettingStatement(setting, id string) string {
+	return fmt.Sprintf("SET LOCAL %s = 'trace:%s'", setting, strings.Replace(id, "'", "''", -1))
+}
@@ -0,0 +1,50 @@
+package otgorm
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTraceSettingStatement(t *testing.T) {
+	cases := []struct {
+		setting  string
+		id       string
+		expected string
+	}{
+		{setting: "application_name", id: "5f0c6c4a7e4f1b2d", expected: "SET LOCAL application_name = 'trace:5f0c6c4a7e4f1b2d'"},
+		{setting: "app.trace_id", id: "1", expected: "SET LOCAL app.trace_id = 'trace:1'"},
+		{setting: "application_name", id: "a'b", expected: "SET LOCAL application_name = 'trace:a''b'"},
+	}
+
+	for _, c := range cases {
+		if statement := traceSettingStatement(c.setting, c.id); statement != c.expected {
+			t.Errorf("statement should be %s but it's %s", c.expected, statement)
+		}
+	}
+}
+
+func TestApplicationNameStatement(t *testing.T) {
+	cases := []struct {
+		service  string
+		suffix   string
+		local    bool
+		expected string
+	}{
+		{service: "billing", expected: "SET application_name = 'billing otgorm/" + Version + "'"},
+		{service: "billing", suffix: "trace:1f", local: true, expected: "SET LOCAL application_name = 'billing otgorm/" + Version + " trace:1f'"},
+		{service: "o'neil", expected: "SET application_name = 'o''neil otgorm/" + Version + "'"},
+	}
+	for _, c := range cases {
+		if statement := applicationNameStatement(applicationName(c.service, c.suffix), c.local); statement != c.expected {
+			t.Errorf("statement should be %s but it's %s", c.expected, statement)
+		}
+	}
+
+	if name := applicationName(strings.Repeat("s", 100), "trace:1"); len(name) != maxApplicationName {
+		t.Errorf("application name should be truncated to %d bytes but it's %d", maxApplicationName, len(name))
+	}
+	if name := applicationName(strings.Repeat("ж", 40), ""); len(name) != maxApplicationName-1 || !utf8.ValidString(name) {
+		t.Errorf("application name should be truncated on a rune boundary but it's %q", name)
+	}
+}
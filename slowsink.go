@@ -0,0 +1,57 @@
+package otgorm
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// SlowQuery is a digest of query slower than WithSlowThreshold
+type SlowQuery struct {
+	// Fingerprint identifies the statement regardless of whitespace, it's the same for all executions of the statement
+	Fingerprint string
+	// Statement is the statement with placeholders, without values
+	Statement string
+	Duration  time.Duration
+	Table     string
+	// TraceID is the trace id of the sql span if its tracer exposes it
+	TraceID string
+}
+
+// WithSlowQuerySink calls sink with SlowQuery digest of every query slower than WithSlowThreshold,
+// so own alerting or sampling pipelines can be fed without scraping the tracing backend.
+// sink is called synchronously after the query, hand digests off to a buffered channel if processing them is slow:
+//
+//	otgorm.WithSlowQuerySink(func(q otgorm.SlowQuery) {
+//		select {
+//		case digests <- q:
+//		default:
+//		}
+//	})
+func WithSlowQuerySink(sink func(SlowQuery)) Option {
+	return func(o *options) {
+		o.slowQuerySink = sink
+	}
+}
+
+// emitSlowQuery reports slow query of the scope to the slow query sink
+func (c *callbacks) emitSlowQuery(scope *gorm.Scope, sp opentracing.Span, duration time.Duration, cfg *config) {
+	statement := redactedStatement(compactStatement(scope.SQL), cfg.options)
+	cfg.slowQuerySink(SlowQuery{
+		Fingerprint: fingerprint(statement),
+		Statement:   statement,
+		Duration:    duration,
+		Table:       scope.TableName(),
+		TraceID:     cfg.traceID(sp.Context()),
+	})
+}
+
+// fingerprint returns hex fnv-1a hash of the statement
+func fingerprint(statement string) string {
+	h := fnv.New64a()
+	h.Write([]byte(statement))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
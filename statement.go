@@ -0,0 +1,268 @@
+package otgorm
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// IsSampled reports whether span context implementing IsSampled() bool is sampled,
+// contexts of other tracers are considered sampled
+func IsSampled(sc opentracing.SpanContext) bool {
+	if s, ok := sc.(interface{ IsSampled() bool }); ok {
+		return s.IsSampled()
+	}
+	return true
+}
+
+// setStatement sets db.statement tag with query captured as configured by capture,
+// statements with too many params are not interpolated
+func (c *callbacks) setStatement(sp opentracing.Span, query string, vars []interface{}, capture Capture, cfg *config) {
+	if !cfg.has(TagStatement) || capture == CaptureNone {
+		return
+	}
+	if sampled := cfg.sampled; sampled != nil && !sampled(sp.Context()) {
+		return
+	}
+	statement := redactedStatement(query, cfg.options)
+	if capture == CaptureFull {
+		if cfg.maxParams > 0 && len(vars) > cfg.maxParams {
+			sp.SetTag("db.statement.truncated_params", true)
+		} else {
+			statement = interpolate(query, vars, cfg.options)
+		}
+	}
+	statement = formatStatement(statement, cfg.statementFormat)
+	if limit := cfg.oversizedStatement; limit > 0 && len(statement) > limit {
+		sp.SetTag("db.statement.oversized", true)
+		sp.SetTag("db.statement.bytes", len(statement))
+	}
+	ext.DBStatement.Set(sp, statement)
+}
+
+// interpolate replaces placeholders of query with formatted vars. Postgres $n placeholders are used
+// if query has any, otherwise ? placeholders of other dialects are replaced in order.
+// Query is returned with redacted literals in no values mode
+func interpolate(query string, vars []interface{}, opts options) string {
+	if opts.noValues {
+		return redactLiterals(query)
+	}
+	style := placeholderStyle(query)
+	allowlist := opts.hasAllowlist()
+	var columns []string
+	valuesStart, valuesEnd := -1, -1
+	if allowlist {
+		columns, valuesStart, valuesEnd = insertColumns(query)
+	}
+
+	var b strings.Builder
+	// count is the number of ? placeholders replaced so far
+	count := 0
+	// inserted is the number of placeholders after VALUES of INSERT
+	inserted := 0
+	for i := 0; i < len(query); {
+		// render IN ($1,$2,...) lists as a whole, so they can be summarized
+		if open, values, end, next, ok := parseInList(query, i, vars, style, count); ok {
+			b.WriteString(query[i:open])
+			if allowlist && !opts.valueAllowed(placeholderColumn(query, i), 0) {
+				b.WriteString(maskInValues(len(values), opts))
+			} else {
+				b.WriteString(formatInValues(values, opts))
+			}
+			b.WriteString(")")
+			i = end
+			count = next
+			continue
+		}
+
+		if n, end, ok := parsePlaceholder(query, i, style, count); ok && n <= len(vars) {
+			if allowlist {
+				column := placeholderColumn(query, i)
+				if len(columns) > 0 && i > valuesStart && i < valuesEnd {
+					column = columns[inserted%len(columns)]
+					inserted++
+				}
+				if opts.valueAllowed(column, n) {
+					b.WriteString(formatValue(vars[n-1], opts))
+				} else {
+					b.WriteByte('?')
+				}
+			} else {
+				b.WriteString(formatValue(vars[n-1], opts))
+			}
+			i = end
+			if style == questionPlaceholder {
+				count = n
+			}
+			continue
+		}
+
+		b.WriteByte(query[i])
+		i++
+	}
+	return b.String()
+}
+
+// placeholder styles of dialects
+const (
+	dollarPlaceholder   = '$'
+	questionPlaceholder = '?'
+)
+
+// placeholderStyle returns $ if query has Postgres $n placeholders, ? otherwise,
+// so ? operators of Postgres jsonb aren't mistaken for placeholders
+func placeholderStyle(query string) byte {
+	for i := 0; i < len(query); i++ {
+		if _, _, ok := parsePlaceholder(query, i, dollarPlaceholder, 0); ok {
+			return dollarPlaceholder
+		}
+	}
+	return questionPlaceholder
+}
+
+// parsePlaceholder parses placeholder of the style starting at i, returns its number and the end position.
+// count is the number of ? placeholders before i
+func parsePlaceholder(query string, i int, style byte, count int) (n int, end int, ok bool) {
+	if query[i] != style {
+		return 0, i, false
+	}
+	if style == questionPlaceholder {
+		return count + 1, i + 1, true
+	}
+	end = i + 1
+	for end < len(query) && query[end] >= '0' && query[end] <= '9' {
+		n = n*10 + int(query[end]-'0')
+		end++
+	}
+	return n, end, n > 0
+}
+
+// parseInList parses IN (...) list of placeholders starting at i,
+// returns the position after opening parenthesis, values of the list, the end position
+// and the number of ? placeholders after the list
+func parseInList(query string, i int, vars []interface{}, style byte, count int) (open int, values []interface{}, end int, next int, ok bool) {
+	if i+2 > len(query) || !strings.EqualFold(query[i:i+2], "IN") || (i > 0 && isIdentChar(query[i-1])) {
+		return 0, nil, i, count, false
+	}
+	pos := skipSpaces(query, i+2)
+	if pos >= len(query) || query[pos] != '(' {
+		return 0, nil, i, count, false
+	}
+	open = pos + 1
+
+	pos = open
+	next = count
+	for {
+		pos = skipSpaces(query, pos)
+		if pos >= len(query) {
+			return 0, nil, i, count, false
+		}
+		n, end, isPlaceholder := parsePlaceholder(query, pos, style, next)
+		if !isPlaceholder || n > len(vars) {
+			return 0, nil, i, count, false
+		}
+		if style == questionPlaceholder {
+			next = n
+		}
+		values = append(values, expandSlice(vars[n-1])...)
+
+		pos = skipSpaces(query, end)
+		if pos >= len(query) {
+			return 0, nil, i, count, false
+		}
+		switch query[pos] {
+		case ',':
+			pos++
+		case ')':
+			return open, values, pos + 1, next, true
+		default:
+			return 0, nil, i, count, false
+		}
+	}
+}
+
+func formatInValues(values []interface{}, opts options) string {
+	if opts.maxInValues > 0 && len(values) > opts.maxInValues {
+		return fmt.Sprintf("… %d values", len(values))
+	}
+	formatted := make([]string, len(values))
+	for i, val := range values {
+		formatted[i] = formatValue(val, opts)
+	}
+	return strings.Join(formatted, ",")
+}
+
+// expandSlice returns elements of slice value, any other value is returned as is
+func expandSlice(val interface{}) []interface{} {
+	if _, ok := val.([]byte); ok {
+		return []interface{}{val}
+	}
+	ref := reflect.ValueOf(val)
+	if ref.Kind() != reflect.Slice && ref.Kind() != reflect.Array {
+		return []interface{}{val}
+	}
+	values := make([]interface{}, ref.Len())
+	for i := range values {
+		values[i] = ref.Index(i).Interface()
+	}
+	return values
+}
+
+func skipSpaces(query string, i int) int {
+	for i < len(query) && (query[i] == ' ' || query[i] == '\t' || query[i] == '\n' || query[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func formatValue(val interface{}, opts options) string {
+	if val == nil {
+		return "NULL"
+	}
+	if value, ok := convertRegistered(val); ok {
+		return formatValue(value, opts)
+	}
+
+	// check type before kind, so structs like time.Time and sql.Null* aren't dumped
+	switch v := val.(type) {
+	case []byte:
+		return fmt.Sprintf(`%v`, v)
+	case time.Time:
+		return formatTime(v, opts)
+	case sql.NullTime, sql.NullString, sql.NullInt64, sql.NullInt32, sql.NullBool, sql.NullFloat64:
+		// Value of null types never fails
+		value, _ := v.(driver.Valuer).Value()
+		return formatValue(value, opts)
+	}
+
+	switch reflect.ValueOf(val).Kind() {
+	case reflect.String:
+		return fmt.Sprintf(`'%s'`, val)
+	case reflect.Slice, reflect.Array:
+		// slice bound to a single placeholder
+		return formatInValues(expandSlice(val), opts)
+	default:
+		return fmt.Sprintf(`%v`, val)
+	}
+}
+
+// formatTime formats time in the layout and location of WithTimeFormat, by default time is rendered with String()
+func formatTime(t time.Time, opts options) string {
+	if opts.timeLocation != nil {
+		t = t.In(opts.timeLocation)
+	}
+	if opts.timeLayout == "" {
+		return fmt.Sprintf(`'%v'`, t.String())
+	}
+	return fmt.Sprintf(`'%v'`, t.Format(opts.timeLayout))
+}
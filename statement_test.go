@@ -0,0 +1,151 @@
+package otgorm
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestInterpolate(t *testing.T) {
+	opts := defaultOptions()
+	opts.maxInValues = 3
+
+	cases := []struct {
+		query    string
+		vars     []interface{}
+		expected string
+	}{
+		{
+			query:    `SELECT * FROM "users" WHERE (name = $1 AND age > $2)`,
+			vars:     []interface{}{"john", 18},
+			expected: `SELECT * FROM "users" WHERE (name = 'john' AND age > 18)`,
+		},
+		{
+			query:    `SELECT * FROM "users" WHERE (id IN ($1,$2,$3))`,
+			vars:     []interface{}{1, 2, 3},
+			expected: `SELECT * FROM "users" WHERE (id IN (1,2,3))`,
+		},
+		{
+			query:    `SELECT * FROM "users" WHERE (id IN ($1,$2,$3,$4))`,
+			vars:     []interface{}{1, 2, 3, 4},
+			expected: `SELECT * FROM "users" WHERE (id IN (… 4 values))`,
+		},
+		{
+			query:    `SELECT * FROM "users" WHERE (id in ($1))`,
+			vars:     []interface{}{[]string{"a", "b"}},
+			expected: `SELECT * FROM "users" WHERE (id in ('a','b'))`,
+		},
+		{
+			query:    `SELECT * FROM "users" WHERE (id IN ($1) AND name = $2)`,
+			vars:     []interface{}{[]int{1, 2, 3, 4, 5}, "john"},
+			expected: `SELECT * FROM "users" WHERE (id IN (… 5 values) AND name = 'john')`,
+		},
+		{
+			query:    `SELECT * FROM "users" WHERE (a = $1 AND b = $10)`,
+			vars:     []interface{}{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+			expected: `SELECT * FROM "users" WHERE (a = 1 AND b = 10)`,
+		},
+		{
+			query:    "SELECT * FROM `users` WHERE (name = ? AND id IN (?,?) AND age > ?)",
+			vars:     []interface{}{"john", 1, 2, 18},
+			expected: "SELECT * FROM `users` WHERE (name = 'john' AND id IN (1,2) AND age > 18)",
+		},
+		{
+			query:    "SELECT * FROM `users` WHERE (id IN (?,?,?,?) AND name = ?)",
+			vars:     []interface{}{1, 2, 3, 4, "john"},
+			expected: "SELECT * FROM `users` WHERE (id IN (… 4 values) AND name = 'john')",
+		},
+		{
+			query:    `SELECT * FROM "users" WHERE (data ? 'key' AND id = $1)`,
+			vars:     []interface{}{1},
+			expected: `SELECT * FROM "users" WHERE (data ? 'key' AND id = 1)`,
+		},
+	}
+
+	for _, c := range cases {
+		if actual := interpolate(c.query, c.vars, opts); actual != c.expected {
+			t.Errorf("interpolate(%q) should be %q but it's %q", c.query, c.expected, actual)
+		}
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	cases := []struct {
+		val      interface{}
+		expected string
+	}{
+		{nil, `NULL`},
+		{"john", `'john'`},
+		{42, `42`},
+		{ts, `'2020-01-02 03:04:05 +0000 UTC'`},
+		{sql.NullTime{Time: ts, Valid: true}, `'2020-01-02 03:04:05 +0000 UTC'`},
+		{sql.NullTime{}, `NULL`},
+		{sql.NullString{String: "john", Valid: true}, `'john'`},
+		{sql.NullInt64{Int64: 42, Valid: true}, `42`},
+		{sql.NullBool{}, `NULL`},
+	}
+
+	for _, c := range cases {
+		if actual := formatValue(c.val, defaultOptions()); actual != c.expected {
+			t.Errorf("formatValue(%#v) should be %q but it's %q", c.val, c.expected, actual)
+		}
+	}
+}
+
+type point struct{ x, y int }
+
+func TestRegisterValueConverter(t *testing.T) {
+	RegisterValueConverter(func(val interface{}) (interface{}, bool) {
+		if p, ok := val.(point); ok {
+			return fmt.Sprintf("(%d,%d)", p.x, p.y), true
+		}
+		return nil, false
+	})
+
+	if actual := FormatValue(point{1, 2}); actual != `'(1,2)'` {
+		t.Errorf("registered converter should be used but value is formatted as %q", actual)
+	}
+	if actual := FormatValue(42); actual != `42` {
+		t.Errorf("built-in formatting should be used but value is formatted as %q", actual)
+	}
+}
+
+func TestFormatTime(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6000, time.FixedZone("UTC+3", 3*60*60))
+
+	opts := defaultOptions()
+	WithTimeFormat("2006-01-02 15:04:05.999999", time.UTC)(&opts)
+	if actual := formatValue(ts, opts); actual != `'2020-01-02 00:04:05.000006'` {
+		t.Errorf("time should be formatted in UTC but it's %q", actual)
+	}
+
+	opts = defaultOptions()
+	WithTimeFormat("2006-01-02", nil)(&opts)
+	if actual := formatValue(sql.NullTime{Time: ts, Valid: true}, opts); actual != `'2020-01-02'` {
+		t.Errorf("null time should be formatted with the layout but it's %q", actual)
+	}
+}
+
+func TestFormatStatement(t *testing.T) {
+	query := "SELECT *  FROM \"products\"\n  LEFT JOIN \"prices\" ON (prices.id = (SELECT id FROM p WHERE x = 1))\n  WHERE code = 'a  b\nc' ORDER BY id LIMIT 1"
+	cases := []struct {
+		format   StatementFormat
+		expected string
+	}{
+		{StatementRaw, query},
+		{StatementCompact, "SELECT * FROM \"products\" LEFT JOIN \"prices\" ON (prices.id = (SELECT id FROM p WHERE x = 1)) WHERE code = 'a  b\nc' ORDER BY id LIMIT 1"},
+		{StatementPretty, "SELECT *\nFROM \"products\"\nLEFT JOIN \"prices\" ON (prices.id = (SELECT id FROM p WHERE x = 1))\nWHERE code = 'a  b\nc'\nORDER BY id\nLIMIT 1"},
+	}
+	for _, c := range cases {
+		if actual := formatStatement(query, c.format); actual != c.expected {
+			t.Errorf("format %d of statement should be\n%q\nbut it's\n%q", c.format, c.expected, actual)
+		}
+	}
+
+	if actual := formatStatement("UPDATE offsets SET fromage = 1", StatementPretty); actual != "UPDATE offsets\nSET fromage = 1" {
+		t.Errorf("keywords should match whole words only but statement is %q", actual)
+	}
+}
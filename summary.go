@@ -0,0 +1,96 @@
+package otgorm
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// summaryEntry aggregates queries of the table and operation for WithRequestSummary
+type summaryEntry struct {
+	operation string
+	table     string
+	count     int64
+	total     time.Duration
+}
+
+// addSummary adds the query to the summary of the parent span
+func (c *callbacks) addSummary(scope *gorm.Scope, operation string, start time.Time, duration time.Duration, cfg *config) {
+	stats, ok := parentStatsFromScope(scope)
+	if !ok {
+		return
+	}
+	table := scope.TableName()
+	key := operation + " " + table
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	if stats.summary == nil {
+		stats.summary = make(map[string]*summaryEntry)
+		stats.summaryStart = start
+	}
+	entry, ok := stats.summary[key]
+	if !ok {
+		entry = &summaryEntry{operation: operation, table: table}
+		stats.summary[key] = entry
+	}
+	entry.count++
+	entry.total += duration
+}
+
+// Summarize finishes "db.summary" child of the span set to db by SetSpanToGorm, which aggregates its queries
+// traced with WithRequestSummary: the span has db.query_count and db.total_ms tags and a log per table
+// and operation sorted by total time, it's started by the tracer of WithTracer or WithGlobalTracer if they are used.
+// Call it before finishing the span, e.g. Summarize(FromContext(ctx)), the middlewares and StartJobSpan call it.
+// Queries summarized once are not summarized again. It also finishes the span of repeated statements
+// of WithRepeatCompression
+func Summarize(db *gorm.DB) {
+	val, ok := db.Get(ParentStatsGormKey)
+	if !ok {
+		return
+	}
+	stats, ok := val.(*parentStats)
+	if !ok || stats == nil {
+		return
+	}
+	c := callbacksFromGorm(db)
+	c.flushRepeat(stats, nil)
+	stats.mu.Lock()
+	summary, start := stats.summary, stats.summaryStart
+	stats.summary = nil
+	stats.mu.Unlock()
+	if len(summary) == 0 {
+		return
+	}
+
+	entries := make([]*summaryEntry, 0, len(summary))
+	var count int64
+	var total time.Duration
+	for _, entry := range summary {
+		entries = append(entries, entry)
+		count += entry.count
+		total += entry.total
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].total != entries[j].total {
+			return entries[i].total > entries[j].total
+		}
+		return entries[i].operation+entries[i].table < entries[j].operation+entries[j].table
+	})
+
+	sp := c.config().spanTracer(stats.span.Tracer()).StartSpan("db.summary", opentracing.ChildOf(stats.span.Context()), opentracing.StartTime(start))
+	sp.SetTag("db.query_count", count)
+	sp.SetTag("db.total_ms", float64(total)/float64(time.Millisecond))
+	for _, entry := range entries {
+		sp.LogFields(
+			log.String("event", "db.summary"),
+			log.String("db.method", entry.operation),
+			log.String("db.table", entry.table),
+			log.Int64("db.query_count", entry.count),
+			log.Float64("db.total_ms", float64(entry.total)/float64(time.Millisecond)),
+		)
+	}
+	sp.Finish()
+}
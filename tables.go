@@ -0,0 +1,78 @@
+package otgorm
+
+import (
+	"regexp"
+	"strings"
+)
+
+// identifier is a plain or quoted part of schema qualified table name
+const identifier = "(?:\"[^\"]+\"|`[^`]+`|\\[[^\\]]+\\]|\\w+)"
+
+var (
+	joinRegexp       = regexp.MustCompile(`(?i)\bJOIN\b`)
+	identifierRegexp = regexp.MustCompile(identifier)
+	// tableRegexp matches tables of FROM, JOIN and INTO clauses and the target of UPDATE statement
+	tableRegexp = regexp.MustCompile(`(?i)(?:\b(?:FROM|JOIN|INTO)|^\s*UPDATE)\s+(` + identifier + `(?:\s*\.\s*` + identifier + `)*)`)
+)
+
+// parseJoinedTables returns all tables referenced by FROM and JOIN clauses of query with joins,
+// scope.TableName() returns only the primary table
+func parseJoinedTables(query string) ([]string, bool) {
+	if !joinRegexp.MatchString(query) {
+		return nil, false
+	}
+	tables := parseTables(query)
+	return tables, len(tables) > 0
+}
+
+// parseTables returns all tables referenced by FROM, JOIN and INTO clauses and the target of UPDATE statement,
+// quotes of schema qualified tables are stripped, e.g. "billing"."users" is billing.users
+func parseTables(query string) []string {
+	var tables []string
+	seen := map[string]bool{}
+	for _, match := range tableRegexp.FindAllStringSubmatch(query, -1) {
+		table := unquoteTable(match[1])
+		if !seen[table] {
+			seen[table] = true
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+// statementCapture returns the most restrictive capture of the tables touched by the query
+func (c *callbacks) statementCapture(table string, query string, cfg *config) Capture {
+	if cfg.tableCapture == nil {
+		return cfg.defaultCapture
+	}
+	capture := c.tableCapture(table, cfg)
+	for _, table := range parseTables(query) {
+		if tc := c.tableCapture(table, cfg); tc > capture {
+			capture = tc
+		}
+	}
+	return capture
+}
+
+// tableCapture returns capture of the table, schema qualified table falls back to capture of the table without schema
+func (c *callbacks) tableCapture(table string, cfg *config) Capture {
+	table = unquoteTable(table)
+	if capture, ok := cfg.tableCapture[table]; ok {
+		return capture
+	}
+	if i := strings.LastIndexByte(table, '.'); i >= 0 {
+		if capture, ok := cfg.tableCapture[table[i+1:]]; ok {
+			return capture
+		}
+	}
+	return cfg.defaultCapture
+}
+
+// unquoteTable strips quotes and spaces of parts of schema qualified table name
+func unquoteTable(table string) string {
+	parts := identifierRegexp.FindAllString(table, -1)
+	for i, part := range parts {
+		parts[i] = strings.Trim(part, "\"`[]")
+	}
+	return strings.Join(parts, ".")
+}
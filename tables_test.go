@@ -0,0 +1,57 @@
+package otgorm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseJoinedTables(t *testing.T) {
+	cases := []struct {
+		query  string
+		tables string
+	}{
+		{query: `SELECT * FROM "users" WHERE (id = 1)`, tables: ""},
+		{
+			query:  `SELECT "users".* FROM "users" left join "emails" on emails.user_id = users.id JOIN orders ON orders.user_id = users.id`,
+			tables: "users,emails,orders",
+		},
+		{
+			query:  "SELECT * FROM `users` INNER JOIN `users` AS managers ON managers.id = users.manager_id",
+			tables: "users",
+		},
+	}
+
+	for _, c := range cases {
+		tables, _ := parseJoinedTables(c.query)
+		if actual := strings.Join(tables, ","); actual != c.tables {
+			t.Errorf("parseJoinedTables(%q) should be %q but it's %q", c.query, c.tables, actual)
+		}
+	}
+}
+
+func TestStatementCapture(t *testing.T) {
+	c := newCallbacks(WithTableCapture(CaptureNone, "users"), WithTableCapture(CapturePlaceholders, "billing.invoices"))
+	cases := []struct {
+		query    string
+		expected Capture
+	}{
+		{query: `SELECT * FROM "products"`, expected: CaptureFull},
+		{query: `SELECT * FROM "products" JOIN "users" ON users.id = products.user_id`, expected: CaptureNone},
+		{query: `INSERT INTO "users" ("email") VALUES ('john@example.com')`, expected: CaptureNone},
+		{query: `UPDATE users SET email = 'john@example.com'`, expected: CaptureNone},
+		{query: `DELETE FROM "public"."users" WHERE id = 1`, expected: CaptureNone},
+		{query: "UPDATE `app` . `users` SET email = 'john@example.com'", expected: CaptureNone},
+		{query: `INSERT INTO [dbo].[users] (email) VALUES ('john@example.com')`, expected: CaptureNone},
+		{query: `UPDATE "billing"."invoices" SET total = 1`, expected: CapturePlaceholders},
+		{query: `UPDATE "archive"."invoices" SET total = 1`, expected: CaptureFull},
+		{query: `INSERT INTO products (code) VALUES ('L1212') ON DUPLICATE KEY UPDATE code = 'L1212'`, expected: CaptureFull},
+	}
+	for _, tc := range cases {
+		if capture := c.statementCapture("", tc.query, c.config()); capture != tc.expected {
+			t.Errorf("capture of %q should be %v but it's %v", tc.query, tc.expected, capture)
+		}
+	}
+	if capture := c.statementCapture(`"public"."users"`, "", c.config()); capture != CaptureNone {
+		t.Errorf("capture of schema qualified table should be CaptureNone but it's %v", capture)
+	}
+}
@@ -0,0 +1,108 @@
+package otgorm
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// setStatementTimeout applies statement timeout derived from ctx deadline to the operation
+func (c *callbacks) setStatementTimeout(scope *gorm.Scope, ctx context.Context, sp opentracing.Span, operation string, cfg *config) {
+	timeout, ok := deadlineTimeout(ctx, time.Now(), cfg.statementTimeoutFloor, cfg.statementTimeoutCeiling)
+	if !ok {
+		return
+	}
+
+	if scope.Dialect().GetName() != "postgres" {
+		return
+	}
+	// SET LOCAL lasts until the end of the transaction, gorm runs create, update and delete in one,
+	// SELECT only in the transaction of the application
+	tx, ok := scope.SQLDB().(*sql.Tx)
+	if !ok {
+		return
+	}
+	if err := setLocalStatementTimeout(tx, timeout); err != nil {
+		sp.LogFields(log.String("event", "statement timeout failed"), log.Error(err))
+		c.status.reportError("statement timeout", err)
+		return
+	}
+	sp.SetTag("db.statement_timeout_ms", int64(timeout/time.Millisecond))
+}
+
+// txExecer is sql.Tx
+type txExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// setLocalStatementTimeout sets statement_timeout of the transaction inside a savepoint,
+// so its failure doesn't abort the transaction of the application
+func setLocalStatementTimeout(tx txExecer, timeout time.Duration) error {
+	if _, err := tx.Exec("SAVEPOINT otgorm_statement_timeout"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout/time.Millisecond)); err != nil {
+		if _, rollbackErr := tx.Exec("ROLLBACK TO SAVEPOINT otgorm_statement_timeout"); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+	_, err := tx.Exec("RELEASE SAVEPOINT otgorm_statement_timeout")
+	return err
+}
+
+var maxExecutionTimeRegexp = regexp.MustCompile(`/\*\+ MAX_EXECUTION_TIME\((\d+)\) \*/`)
+
+// setMaxExecutionTime adds MAX_EXECUTION_TIME hint derived from ctx deadline to SELECT
+func (c *callbacks) setMaxExecutionTime(scope *gorm.Scope, ctx context.Context, cfg *config) {
+	timeout, ok := deadlineTimeout(ctx, time.Now(), cfg.maxExecutionTimeFloor, cfg.maxExecutionTimeCeiling)
+	if !ok {
+		return
+	}
+
+	// the hint must follow SELECT keyword, gorm builds it from selected columns.
+	// Custom selects can't be read back from the scope, so they are left as is
+	if scope.Dialect().GetName() != "mysql" || len(scope.SelectAttrs()) > 0 {
+		return
+	}
+	// table.* is what gorm selects for queries with joins and it's the same as * otherwise
+	scope.Search.Select(fmt.Sprintf("/*+ MAX_EXECUTION_TIME(%d) */ %v.*", timeout/time.Millisecond, scope.QuotedTableName()))
+}
+
+// parseMaxExecutionTime returns value of MAX_EXECUTION_TIME hint of query
+func parseMaxExecutionTime(query string) (int64, bool) {
+	match := maxExecutionTimeRegexp.FindStringSubmatch(query)
+	if match == nil {
+		return 0, false
+	}
+	ms, err := strconv.ParseInt(match[1], 10, 64)
+	return ms, err == nil
+}
+
+// deadlineTimeout returns time remaining until ctx deadline clamped to [floor, ceiling],
+// returns false if ctx has no deadline
+func deadlineTimeout(ctx context.Context, now time.Time, floor, ceiling time.Duration) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	timeout := deadline.Sub(now)
+	if timeout < floor {
+		timeout = floor
+	}
+	if ceiling > 0 && timeout > ceiling {
+		timeout = ceiling
+	}
+	// zero disables statement timeout in postgres
+	if timeout < time.Millisecond {
+		timeout = time.Millisecond
+	}
+	return timeout, true
+}
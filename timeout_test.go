@@ -0,0 +1,70 @@
+package otgorm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeadlineTimeout(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		remaining time.Duration
+		expected  time.Duration
+	}{
+		{remaining: time.Second, expected: time.Second},
+		{remaining: 10 * time.Millisecond, expected: 100 * time.Millisecond},
+		{remaining: -time.Second, expected: 100 * time.Millisecond},
+		{remaining: time.Hour, expected: time.Minute},
+	}
+
+	for _, c := range cases {
+		ctx, cancel := context.WithDeadline(context.Background(), now.Add(c.remaining))
+		timeout, ok := deadlineTimeout(ctx, now, 100*time.Millisecond, time.Minute)
+		cancel()
+		if !ok || timeout != c.expected {
+			t.Errorf("timeout for %v remaining should be %v but it's %v", c.remaining, c.expected, timeout)
+		}
+	}
+
+	if _, ok := deadlineTimeout(context.Background(), now, 0, 0); ok {
+		t.Errorf("context without deadline shouldn't have timeout")
+	}
+}
+
+func TestParseMaxExecutionTime(t *testing.T) {
+	ms, ok := parseMaxExecutionTime("SELECT /*+ MAX_EXECUTION_TIME(1500) */ `users`.* FROM `users`")
+	if !ok || ms != 1500 {
+		t.Errorf("max execution time should be 1500 but it's %v", ms)
+	}
+	if _, ok := parseMaxExecutionTime("SELECT * FROM `users`"); ok {
+		t.Errorf("query without hint shouldn't have max execution time")
+	}
+}
+
+// failingExecer records statements and fails SET statements
+type failingExecer struct {
+	statements []string
+}
+
+func (e *failingExecer) Exec(query string, args ...interface{}) (sql.Result, error) {
+	e.statements = append(e.statements, query)
+	if strings.HasPrefix(query, "SET") {
+		return nil, errors.New("permission denied to set parameter")
+	}
+	return nil, nil
+}
+
+func TestSetLocalStatementTimeoutFailure(t *testing.T) {
+	tx := &failingExecer{}
+	if err := setLocalStatementTimeout(tx, time.Second); err == nil {
+		t.Error("failed SET LOCAL should be reported")
+	}
+	expected := "SAVEPOINT otgorm_statement_timeout,SET LOCAL statement_timeout = 1000,ROLLBACK TO SAVEPOINT otgorm_statement_timeout"
+	if got := strings.Join(tx.statements, ","); got != expected {
+		t.Errorf("failed SET LOCAL should be rolled back to the savepoint but statements are %q", got)
+	}
+}
@@ -0,0 +1,118 @@
+package otgorm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// callbackTimingsGormKey holds timestamps of gorm callbacks of the operation with WithCallbackTimings
+const callbackTimingsGormKey = "opentracingCallbackTimings"
+
+// callbackSteps are gorm callbacks timed by WithCallbackTimings, the last step of each chain is finished
+// by the operation span
+var callbackSteps = map[string][]string{
+	"create": {
+		"gorm:begin_transaction", "gorm:before_create", "gorm:save_before_associations", "gorm:update_time_stamp",
+		"gorm:create", "gorm:force_reload_after_create", "gorm:save_after_associations", "gorm:after_create",
+		"gorm:commit_or_rollback_transaction",
+	},
+	"query": {"gorm:query", "gorm:preload", "gorm:after_query"},
+	"update": {
+		"gorm:assign_updating_attributes", "gorm:begin_transaction", "gorm:before_update",
+		"gorm:save_before_associations", "gorm:update_time_stamp", "gorm:update", "gorm:save_after_associations",
+		"gorm:after_update", "gorm:commit_or_rollback_transaction",
+	},
+	"delete": {
+		"gorm:begin_transaction", "gorm:before_delete", "gorm:delete", "gorm:after_delete",
+		"gorm:commit_or_rollback_transaction",
+	},
+}
+
+// callbackMark is the end time of gorm callback
+type callbackMark struct {
+	step string
+	end  time.Time
+}
+
+// callbackTimings are end times of gorm callbacks of the operation
+type callbackTimings struct {
+	start time.Time
+	// last is the callback finished by the operation span
+	last  string
+	marks []callbackMark
+}
+
+// registerTimingCallbacks registers callbacks marking the end of each gorm callback except the last one,
+// which ends with the operation
+func registerTimingCallbacks(db *gorm.DB, name string, c *callbacks) {
+	steps := callbackSteps[name]
+	for _, step := range steps[:len(steps)-1] {
+		step := step
+		mark := func(scope *gorm.Scope) { c.markCallback(scope, step) }
+		timingName := fmt.Sprintf("tracing:%v_timing", step[len("gorm:"):])
+		switch name {
+		case "create":
+			db.Callback().Create().After(step).Register(timingName, mark)
+		case "query":
+			db.Callback().Query().After(step).Register(timingName, mark)
+		case "update":
+			db.Callback().Update().After(step).Register(timingName, mark)
+		case "delete":
+			db.Callback().Delete().After(step).Register(timingName, mark)
+		}
+	}
+}
+
+// startCallbackTimings starts timing of gorm callbacks of the operation
+func (c *callbacks) startCallbackTimings(scope *gorm.Scope, name string) {
+	if !c.config().callbackTimings {
+		return
+	}
+	steps := callbackSteps[name]
+	scope.Set(callbackTimingsGormKey, &callbackTimings{start: time.Now(), last: steps[len(steps)-1]})
+}
+
+func (c *callbacks) markCallback(scope *gorm.Scope, step string) {
+	val, ok := scope.Get(callbackTimingsGormKey)
+	if !ok {
+		return
+	}
+	t, ok := val.(*callbackTimings)
+	if !ok {
+		return
+	}
+	t.marks = append(t.marks, callbackMark{step: step, end: time.Now()})
+}
+
+// finishCallbackTimings returns span logs of timed callbacks, one log per callback at its start
+// with the callback name as event and its duration as duration_ms
+func (c *callbacks) finishCallbackTimings(scope *gorm.Scope, finish time.Time) []opentracing.LogRecord {
+	val, ok := scope.Get(callbackTimingsGormKey)
+	if !ok {
+		return nil
+	}
+	// nested operations cloned from this scope start their own timings
+	scope.Set(callbackTimingsGormKey, nil)
+	t, ok := val.(*callbackTimings)
+	if !ok {
+		return nil
+	}
+	marks := append(t.marks, callbackMark{step: t.last, end: finish})
+	records := make([]opentracing.LogRecord, 0, len(marks))
+	start := t.start
+	for _, m := range marks {
+		records = append(records, opentracing.LogRecord{
+			Timestamp: start,
+			Fields: []log.Field{
+				log.String("event", m.step),
+				log.Float64("duration_ms", float64(m.end.Sub(start))/float64(time.Millisecond)),
+			},
+		})
+		start = m.end
+	}
+	return records
+}
@@ -0,0 +1,143 @@
+package otgorm
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// assignment is column = value of SET clause of UPDATE, value is known if it's bound to a placeholder
+type assignment struct {
+	column   string
+	value    interface{}
+	hasValue bool
+}
+
+// updateEndKeywords end SET clause of UPDATE
+var updateEndKeywords = []string{"WHERE", "FROM", "RETURNING", "ORDER", "LIMIT"}
+
+// updateAssignments returns assignments of SET clause of UPDATE statement
+func updateAssignments(query string, vars []interface{}) []assignment {
+	style := placeholderStyle(query)
+	var assignments []assignment
+	// count is the number of ? placeholders before i, partCount before the current assignment
+	count, partCount := 0, 0
+	partStart := -1
+	flush := func(end int) {
+		part := query[partStart:end]
+		eq := strings.IndexByte(part, '=')
+		if eq < 0 {
+			return
+		}
+		a := assignment{column: unquoteIdent(strings.TrimSpace(part[:eq]))}
+		expr := strings.TrimSpace(part[eq+1:])
+		if expr != "" {
+			if n, end, ok := parsePlaceholder(expr, 0, style, partCount); ok && end == len(expr) && n <= len(vars) {
+				a.value, a.hasValue = vars[n-1], true
+			}
+		}
+		assignments = append(assignments, a)
+	}
+
+	var quote byte
+	depth := 0
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == '?':
+			count++
+		case depth > 0:
+		case c == ',' && partStart >= 0:
+			flush(i)
+			partStart, partCount = i+1, count
+		case i > 0 && isIdentChar(query[i-1]):
+			// keywords start at word boundaries
+		case partStart < 0 && hasKeyword(query, i, "SET"):
+			partStart, partCount = i+len("SET"), count
+			i += len("SET") - 1
+		case partStart >= 0:
+			for _, keyword := range updateEndKeywords {
+				if hasKeyword(query, i, keyword) {
+					flush(i)
+					return assignments
+				}
+			}
+		}
+	}
+	if partStart >= 0 {
+		flush(len(query))
+	}
+	return assignments
+}
+
+// updatedColumns returns columns updated by the scope, from update attrs of gorm Update and Updates
+// or from SET clause of the statement, e.g. of Save
+func updatedColumns(scope *gorm.Scope) []string {
+	if attrs, ok := scope.InstanceGet("gorm:update_attrs"); ok {
+		if attrs, ok := attrs.(map[string]interface{}); ok {
+			columns := make([]string, 0, len(attrs))
+			for column := range attrs {
+				columns = append(columns, column)
+			}
+			sort.Strings(columns)
+			return columns
+		}
+	}
+	assignments := updateAssignments(scope.SQL, nil)
+	columns := make([]string, len(assignments))
+	for i, a := range assignments {
+		columns[i] = a.column
+	}
+	return columns
+}
+
+// hasKeyword reports whether keyword is at i of query followed by a word boundary
+func hasKeyword(query string, i int, keyword string) bool {
+	end := i + len(keyword)
+	return end <= len(query) && strings.EqualFold(query[i:end], keyword) && (end == len(query) || !isIdentChar(query[end]))
+}
+
+// logUpdate logs columns and values of UPDATE of the tables of WithUpdateCapture
+func (c *callbacks) logUpdate(sp opentracing.Span, table, query string, vars []interface{}, cfg *config) {
+	capture, ok := cfg.updateCapture[table]
+	if !ok {
+		return
+	}
+	assignments := updateAssignments(query, vars)
+	if len(assignments) == 0 {
+		return
+	}
+	columns := make([]string, len(assignments))
+	for i, a := range assignments {
+		columns[i] = a.column
+	}
+	fields := []log.Field{log.String("event", "update"), log.String("db.columns", strings.Join(columns, ","))}
+	if capture && !cfg.noValues {
+		opts := cfg.options
+		for _, a := range assignments {
+			switch {
+			case !a.hasValue:
+			case opts.hasAllowlist() && !opts.valueAllowed(a.column, 0):
+				fields = append(fields, log.String("db.value."+a.column, "?"))
+			default:
+				fields = append(fields, log.String("db.value."+a.column, formatValue(a.value, opts)))
+			}
+		}
+	}
+	sp.LogFields(fields...)
+}
@@ -0,0 +1,49 @@
+package otgorm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUpdateAssignments(t *testing.T) {
+	cases := []struct {
+		query    string
+		vars     []interface{}
+		expected []assignment
+	}{
+		{
+			query: `UPDATE "products" SET "code" = $1, "updated_at" = $2  WHERE "products"."deleted_at" IS NULL AND "id" = $3`,
+			vars:  []interface{}{"L2", "now", 1},
+			expected: []assignment{
+				{column: "code", value: "L2", hasValue: true},
+				{column: "updated_at", value: "now", hasValue: true},
+			},
+		},
+		{
+			query: "UPDATE `accounts` SET `balance` = balance - ?, `note` = 'a, b', `tags` = ? WHERE (id = ?)",
+			vars:  []interface{}{10, "x", 1},
+			expected: []assignment{
+				{column: "balance"},
+				{column: "note"},
+				{column: "tags", value: "x", hasValue: true},
+			},
+		},
+		{
+			query: `UPDATE t SET settings = ?, offset_x = coalesce(?, 0) RETURNING id`,
+			vars:  []interface{}{"s", 2},
+			expected: []assignment{
+				{column: "settings", value: "s", hasValue: true},
+				{column: "offset_x"},
+			},
+		},
+		{
+			query:    `SELECT 1`,
+			expected: nil,
+		},
+	}
+	for _, c := range cases {
+		if actual := updateAssignments(c.query, c.vars); !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("updateAssignments(%q) should be %+v but it's %+v", c.query, c.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package otgorm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// interpolatedDialects are dialects which placeholders are interpolated into db.statement
+var interpolatedDialects = map[string]bool{
+	"postgres":   true,
+	"mysql":      true,
+	"sqlite3":    true,
+	"mssql":      true,
+	"clickhouse": true,
+}
+
+// VerifyCheck is a check of Verify
+type VerifyCheck struct {
+	Name    string
+	OK      bool
+	Message string
+}
+
+// VerifyReport is the result of Verify
+type VerifyReport struct {
+	Checks []VerifyCheck
+}
+
+// OK reports whether all checks passed
+func (r VerifyReport) OK() bool {
+	for _, check := range r.Checks {
+		if !check.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns one line per check, failed checks are marked with FAIL
+func (r VerifyReport) String() string {
+	var b strings.Builder
+	for _, check := range r.Checks {
+		status := "ok"
+		if !check.OK {
+			status = "FAIL"
+		}
+		fmt.Fprintf(&b, "%-4s %s: %s\n", status, check.Name, check.Message)
+	}
+	return b.String()
+}
+
+func (r *VerifyReport) add(name string, ok bool, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, VerifyCheck{Name: name, OK: ok, Message: fmt.Sprintf(format, args...)})
+}
+
+// Verify checks the instrumentation of db to debug silent misconfiguration: callbacks are added, gorm callbacks
+// they trace are registered, a tracer is available and the dialect is interpolated. With probe it runs SELECT 1
+// and checks a span is started for it and the tracing callbacks run after the query is built. The span is reported
+// to the tracer of WithTracer or WithGlobalTracer if they are used
+func Verify(db *gorm.DB, probe bool) VerifyReport {
+	var report VerifyReport
+	val, ok := db.Get(CallbacksGormKey)
+	c, _ := val.(*callbacks)
+	if !ok || c == nil {
+		report.add("callbacks", false, "callbacks aren't added to db, call AddGormCallbacks")
+		return report
+	}
+
+	var missing []string
+	for _, p := range debugCallbacks {
+		names := p.names
+		if c.hasOperationCallbacks() {
+			names = append(names[:len(names):len(names)], p.operation...)
+		}
+		for _, name := range names {
+			if processor(db, p.processor).Get(name) == nil {
+				missing = append(missing, p.processor+"/"+name)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		report.add("callbacks", false, "callbacks are removed: %s", strings.Join(missing, ", "))
+	} else {
+		report.add("callbacks", true, "all callbacks are registered")
+	}
+
+	for _, p := range debugCallbacks {
+		report.Checks = append(report.Checks, verifyGormCallback(db, p.processor))
+	}
+
+	switch {
+	case c.config().tracer != nil:
+		report.add("tracer", true, "spans are started by the tracer of WithTracer")
+	case opentracing.IsGlobalTracerRegistered():
+		report.add("tracer", true, "global tracer is registered")
+	case c.config().globalTracer:
+		report.add("tracer", false, "WithGlobalTracer is used, but global tracer isn't registered")
+	default:
+		report.add("tracer", false, "global tracer isn't registered, spans of SetSpanContextToGorm "+
+			"and spans started from context by opentracing.StartSpanFromContext are not recorded")
+	}
+
+	dialect := db.Dialect().GetName()
+	if interpolatedDialects[dialect] {
+		report.add("dialect", true, "%s statements are interpolated", dialect)
+	} else {
+		report.add("dialect", false, "%s isn't known, statements may be interpolated with wrong placeholders", dialect)
+	}
+
+	if probe {
+		report.Checks = append(report.Checks, verifyProbe(db, c)...)
+	}
+	return report
+}
+
+// verifyGormCallback checks the gorm callback which queries of the processor are traced around is registered
+func verifyGormCallback(db *gorm.DB, kind string) VerifyCheck {
+	name := "gorm/" + kind
+	gormCallback := "gorm:" + kind
+	if processor(db, kind).Get(gormCallback) == nil {
+		return VerifyCheck{Name: name, OK: false, Message: gormCallback + " is removed, its queries aren't traced"}
+	}
+	return VerifyCheck{Name: name, OK: true, Message: gormCallback + " is registered"}
+}
+
+// verifyProbe runs SELECT 1 with a span of verifyTracer and checks the callbacks started a span for it.
+// gorm doesn't expose the order of callbacks, the statement of the span recorded by verifyTracer
+// shows tracing:row_query_after runs after gorm:row_query builds it
+func verifyProbe(db *gorm.DB, c *callbacks) []VerifyCheck {
+	spans := func() int64 {
+		var total int64
+		c.status.spans.Range(func(_, val interface{}) bool {
+			total += atomic.LoadInt64(val.(*int64))
+			return true
+		})
+		return total
+	}
+	before := spans()
+	tr := &verifyTracer{}
+	span := tr.StartSpan("otgorm:verify")
+	var one int
+	err := SetSpanToGorm(opentracing.ContextWithSpan(context.Background(), span), db).Raw("SELECT 1").Row().Scan(&one)
+	span.Finish()
+	switch {
+	case err != nil:
+		return []VerifyCheck{{Name: "probe", OK: false, Message: "SELECT 1 failed: " + redactedError(err, c.config().options).Error()}}
+	case spans() == before:
+		return []VerifyCheck{{Name: "probe", OK: false, Message: "no span was started for SELECT 1, check Disable, WithEnabledFunc, WithSpanBudget and WithDuplicateMode"}}
+	}
+	checks := []VerifyCheck{{Name: "probe", OK: true, Message: "span was started for SELECT 1"}}
+
+	sqlSpan := tr.span("sql")
+	switch {
+	case sqlSpan == nil:
+		checks = append(checks, VerifyCheck{Name: "order", OK: true, Message: "span was started by another tracer, order isn't checked"})
+	case !c.config().has(TagStatement) || c.config().defaultCapture == CaptureNone:
+		checks = append(checks, VerifyCheck{Name: "order", OK: true, Message: "statements aren't captured, order isn't checked"})
+	case !strings.Contains(fmt.Sprint(sqlSpan.tag("db.statement")), "SELECT 1"):
+		checks = append(checks, VerifyCheck{Name: "order", OK: false, Message: "span has no statement, tracing callbacks don't run after gorm:row_query"})
+	default:
+		checks = append(checks, VerifyCheck{Name: "order", OK: true, Message: "tracing callbacks run after gorm:row_query"})
+	}
+	return checks
+}
+
+// verifyTracer records spans of the probe of Verify
+type verifyTracer struct {
+	mu    sync.Mutex
+	spans []*verifySpan
+}
+
+func (t *verifyTracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	sp := &verifySpan{tracer: t, name: operationName, tags: make(map[string]interface{})}
+	t.mu.Lock()
+	t.spans = append(t.spans, sp)
+	t.mu.Unlock()
+	return sp
+}
+
+func (t *verifyTracer) Inject(sm opentracing.SpanContext, format interface{}, carrier interface{}) error {
+	return opentracing.ErrUnsupportedFormat
+}
+
+func (t *verifyTracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
+	return nil, opentracing.ErrSpanContextNotFound
+}
+
+// span returns the first span named name
+func (t *verifyTracer) span(name string) *verifySpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, sp := range t.spans {
+		if sp.operationName() == name {
+			return sp
+		}
+	}
+	return nil
+}
+
+type verifySpan struct {
+	tracer *verifyTracer
+
+	mu   sync.Mutex
+	name string
+	tags map[string]interface{}
+}
+
+type verifySpanContext struct{}
+
+func (verifySpanContext) ForeachBaggageItem(handler func(k, v string) bool) {}
+
+func (s *verifySpan) operationName() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.name
+}
+
+func (s *verifySpan) tag(key string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tags[key]
+}
+
+func (s *verifySpan) Finish()                                          {}
+func (s *verifySpan) FinishWithOptions(opts opentracing.FinishOptions) {}
+func (s *verifySpan) Context() opentracing.SpanContext                 { return verifySpanContext{} }
+func (s *verifySpan) Tracer() opentracing.Tracer                       { return s.tracer }
+
+func (s *verifySpan) SetOperationName(operationName string) opentracing.Span {
+	s.mu.Lock()
+	s.name = operationName
+	s.mu.Unlock()
+	return s
+}
+
+func (s *verifySpan) SetTag(key string, value interface{}) opentracing.Span {
+	s.mu.Lock()
+	s.tags[key] = value
+	s.mu.Unlock()
+	return s
+}
+
+func (s *verifySpan) LogFields(fields ...log.Field)                               {}
+func (s *verifySpan) LogKV(alternatingKeyValues ...interface{})                   {}
+func (s *verifySpan) SetBaggageItem(restrictedKey, value string) opentracing.Span { return s }
+func (s *verifySpan) BaggageItem(restrictedKey string) string                     { return "" }
+func (s *verifySpan) LogEvent(event string)                                       {}
+func (s *verifySpan) LogEventWithPayload(event string, payload interface{})       {}
+func (s *verifySpan) Log(data opentracing.LogData)                                {}
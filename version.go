@@ -0,0 +1,94 @@
+package otgorm
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// dialectDBTypes are db.type of dialects which aren't reported as the generic sql db.type
+var dialectDBTypes = map[string]string{
+	"clickhouse": "clickhouse",
+}
+
+// dialectDBType returns db.type of the dialect, it's sql as in the OpenTracing spec unless the dialect is in dialectDBTypes
+func dialectDBType(dialect string) string {
+	if dbType, ok := dialectDBTypes[dialect]; ok {
+		return dbType
+	}
+	return "sql"
+}
+
+// detectDBType returns db.type of the dialect and server version, CockroachDB is detected behind the postgres dialect
+// by its version
+func detectDBType(dialect, version string) string {
+	if dialect == "postgres" && strings.Contains(version, "CockroachDB") {
+		return "cockroachdb"
+	}
+	return dialectDBType(dialect)
+}
+
+// dbType returns db.type and server version of the scope, version is empty unless WithServerVersion is used
+func (c *callbacks) dbType(scope *gorm.Scope, cfg *config) (string, string) {
+	name := scope.Dialect().GetName()
+	if !cfg.serverVersion {
+		return dialectDBType(name), ""
+	}
+	version, _ := c.serverVersion(scope)
+	return detectDBType(name, version), version
+}
+
+// instance returns db.instance of the scope: name of the current database queried once per db outside of transactions
+// or gorm instance id with WithInstanceID. It's empty until the name is queried
+func (c *callbacks) instance(scope *gorm.Scope, cfg *config) string {
+	if cfg.instanceID {
+		return scope.InstanceID()
+	}
+	dialect := scope.Dialect()
+	if val, ok := c.databases.Load(dialect); ok {
+		return val.(string)
+	}
+	if _, ok := scope.DB().CommonDB().(*sql.DB); !ok {
+		return ""
+	}
+	name := dialect.CurrentDatabase()
+	c.databases.Store(dialect, name)
+	return name
+}
+
+// versionQueries are queries of server version by dialect
+var versionQueries = map[string]string{
+	"postgres":   "SELECT version()",
+	"mysql":      "SELECT @@version",
+	"mssql":      "SELECT @@VERSION",
+	"sqlite3":    "SELECT sqlite_version()",
+	"clickhouse": "SELECT version()",
+}
+
+// serverVersion returns version of the database server, it's queried once per db and cached.
+// The version isn't queried inside transactions, so it doesn't interfere with them
+func (c *callbacks) serverVersion(scope *gorm.Scope) (string, bool) {
+	dialect := scope.Dialect()
+	if val, ok := c.versions.Load(dialect); ok {
+		version := val.(string)
+		return version, version != ""
+	}
+	query, ok := versionQueries[dialect.GetName()]
+	if !ok {
+		return "", false
+	}
+	sqlDB, ok := scope.DB().CommonDB().(*sql.DB)
+	if !ok {
+		return "", false
+	}
+
+	var version string
+	if err := sqlDB.QueryRow(query).Scan(&version); err != nil {
+		// don't retry failed query on every statement
+		c.status.reportError("server version", err)
+		version = ""
+	}
+	c.versions.Store(dialect, version)
+	return version, version != ""
+}
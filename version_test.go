@@ -0,0 +1,25 @@
+package otgorm
+
+import "testing"
+
+func TestDetectDBType(t *testing.T) {
+	cases := []struct {
+		dialect  string
+		version  string
+		expected string
+	}{
+		{dialect: "sqlite3", version: "3.31.1", expected: "sql"},
+		{dialect: "mysql", version: "8.0.21", expected: "sql"},
+		{dialect: "postgres", version: "PostgreSQL 12.4 on x86_64-pc-linux-gnu", expected: "sql"},
+		{dialect: "postgres", expected: "sql"},
+		{dialect: "postgres", version: "CockroachDB CCL v20.1.5 (x86_64-unknown-linux-gnu, built 2020/08/24 19:52:08, go1.13.9)", expected: "cockroachdb"},
+		{dialect: "mysql", version: "CockroachDB CCL v20.1.5", expected: "sql"},
+		{dialect: "clickhouse", version: "20.8.2.3", expected: "clickhouse"},
+		{dialect: "clickhouse", expected: "clickhouse"},
+	}
+	for _, c := range cases {
+		if dbType := detectDBType(c.dialect, c.version); dbType != c.expected {
+			t.Errorf("db.type of %s %q should be %s but it's %s", c.dialect, c.version, c.expected, dbType)
+		}
+	}
+}
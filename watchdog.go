@@ -0,0 +1,125 @@
+package otgorm
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// watchdogBuckets is the number of buckets of the sliding window of WithErrorRateWatchdog
+const watchdogBuckets = 10
+
+// ErrorRateAlert is reported by WithErrorRateWatchdog when error rate of the table and operation
+// crosses the threshold and when it falls back below it
+type ErrorRateAlert struct {
+	Table     string        `json:"table"`
+	Operation string        `json:"operation"`
+	Errors    int           `json:"errors"`
+	Queries   int           `json:"queries"`
+	Rate      float64       `json:"rate"`
+	Window    time.Duration `json:"window"`
+	// Recovered is true when the rate fell back below the threshold
+	Recovered bool `json:"recovered"`
+}
+
+// errorWatchdog tracks error rates per table and operation in a sliding window
+type errorWatchdog struct {
+	window     time.Duration
+	threshold  float64
+	minQueries int
+	hook       func(ErrorRateAlert)
+
+	mu      sync.Mutex
+	windows map[budgetKey]*errorWindow
+}
+
+// errorWindow is the ring of buckets of window/watchdogBuckets each
+type errorWindow struct {
+	buckets  [watchdogBuckets]errorBucket
+	alerting bool
+}
+
+type errorBucket struct {
+	index   int64
+	queries int
+	errors  int
+}
+
+func newErrorWatchdog(window time.Duration, threshold float64, minQueries int, hook func(ErrorRateAlert)) *errorWatchdog {
+	return &errorWatchdog{
+		window:     window,
+		threshold:  threshold,
+		minQueries: minQueries,
+		hook:       hook,
+		windows:    make(map[budgetKey]*errorWindow),
+	}
+}
+
+// record adds the query to the window of its table and operation and calls the hook
+// when the error rate crosses the threshold in either direction
+func (w *errorWatchdog) record(table, operation string, failed bool, now time.Time) {
+	key := budgetKey{table: table, operation: operation}
+	index := now.UnixNano() / int64(w.window/watchdogBuckets)
+
+	w.mu.Lock()
+	win, ok := w.windows[key]
+	if !ok {
+		win = &errorWindow{}
+		w.windows[key] = win
+	}
+	bucket := &win.buckets[index%watchdogBuckets]
+	if bucket.index != index {
+		*bucket = errorBucket{index: index}
+	}
+	bucket.queries++
+	if failed {
+		bucket.errors++
+	}
+	alert := w.alert(key, win, index)
+	changed := alert.Rate >= w.threshold && alert.Queries >= w.minQueries
+	if changed == win.alerting {
+		w.mu.Unlock()
+		return
+	}
+	win.alerting = changed
+	alert.Recovered = !changed
+	w.mu.Unlock()
+
+	// the hook may be slow, it's called without the lock
+	w.hook(alert)
+}
+
+// alert sums buckets of the window ending with the bucket of index
+func (w *errorWatchdog) alert(key budgetKey, win *errorWindow, index int64) ErrorRateAlert {
+	alert := ErrorRateAlert{Table: key.table, Operation: key.operation, Window: w.window}
+	for _, b := range win.buckets {
+		if b.index > index-watchdogBuckets {
+			alert.Queries += b.queries
+			alert.Errors += b.errors
+		}
+	}
+	if alert.Queries > 0 {
+		alert.Rate = float64(alert.Errors) / float64(alert.Queries)
+	}
+	return alert
+}
+
+// alerting returns tables and operations which error rate is over the threshold
+func (w *errorWatchdog) alerting(now time.Time) []ErrorRateAlert {
+	index := now.UnixNano() / int64(w.window/watchdogBuckets)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	alerts := []ErrorRateAlert{}
+	for key, win := range w.windows {
+		if win.alerting {
+			alerts = append(alerts, w.alert(key, win, index))
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Table != alerts[j].Table {
+			return alerts[i].Table < alerts[j].Table
+		}
+		return alerts[i].Operation < alerts[j].Operation
+	})
+	return alerts
+}
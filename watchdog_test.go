@@ -0,0 +1,46 @@
+package otgorm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestErrorWatchdog(t *testing.T) {
+	var alerts []ErrorRateAlert
+	w := newErrorWatchdog(10*time.Second, 0.5, 4, func(alert ErrorRateAlert) {
+		alerts = append(alerts, alert)
+	})
+	now := time.Unix(1000, 0)
+
+	// too few queries to alert
+	w.record("users", "SELECT", true, now)
+	w.record("users", "SELECT", true, now)
+	w.record("users", "SELECT", false, now)
+	if len(alerts) != 0 {
+		t.Fatalf("rate of 3 queries shouldn't alert but alerts are %v", alerts)
+	}
+	w.record("users", "SELECT", false, now.Add(time.Second))
+	w.record("users", "UPDATE", true, now.Add(time.Second))
+	if len(alerts) != 1 || alerts[0].Table != "users" || alerts[0].Operation != "SELECT" || alerts[0].Errors != 2 ||
+		alerts[0].Queries != 4 || alerts[0].Recovered {
+		t.Fatalf("rate 0.5 should alert once but alerts are %+v", alerts)
+	}
+	w.record("users", "SELECT", true, now.Add(2*time.Second))
+	if len(alerts) != 1 {
+		t.Fatalf("alerting rate should alert once but alerts are %+v", alerts)
+	}
+	if alerting := w.alerting(now.Add(2 * time.Second)); len(alerting) != 1 || alerting[0].Errors != 3 {
+		t.Errorf("users SELECT should be alerting but alerting are %+v", alerting)
+	}
+
+	// errors slide out of the window
+	for i := 0; i < 4; i++ {
+		w.record("users", "SELECT", false, now.Add(11*time.Second))
+	}
+	if len(alerts) != 2 || !alerts[1].Recovered || alerts[1].Errors != 1 {
+		t.Fatalf("rate below threshold should recover but alerts are %+v", alerts)
+	}
+	if alerting := w.alerting(now.Add(11 * time.Second)); len(alerting) != 0 {
+		t.Errorf("nothing should be alerting but alerting are %+v", alerting)
+	}
+}